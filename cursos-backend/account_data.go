@@ -0,0 +1,335 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// DeleteAccountRequest confirma la eliminación de la cuenta. Las cuentas sin contraseña
+// (creadas con OAuth o enlace mágico) confirman escribiendo su email.
+type DeleteAccountRequest struct {
+	Password     string `json:"password"`
+	Confirmacion string `json:"confirmacion"`
+}
+
+// accountDeletionGrace devuelve el plazo antes del borrado definitivo (por defecto 14 días).
+// Iniciar sesión durante el plazo cancela la eliminación.
+func accountDeletionGrace() time.Duration {
+	return parseDurationEnv("ACCOUNT_DELETION_GRACE", 14*24*time.Hour)
+}
+
+// exportMyData genera un ZIP con todos los datos personales del usuario
+func exportMyData(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	if !checkRateLimit(c, ruleDataExport, fmt.Sprint(user.ID)) {
+		return
+	}
+
+	var pagos []Pago
+	var progresoCursos []ProgresoUsuario
+	var progresoCapitulos []ProgresoCapitulo
+	var mensajes []ContactMessage
+	var actividad []ActivityLog
+	var identidades []UsuarioIdentidad
+	var notificaciones []Notificacion
+	var valoraciones []Valoracion
+
+	consultas := []struct {
+		nombre string
+		query  *gorm.DB
+	}{
+		{"pagos", db.Where("usuario_id = ?", user.ID).Order("created_at").Find(&pagos)},
+		{"progreso", db.Where("usuario_id = ?", user.ID).Find(&progresoCursos)},
+		{"progreso de capítulos", db.Where("usuario_id = ?", user.ID).Find(&progresoCapitulos)},
+		{"mensajes", db.Preload("Respuestas", func(db *gorm.DB) *gorm.DB {
+			return db.Order("enviado_en, id")
+		}).Preload("Respuestas.Adjuntos").Preload("Adjuntos", "respuesta_id IS NULL").Where("email = ?", user.Email).Order("created_at").Find(&mensajes)},
+		{"actividad", db.Where("user_id = ?", user.ID).Order("created_at").Find(&actividad)},
+		{"identidades", db.Where("usuario_id = ?", user.ID).Find(&identidades)},
+		{"notificaciones", db.Where("usuario_id = ?", user.ID).Order("created_at").Find(&notificaciones)},
+		{"valoraciones", db.Preload("Historial").Where("usuario_id = ?", user.ID).Order("created_at").Find(&valoraciones)},
+	}
+	for _, q := range consultas {
+		if q.query.Error != nil {
+			log.Printf("Error al exportar %s del usuario %d: %v", q.nombre, user.ID, q.query.Error)
+			SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	user.Password = ""
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	ficheros := []struct {
+		nombre string
+		datos  interface{}
+	}{
+		{"perfil.json", user},
+		{"pagos.json", pagos},
+		{"progreso_cursos.json", progresoCursos},
+		{"progreso_capitulos.json", progresoCapitulos},
+		{"mensajes_contacto.json", mensajes},
+		{"registro_actividad.json", actividad},
+		{"identidades_vinculadas.json", identidades},
+		{"notificaciones.json", notificaciones},
+		{"valoraciones.json", valoraciones},
+		{"preferencias_notificacion.json", loadNotificationPreferences(user.ID)},
+	}
+	for _, f := range ficheros {
+		if err := writeZipJSON(zw, f.nombre, f.datos); err != nil {
+			log.Printf("Error al generar exportación del usuario %d: %v", user.ID, err)
+			SendErrorResponse(c, ErrServerError, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := writeZipProfileImage(zw, user.ImageURL); err != nil {
+		// La imagen es opcional: si no se puede leer, se exporta el resto
+		log.Printf("No se pudo incluir la imagen de perfil del usuario %d: %v", user.ID, err)
+	}
+
+	if err := zw.Close(); err != nil {
+		SendErrorResponse(c, ErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "data_export", "Exportación de datos personales")
+
+	filename := fmt.Sprintf("mis-datos-%s.zip", time.Now().Format("2006-01-02"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
+
+// writeZipJSON añade un fichero JSON legible al ZIP
+func writeZipJSON(zw *zip.Writer, nombre string, datos interface{}) error {
+	w, err := zw.Create(nombre)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(datos)
+}
+
+// writeZipProfileImage copia la imagen de perfil si está almacenada en el servidor
+func writeZipProfileImage(zw *zip.Writer, imageURL string) error {
+	path, ok := profileImagePath(imageURL)
+	if !ok {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w, err := zw.Create("imagen_perfil" + filepath.Ext(path))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// profileImagePath traduce la URL pública de la imagen de perfil a su ruta en disco
+func profileImagePath(imageURL string) (string, bool) {
+	const prefijo = "/static/profiles/"
+	if !strings.HasPrefix(imageURL, prefijo) {
+		return "", false
+	}
+	nombre := filepath.Base(strings.TrimPrefix(imageURL, prefijo))
+	if nombre == "." || nombre == "/" {
+		return "", false
+	}
+	return filepath.Join(".", "static", "profiles", nombre), true
+}
+
+// deleteMyAccount programa la eliminación de la cuenta tras el periodo de gracia
+func deleteMyAccount(c *gin.Context) {
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	if user.Password != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+			SendErrorResponse(c, errors.New("contraseña incorrecta"), http.StatusBadRequest)
+			return
+		}
+	} else if !strings.EqualFold(strings.TrimSpace(req.Confirmacion), user.Email) {
+		SendErrorResponse(c, errors.New("escribe tu email para confirmar la eliminación"), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	programada := now.Add(accountDeletionGrace())
+	if err := db.Model(&user).Updates(map[string]interface{}{
+		"eliminacion_solicitada_en": now,
+		"eliminacion_programada":    programada,
+	}).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	// Se cierran todas las sesiones y se revocan las claves API
+	if err := revokeAllSessions(user.ID, "account_deletion", ""); err != nil {
+		log.Printf("Error al revocar sesiones del usuario %d: %v", user.ID, err)
+	}
+	if err := db.Model(&APIKey{}).Where("usuario_id = ? AND revoked_at IS NULL", user.ID).
+		Update("revoked_at", now).Error; err != nil {
+		log.Printf("Error al revocar claves API del usuario %d: %v", user.ID, err)
+	}
+
+	logActivity(c, user.ID, "account_deletion_requested",
+		fmt.Sprintf("Eliminación de cuenta programada para %s", programada.Format(time.RFC3339)))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":                true,
+		"message":                fmt.Sprintf("Tu cuenta se eliminará definitivamente en %s. Si inicias sesión antes, la eliminación se cancelará.", formatDuracion(accountDeletionGrace())),
+		"eliminacion_programada": programada,
+	})
+}
+
+// cancelAccountDeletion anula una eliminación pendiente; se llama al iniciar sesión
+func cancelAccountDeletion(c *gin.Context, user Usuario) {
+	if user.EliminacionProgramada == nil {
+		return
+	}
+	if err := db.Model(&Usuario{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"eliminacion_solicitada_en": nil,
+		"eliminacion_programada":    nil,
+	}).Error; err != nil {
+		log.Printf("Error al cancelar la eliminación de la cuenta %d: %v", user.ID, err)
+		return
+	}
+	logActivity(c, user.ID, "account_deletion_cancelled", "Eliminación de cuenta cancelada al iniciar sesión")
+}
+
+// purgeUser borra definitivamente al usuario y sus datos personales. Los pagos se
+// conservan por obligaciones contables, pero dejan de estar asociados a la persona.
+func purgeUser(user Usuario) error {
+	var adjuntos []MensajeAdjunto
+	if err := db.Where("mensaje_id IN (SELECT id FROM contact_messages WHERE email = ?)", user.Email).
+		Find(&adjuntos).Error; err != nil {
+		return fmt.Errorf("error al buscar adjuntos: %v", err)
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Pago{}).Where("usuario_id = ?", user.ID).Update("usuario_id", nil).Error; err != nil {
+			return fmt.Errorf("error al anonimizar pagos: %v", err)
+		}
+		// Las conversaciones que atendió siguen existiendo, sin autor ni asignación
+		if err := tx.Model(&MensajeRespuesta{}).Where("autor_id = ?", user.ID).Update("autor_id", nil).Error; err != nil {
+			return fmt.Errorf("error al anonimizar respuestas: %v", err)
+		}
+		if err := tx.Model(&ContactMessage{}).Where("asignado_id = ?", user.ID).Update("asignado_id", nil).Error; err != nil {
+			return fmt.Errorf("error al desasignar mensajes: %v", err)
+		}
+		if err := tx.Model(&RespuestaPredefinida{}).Where("creada_por_id = ?", user.ID).Update("creada_por_id", nil).Error; err != nil {
+			return fmt.Errorf("error al anonimizar respuestas predefinidas: %v", err)
+		}
+		if err := tx.Model(&Valoracion{}).Where("moderada_por_id = ?", user.ID).Update("moderada_por_id", nil).Error; err != nil {
+			return fmt.Errorf("error al anonimizar la moderación de valoraciones: %v", err)
+		}
+
+		borrados := []struct {
+			modelo interface{}
+			where  string
+			valor  interface{}
+		}{
+			{&ValoracionHistorial{}, "valoracion_id IN (SELECT id FROM valoraciones WHERE usuario_id = ?)", user.ID},
+			{&Valoracion{}, "usuario_id = ?", user.ID},
+			{&ProgresoCapitulo{}, "usuario_id = ?", user.ID},
+			{&ProgresoUsuario{}, "usuario_id = ?", user.ID},
+			{&Sesion{}, "usuario_id = ?", user.ID},
+			{&APIKey{}, "usuario_id = ?", user.ID},
+			{&UsuarioIdentidad{}, "usuario_id = ?", user.ID},
+			{&CodigoRecuperacion{}, "usuario_id = ?", user.ID},
+			{&EnlaceMagico{}, "usuario_id = ?", user.ID},
+			{&OAuthEstado{}, "usuario_id = ?", user.ID},
+			{&Invitacion{}, "usuario_id = ?", user.ID},
+			{&Notificacion{}, "usuario_id = ?", user.ID},
+			{&PreferenciasNotificacion{}, "usuario_id = ?", user.ID},
+			{&ActivityLog{}, "user_id = ?", user.ID},
+			{&PasswordReset{}, "email = ?", user.Email},
+			{&MensajeAdjunto{}, "mensaje_id IN (SELECT id FROM contact_messages WHERE email = ?)", user.Email},
+			{&MensajeRespuesta{}, "mensaje_id IN (SELECT id FROM contact_messages WHERE email = ?)", user.Email},
+			{&ContactMessage{}, "email = ?", user.Email},
+			{&EmailOutbox{}, "destinatario = ?", user.Email},
+		}
+		for _, b := range borrados {
+			if err := tx.Unscoped().Where(b.where, b.valor).Delete(b.modelo).Error; err != nil {
+				return fmt.Errorf("error al borrar %T: %v", b.modelo, err)
+			}
+		}
+
+		return tx.Delete(&Usuario{}, user.ID).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if path, ok := profileImagePath(user.ImageURL); ok {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("No se pudo borrar la imagen de perfil %s: %v", path, err)
+		}
+	}
+	for _, a := range adjuntos {
+		if err := os.Remove(a.Ruta); err != nil && !os.IsNotExist(err) {
+			log.Printf("No se pudo borrar el adjunto %s: %v", a.Ruta, err)
+		}
+	}
+	return nil
+}
+
+// startAccountDeletionWorker borra periódicamente las cuentas cuyo plazo de gracia ha vencido
+func startAccountDeletionWorker() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		purgeScheduledAccounts()
+		<-ticker.C
+	}
+}
+
+func purgeScheduledAccounts() {
+	var usuarios []Usuario
+	if err := db.Where("eliminacion_programada IS NOT NULL AND eliminacion_programada <= ?", time.Now()).
+		Find(&usuarios).Error; err != nil {
+		log.Printf("Error al buscar cuentas pendientes de eliminación: %v", err)
+		return
+	}
+
+	for _, u := range usuarios {
+		if err := purgeUser(u); err != nil {
+			log.Printf("Error al eliminar la cuenta %d: %v", u.ID, err)
+			continue
+		}
+		log.Printf("Cuenta %d eliminada definitivamente tras el periodo de gracia", u.ID)
+	}
+}
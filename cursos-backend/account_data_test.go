@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPurgeUser comprueba que al borrar una cuenta los pagos se conservan anonimizados y
+// no queda ningún dato personal: conversaciones, adjuntos (también en disco),
+// valoraciones, sesiones ni registros de actividad
+func TestPurgeUser(t *testing.T) {
+	useTestDB(t)
+	user := createTestUser(t, RolUser)
+
+	curso := Curso{Titulo: "Curso de prueba"}
+	if err := db.Create(&curso).Error; err != nil {
+		t.Fatal(err)
+	}
+	pago := Pago{UsuarioID: &user.ID, CursoID: curso.ID, Monto: 29.99, Metodo: "tarjeta", Estado: "aprobado"}
+	if err := db.Create(&pago).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Delete(&Pago{}, pago.ID)
+		db.Delete(&Curso{}, curso.ID)
+	})
+
+	now := time.Now()
+	hilo := ContactMessage{Name: user.Nombre, Email: user.Email, Message: "Hola", Estado: HiloAbierto, UltimaActividad: &now}
+	if err := db.Create(&hilo).Error; err != nil {
+		t.Fatal(err)
+	}
+	respuesta := MensajeRespuesta{MensajeID: hilo.ID, Direccion: RespuestaEntrante, Cuerpo: "Sigo con el problema",
+		EnviadoEn: now, EstadoEntrega: EntregaRecibido}
+	if err := db.Create(&respuesta).Error; err != nil {
+		t.Fatal(err)
+	}
+	ruta := filepath.Join(t.TempDir(), "captura.png")
+	if err := os.WriteFile(ruta, []byte("png"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	adjunto := MensajeAdjunto{MensajeID: hilo.ID, RespuestaID: &respuesta.ID, Nombre: "captura.png", Ruta: ruta}
+	if err := db.Create(&adjunto).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	valoracion := Valoracion{UsuarioID: user.ID, CursoID: curso.ID, Puntuacion: 5, Comentario: "Muy bueno"}
+	if err := db.Create(&valoracion).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&ValoracionHistorial{ValoracionID: valoracion.ID, Puntuacion: 4}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createSession(testContext(), user); err != nil {
+		t.Fatal(err)
+	}
+	logActivity(testContext(), user.ID, "login", "Inicio de sesión")
+
+	if err := purgeUser(user); err != nil {
+		t.Fatalf("purgeUser: %v", err)
+	}
+
+	var guardado Pago
+	if err := db.First(&guardado, pago.ID).Error; err != nil {
+		t.Fatalf("el pago ha desaparecido: %v", err)
+	}
+	if guardado.UsuarioID != nil {
+		t.Errorf("pagos.usuario_id = %d, quiero NULL", *guardado.UsuarioID)
+	}
+
+	restos := []struct {
+		nombre string
+		modelo interface{}
+		where  string
+		valor  interface{}
+	}{
+		{"usuario", &Usuario{}, "id = ?", user.ID},
+		{"conversaciones", &ContactMessage{}, "email = ?", user.Email},
+		{"respuestas", &MensajeRespuesta{}, "mensaje_id = ?", hilo.ID},
+		{"adjuntos", &MensajeAdjunto{}, "mensaje_id = ?", hilo.ID},
+		{"valoraciones", &Valoracion{}, "usuario_id = ?", user.ID},
+		{"historial de valoraciones", &ValoracionHistorial{}, "valoracion_id = ?", valoracion.ID},
+		{"sesiones", &Sesion{}, "usuario_id = ?", user.ID},
+		{"actividad", &ActivityLog{}, "user_id = ?", user.ID},
+	}
+	for _, r := range restos {
+		var n int64
+		if err := db.Unscoped().Model(r.modelo).Where(r.where, r.valor).Count(&n).Error; err != nil {
+			t.Fatalf("%s: %v", r.nombre, err)
+		}
+		if n != 0 {
+			t.Errorf("quedan %d filas de %s", n, r.nombre)
+		}
+	}
+	if _, err := os.Stat(ruta); !os.IsNotExist(err) {
+		t.Errorf("el adjunto sigue en disco: %v", err)
+	}
+}
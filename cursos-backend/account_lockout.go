@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// loginMaxFailures devuelve los intentos fallidos permitidos antes de bloquear la cuenta
+func loginMaxFailures() int {
+	return parseIntEnv("LOGIN_MAX_FAILURES", 5)
+}
+
+// loginLockoutDuration devuelve cuánto dura el bloqueo temporal (por defecto 15 minutos)
+func loginLockoutDuration() time.Duration {
+	return parseDurationEnv("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+}
+
+func parseIntEnv(key string, defaultValue int) int {
+	value := getEnv(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Printf("Advertencia: valor inválido para %s (%q), usando %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+// loginDelay calcula el retraso progresivo tras un intento fallido: se duplica en cada
+// intento a partir de 500ms, hasta un máximo de 8 segundos
+func loginDelay(intentos int) time.Duration {
+	if intentos <= 0 {
+		return 0
+	}
+	delay := time.Duration(float64(500*time.Millisecond) * math.Pow(2, float64(intentos-1)))
+	if max := 8 * time.Second; delay > max {
+		return max
+	}
+	return delay
+}
+
+// accountLockedFor devuelve el tiempo que queda de bloqueo, o 0 si la cuenta no está bloqueada
+func accountLockedFor(user Usuario) time.Duration {
+	if user.LockedUntil == nil {
+		return 0
+	}
+	if restante := time.Until(*user.LockedUntil); restante > 0 {
+		return restante
+	}
+	return 0
+}
+
+// sendAccountLockedResponse responde 423 indicando cuándo se puede volver a intentar. Solo
+// se usa cuando la petición ya prueba la identidad (token pendiente, enlace, proveedor);
+// el login con contraseña responde como a unas credenciales incorrectas.
+func sendAccountLockedResponse(c *gin.Context, restante time.Duration) {
+	segundos := int(math.Ceil(restante.Seconds()))
+	c.Header("Retry-After", fmt.Sprint(segundos))
+	c.JSON(http.StatusLocked, gin.H{
+		"success":     false,
+		"error":       ErrAccountLocked.Error(),
+		"retry_after": segundos,
+	})
+	c.Abort()
+}
+
+// registerFailedLogin suma un intento fallido y bloquea la cuenta al llegar al máximo.
+// Devuelve el número de intentos fallidos consecutivos.
+func registerFailedLogin(c *gin.Context, user Usuario) int {
+	if err := db.Model(&Usuario{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"failed_login_attempts": gorm.Expr("failed_login_attempts + 1"),
+		"last_failed_login":     time.Now(),
+	}).Error; err != nil {
+		log.Printf("Error al registrar intento fallido para usuario %d: %v", user.ID, err)
+		return 0
+	}
+
+	var actualizado Usuario
+	if err := db.Select("id", "failed_login_attempts").First(&actualizado, user.ID).Error; err != nil {
+		return 0
+	}
+	intentos := actualizado.FailedLoginAttempts
+
+	if intentos < loginMaxFailures() {
+		return intentos
+	}
+
+	// Bloqueo temporal: el contador vuelve a cero para el siguiente periodo
+	duracion := loginLockoutDuration()
+	hasta := time.Now().Add(duracion)
+	result := db.Model(&Usuario{}).
+		Where("id = ? AND failed_login_attempts >= ?", user.ID, loginMaxFailures()).
+		Updates(map[string]interface{}{"locked_until": hasta, "failed_login_attempts": 0})
+	if result.Error != nil {
+		log.Printf("Error al bloquear la cuenta del usuario %d: %v", user.ID, result.Error)
+		return intentos
+	}
+	if result.RowsAffected == 0 {
+		// Otra petición concurrente ya aplicó el bloqueo
+		return intentos
+	}
+
+	log.Printf("Cuenta bloqueada. Usuario ID: %d, IP: %s, hasta: %s", user.ID, c.ClientIP(), hasta.Format(time.RFC3339))
+	logActivity(c, user.ID, "account_locked",
+		fmt.Sprintf("Cuenta bloqueada %v tras %d intentos fallidos desde IP %s", duracion, intentos, c.ClientIP()))
+
+	ip := c.ClientIP()
+	go func() {
+		if err := sendAccountLockedEmail(user, duracion, ip); err != nil {
+			log.Printf("Error al enviar aviso de bloqueo a %s: %v", user.Email, err)
+		}
+	}()
+
+	return intentos
+}
+
+// resetFailedLogins limpia los intentos fallidos tras un inicio de sesión correcto
+func resetFailedLogins(user Usuario) {
+	if user.FailedLoginAttempts == 0 && user.LockedUntil == nil {
+		return
+	}
+	if err := db.Model(&Usuario{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"locked_until":          nil,
+	}).Error; err != nil {
+		log.Printf("Error al reiniciar intentos fallidos del usuario %d: %v", user.ID, err)
+	}
+}
+
+// sendAccountLockedEmail avisa al usuario de que su cuenta se ha bloqueado
+func sendAccountLockedEmail(user Usuario, duracion time.Duration, ip string) error {
+	frontendURL := getEnv("FRONTEND_URL", "http://localhost:3000")
+
+	return sendTemplateEmail(PlantillaCuentaBloqueada, userEmailLocale(user), EmailMessage{To: []string{user.Email}}, accountLockedEmailData{
+		Name:      user.Nombre,
+		Duracion:  formatDuracion(duracion),
+		IP:        ip,
+		Fecha:     time.Now().Format("02/01/2006 15:04"),
+		ResetLink: frontendURL + "/forgot-password",
+	})
+}
+
+// accountLockedEmailData son los datos de la plantilla del aviso de bloqueo
+type accountLockedEmailData struct {
+	Name      string
+	Duracion  string
+	IP        string
+	Fecha     string
+	ResetLink string
+}
+
+// unlockUser permite a un administrador desbloquear una cuenta antes de tiempo
+func unlockUser(c *gin.Context) {
+	id := c.Param("id")
+
+	var user Usuario
+	if err := db.First(&user, id).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+	if !checkCanManageUser(c, user) {
+		return
+	}
+
+	if err := db.Model(&user).Updates(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"locked_until":          nil,
+	}).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	currentUser, _ := c.Get("user")
+	adminUser := currentUser.(Usuario)
+	logActivity(c, adminUser.ID, "unlock_user",
+		fmt.Sprintf("Admin desbloqueó la cuenta del usuario ID: %s, Email: %s", id, user.Email))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Cuenta desbloqueada correctamente",
+	})
+}
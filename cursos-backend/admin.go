@@ -119,6 +119,9 @@ func updateUser(c *gin.Context) {
 		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
 		return
 	}
+	if !checkCanManageUser(c, user) {
+		return
+	}
 
 	var req struct {
 		Nombre string `json:"nombre"`
@@ -147,8 +150,11 @@ func updateUser(c *gin.Context) {
 		updates["nombre"] = req.Nombre
 	}
 	
-	if req.Email != "" {
+	if req.Email != "" && req.Email != user.Email {
+		// La nueva dirección no está verificada: el titular debe confirmarla
 		updates["email"] = req.Email
+		updates["email_verificado"] = false
+		updates["email_verificado_en"] = nil
 	}
 	
 	if req.Phone != "" {
@@ -199,6 +205,10 @@ func deleteUser(c *gin.Context) {
 		return
 	}
 
+	if !checkCanManageUser(c, user) {
+		return
+	}
+
 	// Evitar que un admin elimine su propia cuenta
 	currentUser, _ := c.Get("user")
 	adminUser := currentUser.(Usuario)
@@ -211,8 +221,8 @@ func deleteUser(c *gin.Context) {
 	userEmail := user.Email
 	userName := user.Nombre
 
-	// Eliminar usuario (borrado en cascada configurado en la base de datos)
-	if err := db.Delete(&user).Error; err != nil {
+	// Eliminar usuario y sus datos personales; los pagos se conservan anonimizados
+	if err := purgeUser(user); err != nil {
 		SendErrorResponse(c, err, http.StatusInternalServerError)
 		return
 	}
@@ -243,14 +253,18 @@ func changeUserRole(c *gin.Context) {
 		return
 	}
 
+	if !checkCanManageUser(c, user) {
+		return
+	}
+
 	var req ChangeRoleRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		SendErrorResponse(c, err, http.StatusBadRequest)
 		return
 	}
 
-	// Validar que el rol sea válido
-	if req.Role != "admin" && req.Role != "user" {
+	// Validar que el rol exista en la tabla de roles
+	if !roleExists(req.Role) {
 		SendErrorResponse(c, errors.New("rol no válido"), http.StatusBadRequest)
 		return
 	}
@@ -258,7 +272,11 @@ func changeUserRole(c *gin.Context) {
 	// Un admin no debería poder quitarse los privilegios a sí mismo
 	currentUser, _ := c.Get("user")
 	adminUser := currentUser.(Usuario)
-	if adminUser.ID == user.ID && req.Role != "admin" {
+	if !canGrantRole(adminUser.Role, req.Role) {
+		SendErrorResponse(c, errors.New("no puedes asignar un rol con permisos que tú no tienes"), http.StatusForbidden)
+		return
+	}
+	if adminUser.ID == user.ID && !hasPermission(req.Role, PermRolesManage) {
 		SendErrorResponse(c, errors.New("no puedes quitarte los privilegios de administrador a ti mismo"), http.StatusBadRequest)
 		return
 	}
@@ -307,6 +325,11 @@ func logActivity(c *gin.Context, userID uint, action, details string) {
 		UserAgent: userAgent,
 		CreatedAt: time.Now(),
 	}
+
+	// Bajo suplantación se guarda también quién actuaba realmente
+	if admin, ok := currentImpersonator(c); ok {
+		activityLog.ImpersonatorID = &admin.ID
+	}
 	
 	if err := db.Create(&activityLog).Error; err != nil {
 		log.Printf("Error al registrar actividad: %v", err)
@@ -281,6 +281,9 @@ type ActivityLogData struct {
 	IP        string    `json:"ip"`
 	UserAgent string    `json:"user_agent"`
 	CreatedAt time.Time `json:"created_at"`
+
+	ImpersonatorID   *uint  `json:"impersonator_id,omitempty"`
+	ImpersonatorName string `json:"impersonator_name,omitempty"`
 }
 
 // getActivityLog devuelve el registro de actividad para el panel de administración
@@ -367,30 +370,21 @@ func getMonthlyRevenue(startDate, endDate time.Time) (float64, error) {
 	return totalRevenue, nil
 }
 
-// getAverageRating obtiene la valoración media de los cursos en un rango de fechas
-// Nota: Como no se tiene una tabla de valoraciones definida en los archivos proporcionados,
-// crearemos una consulta que podría funcionar si existiera dicha tabla.
-// En este caso, usaremos un valor predeterminado o se podría implementar cuando se tenga la tabla.
+// getAverageRating obtiene la valoración media de las valoraciones visibles
+// publicadas en un rango de fechas (0 si no hay ninguna)
 func getAverageRating(startDate, endDate time.Time) (float64, error) {
-	// Como no hay tabla de valoraciones en los archivos proporcionados, 
-	// podemos implementar una lógica alternativa o usar un valor predeterminado
-	
-	// Versión simulada - se podría implementar cuando se tenga la tabla de valoraciones
-	var averageRating float64 = 4.5 // Valor predeterminado
-	
-	// La consulta real sería algo como:
-	/*
-	err := db.Table("valoraciones").
+	var averageRating float64
+
+	err := db.Model(&Valoracion{}).
 		Select("COALESCE(AVG(puntuacion), 0) as promedio").
-		Where("created_at BETWEEN ? AND ?", startDate, endDate).
+		Where("oculta = ? AND created_at BETWEEN ? AND ?", false, startDate, endDate).
 		Scan(&averageRating).Error
-	
+
 	if err != nil {
 		return 0, fmt.Errorf("error al calcular valoración media: %v", err)
 	}
-	*/
-	
-	return averageRating, nil
+
+	return roundRating(averageRating), nil
 }
 
 // getCoursesSales obtiene las ventas por curso en un rango de fechas
@@ -796,6 +790,20 @@ func fetchActivityLog(page, limit, userID, action, startDate, endDate string) ([
 			}
 		}
 		
+		// Nombre del admin si la acción se hizo suplantando al usuario
+		impersonatorName := ""
+		if log.ImpersonatorID != nil {
+			name, exists := userNames[*log.ImpersonatorID]
+			if !exists {
+				var admin Usuario
+				if err := db.Select("nombre").Where("id = ?", *log.ImpersonatorID).First(&admin).Error; err == nil {
+					name = admin.Nombre
+					userNames[*log.ImpersonatorID] = name
+				}
+			}
+			impersonatorName = name
+		}
+
 		// Añadir a resultados
 		result = append(result, ActivityLogData{
 			ID:        log.ID,
@@ -806,6 +814,9 @@ func fetchActivityLog(page, limit, userID, action, startDate, endDate string) ([
 			IP:        log.IP,
 			UserAgent: log.UserAgent,
 			CreatedAt: log.CreatedAt,
+
+			ImpersonatorID:   log.ImpersonatorID,
+			ImpersonatorName: impersonatorName,
 		})
 	}
 	
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	apiKeyPrefix         = "ck_"
+	apiKeyDisplayLength  = 11 // "ck_" + 8 caracteres, suficiente para reconocer la clave
+	apiKeyTouchInterval  = time.Minute
+	maxAPIKeysPorUsuario = 25
+	serviceAccountDomain = "service.invalid" // Dominio reservado: nunca recibe correo
+)
+
+// APIKey es una clave personal para integraciones y scripts. Solo se guarda el hash;
+// la clave en claro se muestra una única vez al crearla.
+type APIKey struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UsuarioID   uint       `gorm:"not null;index" json:"usuario_id"`
+	Nombre      string     `gorm:"size:100;not null" json:"nombre"`
+	Prefijo     string     `gorm:"size:20;not null" json:"prefijo"`
+	KeyHash     string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Scopes      string     `gorm:"size:500;not null" json:"-"` // Permisos separados por espacios
+	CreadaPorID uint       `gorm:"not null" json:"creada_por_id"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	LastUsedIP  string     `gorm:"size:50" json:"last_used_ip"`
+	RevokedAt   *time.Time `gorm:"index" json:"revoked_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// ScopeList devuelve los alcances de la clave
+func (k APIKey) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+// HasScope indica si la clave incluye el alcance indicado
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Activa indica si la clave puede usarse
+func (k APIKey) Activa() bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || time.Now().Before(*k.ExpiresAt)
+}
+
+// Estructuras para solicitudes de claves API
+type APIKeyRequest struct {
+	Nombre        string   `json:"nombre" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays int      `json:"expires_in_days" binding:"omitempty,min=1,max=3650"`
+}
+
+type ServiceAccountRequest struct {
+	Nombre string `json:"nombre" binding:"required,max=100"`
+	Role   string `json:"role" binding:"required"`
+}
+
+// apiKeyResponse describe la clave sin exponer el hash
+func apiKeyResponse(k APIKey) gin.H {
+	return gin.H{
+		"id":            k.ID,
+		"usuario_id":    k.UsuarioID,
+		"nombre":        k.Nombre,
+		"prefijo":       k.Prefijo,
+		"scopes":        k.ScopeList(),
+		"activa":        k.Activa(),
+		"creada_por_id": k.CreadaPorID,
+		"expires_at":    k.ExpiresAt,
+		"last_used_at":  k.LastUsedAt,
+		"last_used_ip":  k.LastUsedIP,
+		"revoked_at":    k.RevokedAt,
+		"created_at":    k.CreatedAt,
+	}
+}
+
+// validateAPIKeyScopes comprueba que los alcances existan y que el rol del propietario
+// los tenga: una clave nunca puede hacer más que su usuario
+func validateAPIKeyScopes(role string, scopes []string) ([]string, error) {
+	vistos := make(map[string]bool, len(scopes))
+	resultado := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		s = strings.TrimSpace(s)
+		if s == "" || vistos[s] {
+			continue
+		}
+		if _, ok := permisosDisponibles[s]; !ok {
+			return nil, fmt.Errorf("alcance desconocido: %s", s)
+		}
+		if !hasPermission(role, s) {
+			return nil, fmt.Errorf("el rol '%s' no tiene el permiso %s", role, s)
+		}
+		vistos[s] = true
+		resultado = append(resultado, s)
+	}
+	if len(resultado) == 0 {
+		return nil, errors.New("debes indicar al menos un alcance")
+	}
+	sort.Strings(resultado)
+	return resultado, nil
+}
+
+// issueAPIKey crea una clave para el propietario y devuelve la clave en claro
+func issueAPIKey(owner Usuario, creador Usuario, req APIKeyRequest) (*APIKey, string, error) {
+	scopes, err := validateAPIKeyScopes(owner.Role, req.Scopes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var activas int64
+	if err := db.Model(&APIKey{}).
+		Where("usuario_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", owner.ID, time.Now()).
+		Count(&activas).Error; err != nil {
+		return nil, "", err
+	}
+	if activas >= maxAPIKeysPorUsuario {
+		return nil, "", fmt.Errorf("se ha alcanzado el máximo de %d claves activas", maxAPIKeysPorUsuario)
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return nil, "", err
+	}
+	plain := apiKeyPrefix + token
+
+	key := APIKey{
+		UsuarioID:   owner.ID,
+		Nombre:      strings.TrimSpace(req.Nombre),
+		Prefijo:     plain[:apiKeyDisplayLength],
+		KeyHash:     hashToken(plain),
+		Scopes:      strings.Join(scopes, " "),
+		CreadaPorID: creador.ID,
+	}
+	if req.ExpiresInDays > 0 {
+		expira := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		key.ExpiresAt = &expira
+	}
+
+	if err := db.Create(&key).Error; err != nil {
+		return nil, "", err
+	}
+	return &key, plain, nil
+}
+
+// authenticateAPIKey valida una clave API y registra su uso. El bloqueo por intentos
+// fallidos no se aplica: protege la contraseña, y las claves tienen entropía suficiente.
+func authenticateAPIKey(c *gin.Context, plain string) (*APIKey, *Usuario, error) {
+	if !strings.HasPrefix(plain, apiKeyPrefix) {
+		return nil, nil, ErrInvalidToken
+	}
+
+	var key APIKey
+	if err := db.Where("key_hash = ?", hashToken(plain)).First(&key).Error; err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+	if !key.Activa() {
+		return nil, nil, ErrInvalidToken
+	}
+
+	var user Usuario
+	if err := db.First(&user, key.UsuarioID).Error; err != nil {
+		return nil, nil, ErrUserNotFound
+	}
+
+	touchAPIKey(&key, c.ClientIP())
+	return &key, &user, nil
+}
+
+// touchAPIKey actualiza el último uso como mucho una vez por minuto, o si cambia la IP
+func touchAPIKey(key *APIKey, ip string) {
+	if key.LastUsedAt != nil && time.Since(*key.LastUsedAt) < apiKeyTouchInterval && key.LastUsedIP == ip {
+		return
+	}
+	now := time.Now()
+	if err := db.Model(&APIKey{}).Where("id = ?", key.ID).Updates(map[string]interface{}{
+		"last_used_at": now,
+		"last_used_ip": ip,
+	}).Error; err != nil {
+		log.Printf("Error al registrar uso de la clave API %d: %v", key.ID, err)
+		return
+	}
+	key.LastUsedAt = &now
+	key.LastUsedIP = ip
+}
+
+// currentAPIKey devuelve la clave con la que se autenticó la petición, si la hay
+func currentAPIKey(c *gin.Context) (*APIKey, bool) {
+	value, exists := c.Get("api_key")
+	if !exists {
+		return nil, false
+	}
+	key, ok := value.(*APIKey)
+	return key, ok
+}
+
+// apiKeyAllowedKey es la marca de contexto con la que allowAPIKey habilita la ruta en
+// curso para claves API
+const apiKeyAllowedKey = "api_key_ok"
+
+// allowAPIKey habilita una ruta para claves API. Va antes de authMiddleware, que rechaza
+// las claves en cualquier ruta sin esta marca; si la ruta usa requirePermission, el
+// permiso debe estar además entre los alcances de la clave.
+func allowAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(apiKeyAllowedKey, true)
+		c.Next()
+	}
+}
+
+// routeAllowsAPIKey indica si la ruta en curso admite claves API. Por defecto no: solo
+// las rutas marcadas con allowAPIKey.
+func routeAllowsAPIKey(c *gin.Context) bool {
+	return c.GetBool(apiKeyAllowedKey)
+}
+
+// denyAPIKey impide usar claves API en rutas de gestión de la propia cuenta
+// (contraseña, sesiones, 2FA, claves): solo se permiten con una sesión interactiva
+func denyAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := currentAPIKey(c); ok {
+			SendErrorResponse(c, errors.New("esta operación no está disponible con una clave API"), http.StatusForbidden)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// listMyAPIKeys devuelve las claves del usuario autenticado
+func listMyAPIKeys(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	var keys []APIKey
+	if err := db.Where("usuario_id = ?", user.ID).Order("created_at DESC").Find(&keys).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	data := make([]gin.H, 0, len(keys))
+	for _, k := range keys {
+		data = append(data, apiKeyResponse(k))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// createMyAPIKey crea una clave personal. La clave solo se muestra en esta respuesta.
+func createMyAPIKey(c *gin.Context) {
+	var req APIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	// Las claves no piden segundo factor, así que se exige haberlo configurado si el rol lo requiere
+	if roleRequires2FA(user.Role) && !user.TwoFactorEnabled {
+		SendErrorResponse(c, ErrTwoFactorSetupRequired, http.StatusForbidden)
+		return
+	}
+
+	key, plain, err := issueAPIKey(user, user, req)
+	if err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	logActivity(c, user.ID, "create_api_key",
+		fmt.Sprintf("Clave API '%s' (%s) creada con alcances: %s", key.Nombre, key.Prefijo, key.Scopes))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Clave creada. Guárdala ahora: no se volverá a mostrar.",
+		"api_key": plain,
+		"data":    apiKeyResponse(*key),
+	})
+}
+
+// revokeMyAPIKey revoca una clave propia
+func revokeMyAPIKey(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	revokeAPIKeyWhere(c, user, db.Where("id = ? AND usuario_id = ?", c.Param("id"), user.ID))
+}
+
+// revokeAPIKey permite a un administrador revocar cualquier clave
+func revokeAPIKey(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	adminUser := userValue.(Usuario)
+
+	revokeAPIKeyWhere(c, adminUser, db.Where("id = ?", c.Param("id")))
+}
+
+func revokeAPIKeyWhere(c *gin.Context, actor Usuario, query *gorm.DB) {
+	var key APIKey
+	if err := query.First(&key).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	if key.RevokedAt == nil {
+		if err := db.Model(&key).Update("revoked_at", time.Now()).Error; err != nil {
+			SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	logActivity(c, actor.ID, "revoke_api_key",
+		fmt.Sprintf("Clave API '%s' (%s) del usuario ID %d revocada", key.Nombre, key.Prefijo, key.UsuarioID))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Clave revocada correctamente",
+	})
+}
+
+// listServiceAccounts devuelve las cuentas de servicio
+func listServiceAccounts(c *gin.Context) {
+	var cuentas []Usuario
+	if err := db.Where("es_servicio = ?", true).Order("created_at DESC").Find(&cuentas).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    cuentas,
+	})
+}
+
+// createServiceAccount crea una cuenta sin contraseña ni email real, que solo
+// puede autenticarse con claves API
+func createServiceAccount(c *gin.Context) {
+	var req ServiceAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	userValue, _ := c.Get("user")
+	adminUser := userValue.(Usuario)
+
+	if !roleExists(req.Role) {
+		SendErrorResponse(c, errors.New("rol no válido"), http.StatusBadRequest)
+		return
+	}
+	if !canGrantRole(adminUser.Role, req.Role) {
+		SendErrorResponse(c, errors.New("no puedes asignar un rol con permisos que tú no tienes"), http.StatusForbidden)
+		return
+	}
+
+	now := time.Now()
+	cuenta := Usuario{
+		Nombre:            strings.TrimSpace(req.Nombre),
+		Email:             "service+" + uuid.New().String() + "@" + serviceAccountDomain,
+		Password:          "", // Sin contraseña: el login con contraseña siempre falla
+		Role:              req.Role,
+		EsServicio:        true,
+		EmailVerificado:   true,
+		EmailVerificadoEn: &now,
+	}
+	if err := db.Create(&cuenta).Error; err != nil {
+		log.Printf("Error al crear cuenta de servicio: %v", err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, adminUser.ID, "create_service_account",
+		fmt.Sprintf("Admin creó la cuenta de servicio '%s' (ID: %d) con rol '%s'", cuenta.Nombre, cuenta.ID, cuenta.Role))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Cuenta de servicio creada correctamente",
+		"data":    cuenta,
+	})
+}
+
+// findServiceAccount busca una cuenta de servicio por el parámetro :id
+func findServiceAccount(c *gin.Context) (*Usuario, bool) {
+	var cuenta Usuario
+	if err := db.Where("id = ? AND es_servicio = ?", c.Param("id"), true).First(&cuenta).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return nil, false
+	}
+	return &cuenta, true
+}
+
+// listServiceAccountKeys devuelve las claves de una cuenta de servicio
+func listServiceAccountKeys(c *gin.Context) {
+	cuenta, ok := findServiceAccount(c)
+	if !ok {
+		return
+	}
+
+	var keys []APIKey
+	if err := db.Where("usuario_id = ?", cuenta.ID).Order("created_at DESC").Find(&keys).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	data := make([]gin.H, 0, len(keys))
+	for _, k := range keys {
+		data = append(data, apiKeyResponse(k))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// createServiceAccountKey crea una clave para una cuenta de servicio
+func createServiceAccountKey(c *gin.Context) {
+	var req APIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	userValue, _ := c.Get("user")
+	adminUser := userValue.(Usuario)
+
+	cuenta, ok := findServiceAccount(c)
+	if !ok {
+		return
+	}
+	if !canGrantRole(adminUser.Role, cuenta.Role) {
+		SendErrorResponse(c, errors.New("no puedes crear claves para una cuenta con permisos que tú no tienes"), http.StatusForbidden)
+		return
+	}
+
+	key, plain, err := issueAPIKey(*cuenta, adminUser, req)
+	if err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	logActivity(c, adminUser.ID, "create_api_key",
+		fmt.Sprintf("Admin creó la clave API '%s' (%s) para la cuenta de servicio ID %d con alcances: %s",
+			key.Nombre, key.Prefijo, cuenta.ID, key.Scopes))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Clave creada. Guárdala ahora: no se volverá a mostrar.",
+		"api_key": plain,
+		"data":    apiKeyResponse(*key),
+	})
+}
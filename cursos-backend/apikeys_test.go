@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRouteAllowsAPIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var permitida bool
+	comprobar := func(c *gin.Context) {
+		permitida = routeAllowsAPIKey(c)
+		c.Status(http.StatusNoContent)
+	}
+
+	r := gin.New()
+	r.GET("/sin-permiso", comprobar)
+	r.GET("/solo-permiso", comprobar, requirePermission(PermStatsRead), func(*gin.Context) {})
+	r.GET("/habilitada", allowAPIKey(), comprobar, func(*gin.Context) {})
+	grupo := r.Group("/admin", allowAPIKey(), comprobar, requirePermission(PermAdminPanel))
+	grupo.GET("/stats", func(*gin.Context) {})
+
+	tests := []struct {
+		ruta string
+		want bool
+	}{
+		{"/sin-permiso", false},
+		{"/solo-permiso", false},
+		{"/habilitada", true},
+		{"/admin/stats", true},
+	}
+	for _, tt := range tests {
+		permitida = !tt.want
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.ruta, nil))
+		if permitida != tt.want {
+			t.Errorf("%s: routeAllowsAPIKey = %v, quiero %v", tt.ruta, permitida, tt.want)
+		}
+	}
+}
+
+// TestAPIKeyRejectedOnUnmarkedRoute comprueba que authMiddleware rechaza la clave antes de
+// validarla cuando la ruta no la admite
+func TestAPIKeyRejectedOnUnmarkedRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.GET("/perfil", authMiddleware(), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	req := httptest.NewRequest(http.MethodGet, "/perfil", nil)
+	req.Header.Set("Authorization", "ApiKey cualquiera")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("código = %d, quiero 403", w.Code)
+	}
+}
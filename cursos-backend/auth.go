@@ -17,8 +17,7 @@ import (
 type RegisterRequest struct {
 	Nombre   string `json:"nombre" binding:"required"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
-	Role     string `json:"role"` // Opcional, por defecto será "user"
+	Password string `json:"password" binding:"required"`
 }
 
 type LoginRequest struct {
@@ -27,20 +26,25 @@ type LoginRequest struct {
 }
 
 type AuthResponse struct {
-	Token string  `json:"token"`
-	User  Usuario `json:"user"`
+	Token        string  `json:"token"`
+	RefreshToken string  `json:"refresh_token"`
+	ExpiresIn    int     `json:"expires_in"`
+	User         Usuario `json:"user"`
+
+	// Indica que el rol del usuario exige 2FA y aún no lo ha configurado
+	TwoFactorSetupRequired bool `json:"two_factor_setup_required,omitempty"`
 }
 
 // JWT Claims personalizado
 type Claims struct {
-	UserID uint   `json:"user_id"`
-	Role   string `json:"role"` // Añadimos el rol a los claims
+	UserID    uint   `json:"user_id"`
+	Role      string `json:"role"` // Añadimos el rol a los claims
+	SessionID string `json:"sid"`  // Familia de sesión, permite revocar el token en el servidor
+	Purpose   string `json:"purpose,omitempty"` // Vacío para access tokens; p. ej. "2fa_pending"
+	ImpersonatorID uint `json:"imp,omitempty"` // Admin que actúa en nombre del usuario (suplantación)
 	jwt.RegisteredClaims
 }
 
-// Secret para JWT
-var jwtSecret = []byte(getEnv("JWT_SECRET", "mi_clave_secreta_muy_segura"))
-
 // Controlador para registro de usuarios
 func register(c *gin.Context) {
 	var req RegisterRequest
@@ -49,6 +53,10 @@ func register(c *gin.Context) {
 		return
 	}
 
+	if !checkPasswordPolicy(c, "password", req.Password, req.Email, req.Nombre) {
+		return
+	}
+
 	// Verificar si el email ya existe
 	var existingUser Usuario
 	if result := db.Where("email = ?", req.Email).First(&existingUser); result.Error == nil {
@@ -63,18 +71,13 @@ func register(c *gin.Context) {
 		return
 	}
 
-	// Establecer rol por defecto si no se proporciona
-	role := req.Role
-	if role == "" {
-		role = "user" // Por defecto, todos los usuarios son "user"
-	}
-
-	// Crear nuevo usuario
+	// El registro público siempre crea estudiantes; los roles con más privilegios
+	// solo se conceden desde el panel de administración o mediante invitación
 	user := Usuario{
 		Nombre:   req.Nombre,
 		Email:    req.Email,
 		Password: string(hashedPassword),
-		Role:     role,
+		Role:     RolUser,
 	}
 
 	if result := db.Create(&user); result.Error != nil {
@@ -82,8 +85,24 @@ func register(c *gin.Context) {
 		return
 	}
 
+	go notifyNewStudent(user)
+
+	// Enviar el enlace de verificación; si falla, el usuario puede pedir otro desde su perfil
+	verifyLink, emailError := sendVerificationEmail(user)
+	if emailError != nil {
+		log.Printf("Error al enviar email de verificación a %s: %v", user.Email, emailError)
+	}
+
+	response := gin.H{
+		"message":                     "Usuario registrado correctamente. Revisa tu email para verificar la cuenta.",
+		"email_verification_required": true,
+	}
+	if getEnv("APP_ENV", "development") == "development" && verifyLink != "" {
+		response["verifyLink"] = verifyLink
+	}
+
 	// Usar directamente c.JSON como en el código original que funcionaba
-	c.JSON(http.StatusCreated, gin.H{"message": "Usuario registrado correctamente"})
+	c.JSON(http.StatusCreated, response)
 }
 
 // Controlador para login de usuarios
@@ -94,10 +113,16 @@ func login(c *gin.Context) {
 		return
 	}
 
+	// Limitar intentos por cuenta, independientemente de la IP de origen
+	if !checkRateLimit(c, ruleLoginEmail, req.Email) {
+		return
+	}
+
 	// Buscar usuario por email
 	var user Usuario
 	if result := db.Where("email = ?", req.Email).First(&user); result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			time.Sleep(loginDelay(1))
 			SendErrorResponse(c, ErrInvalidLogin, http.StatusUnauthorized)
 			return
 		}
@@ -105,12 +130,73 @@ func login(c *gin.Context) {
 		return
 	}
 
-	// Verificar contraseña
+	// Con la cuenta bloqueada se responde igual que a unas credenciales incorrectas, para no
+	// revelar que el email existe; el titular ya recibió el aviso de bloqueo por email
+	if accountLockedFor(user) > 0 {
+		log.Printf("Intento de inicio de sesión en cuenta bloqueada. Usuario ID: %d, IP: %s", user.ID, c.ClientIP())
+		time.Sleep(loginDelay(1))
+		SendErrorResponse(c, ErrInvalidLogin, http.StatusUnauthorized)
+		return
+	}
+
+	// Verificar contraseña; cada fallo consecutivo retrasa más la respuesta
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		time.Sleep(loginDelay(registerFailedLogin(c, user)))
 		SendErrorResponse(c, ErrInvalidLogin, http.StatusUnauthorized)
 		return
 	}
 
+	completeLogin(c, user, "password")
+}
+
+// completeLogin finaliza un inicio de sesión cuya primera verificación ya se ha hecho
+// (contraseña, proveedor externo, enlace mágico...). Si el usuario tiene 2FA activado
+// responde con un token pendiente en lugar de abrir la sesión.
+func completeLogin(c *gin.Context, user Usuario, metodo string) {
+	if user.TwoFactorEnabled {
+		pending, err := generatePendingToken(user, metodo)
+		if err != nil {
+			log.Printf("Error al generar token pendiente de 2FA: %v", err)
+			SendErrorResponse(c, errors.New("error al generar token"), http.StatusInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":             true,
+			"two_factor_required": true,
+			"pending_token":       pending,
+			"expires_in":          int(pendingTokenTTL.Seconds()),
+		})
+		return
+	}
+
+	finishLogin(c, user, metodo)
+}
+
+// finishLogin abre la sesión y envía la respuesta estándar de autenticación
+func finishLogin(c *gin.Context, user Usuario, metodo string) {
+	finishLoginConsuming(c, user, metodo, nil)
+}
+
+// finishLoginConsuming es finishLogin con un paso previo (canjear un token de un solo uso)
+// que se ejecuta en la misma transacción que abre la sesión
+func finishLoginConsuming(c *gin.Context, user Usuario, metodo string, antes func(tx *gorm.DB) error) {
+	// Abrir sesión: access token de corta duración + refresh token rotativo
+	pair, err := createSessionWith(c, user, antes)
+	if err != nil {
+		if errors.Is(err, ErrTokenAlreadyUsed) {
+			log.Printf("Token de inicio de sesión reutilizado. Usuario ID: %d, IP: %s", user.ID, c.ClientIP())
+			SendErrorResponse(c, ErrInvalidToken, http.StatusUnauthorized)
+			return
+		}
+		log.Printf("Error al crear sesión: %v", err)
+		SendErrorResponse(c, errors.New("error al generar token"), http.StatusInternalServerError)
+		return
+	}
+
+	resetFailedLogins(user)
+	cancelAccountDeletion(c, user)
+
 	// Actualizar última conexión
 	now := time.Now()
 	if err := db.Model(&user).Update("last_login", now).Error; err != nil {
@@ -118,12 +204,7 @@ func login(c *gin.Context) {
 		log.Printf("Error al actualizar última conexión: %v", err)
 	}
 
-	// Generar token JWT
-	token, err := generateToken(user.ID, user.Role)
-	if err != nil {
-		SendErrorResponse(c, errors.New("error al generar token"), http.StatusInternalServerError)
-		return
-	}
+	logActivity(c, user.ID, "login", "Inicio de sesión ("+metodo+")")
 
 	// No enviar la contraseña en la respuesta
 	user.Password = ""
@@ -133,26 +214,78 @@ func login(c *gin.Context) {
 
 	// Usar el formato original de AuthResponse
 	c.JSON(http.StatusOK, AuthResponse{
-		Token: token,
-		User:  user,
+		Token:                  pair.AccessToken,
+		RefreshToken:           pair.RefreshToken,
+		ExpiresIn:              pair.ExpiresIn,
+		User:                   user,
+		TwoFactorSetupRequired: roleRequires2FA(user.Role) && !user.TwoFactorEnabled,
 	})
 }
 
-// Función para generar token JWT
-func generateToken(userID uint, role string) (string, error) {
-	// Aumentamos el tiempo de expiración para evitar desconexiones frecuentes
-	expirationTime := time.Now().Add(24 * time.Hour)
+// Función para generar token JWT (access token de corta duración ligado a una sesión)
+func generateToken(userID uint, role string, sessionID string) (string, error) {
+	expirationTime := time.Now().Add(accessTokenTTL())
 	claims := &Claims{
-		UserID: userID,
-		Role:   role, // Incluir el rol en el token
+		UserID:    userID,
+		Role:      role, // Incluir el rol en el token
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return signClaims(claims)
+}
+
+// signClaims firma cualquier conjunto de claims con la clave activa del servidor
+func signClaims(claims jwt.Claims) (string, error) {
+	key := keyRing.signingKey()
+	if key == nil {
+		return "", errors.New("no hay clave de firma configurada")
+	}
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// parseClaims valida la firma de un token y rellena los claims indicados.
+// La clave se elige por el kid de la cabecera y debe coincidir con el algoritmo.
+// Con allowExpired se aceptan tokens caducados (p. ej. para cerrar sesión).
+func parseClaims(tokenString string, claims jwt.Claims, allowExpired bool) error {
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key := keyRing.verificationKey(kid)
+		if key == nil || token.Method.Alg() != key.method.Alg() {
+			return nil, ErrInvalidToken
+		}
+		return key.public, nil
+	})
+
+	if err != nil {
+		var validationErr *jwt.ValidationError
+		if allowExpired && errors.As(err, &validationErr) && validationErr.Errors == jwt.ValidationErrorExpired {
+			return nil
+		}
+		return ErrInvalidToken
+	}
+	if !token.Valid {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// parseAccessToken valida un access token de sesión. Los tokens con propósito
+// específico (p. ej. el pendiente de segundo factor) no sirven como access token.
+func parseAccessToken(tokenString string, allowExpired bool) (*Claims, error) {
+	claims := &Claims{}
+	if err := parseClaims(tokenString, claims, allowExpired); err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
 }
 
 // Middleware de autenticación
@@ -168,6 +301,10 @@ func authMiddleware() gin.HandlerFunc {
 
 		// Verificar que el token tenga el formato correcto
 		tokenParts := strings.Split(authHeader, " ")
+		if len(tokenParts) == 2 && tokenParts[0] == "ApiKey" {
+			authenticateWithAPIKey(c, tokenParts[1])
+			return
+		}
 		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
 			SendErrorResponse(c, errors.New("formato de token inválido"), http.StatusUnauthorized)
 			c.Abort()
@@ -177,17 +314,21 @@ func authMiddleware() gin.HandlerFunc {
 		tokenString := tokenParts[1]
 
 		// Validar el token
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
-
-		if err != nil || !token.Valid {
+		claims, err := parseAccessToken(tokenString, false)
+		if err != nil {
 			SendErrorResponse(c, ErrInvalidToken, http.StatusUnauthorized)
 			c.Abort()
 			return
 		}
 
+		// Verificar que la sesión no haya sido revocada (logout, cambio de contraseña, etc.)
+		if claims.SessionID == "" || !isSessionActive(claims.SessionID) {
+			SendErrorResponse(c, ErrSessionRevoked, http.StatusUnauthorized)
+			c.Abort()
+			return
+		}
+		touchSession(claims.SessionID)
+
 		// Verificar si el usuario existe en la base de datos
 		var user Usuario
 		if result := db.First(&user, claims.UserID); result.Error != nil {
@@ -204,96 +345,53 @@ func authMiddleware() gin.HandlerFunc {
 
 		// Añadir el usuario al contexto para que los controladores puedan acceder a él
 		c.Set("user", user)
-
-		c.Next()
-	}
-}
-
-// adminMiddleware verifica si el usuario autenticado tiene rol de administrador
-func adminMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Obtener el usuario del contexto (establecido por authMiddleware)
-		userValue, exists := c.Get("user")
-		if !exists {
-			SendErrorResponse(c, ErrUnauthorized, http.StatusUnauthorized)
-			c.Abort()
-			return
+		c.Set("session_id", claims.SessionID)
+		if claims.ExpiresAt != nil {
+			c.Set("token_expira", claims.ExpiresAt.Time)
 		}
 
-		user, ok := userValue.(Usuario)
-		if !ok {
-			SendErrorResponse(c, errors.New("error al obtener información del usuario"), http.StatusInternalServerError)
-			c.Abort()
+		// Token de suplantación: se valida al admin y se audita cada petición
+		if claims.ImpersonatorID != 0 {
+			admin, err := loadImpersonator(claims)
+			if err != nil {
+				SendErrorResponse(c, ErrSessionRevoked, http.StatusUnauthorized)
+				c.Abort()
+				return
+			}
+			c.Set("impersonator", *admin)
+
+			c.Next()
+			auditImpersonatedRequest(c, user)
 			return
 		}
 
-		// Verificar si el usuario tiene rol de administrador
-		if user.Role != "admin" {
-			// Registrar intento de acceso no autorizado para auditoría de seguridad
-			log.Printf("Intento de acceso a área administrativa por usuario sin permisos. ID: %d, Email: %s, Rol: %s", 
-				user.ID, user.Email, user.Role)
-				
-			SendErrorResponse(c, errors.New("acceso denegado: se requiere rol de administrador"), http.StatusForbidden)
-			c.Abort()
-			return
-		}
-
-		// Verificar adicionalmente en la base de datos para asegurar que el rol no ha cambiado
-		var dbUser Usuario
-		if result := db.Select("role").First(&dbUser, user.ID); result.Error != nil {
-			log.Printf("Error al verificar rol en DB para usuario ID: %d: %v", user.ID, result.Error)
-			SendErrorResponse(c, errors.New("error al verificar permisos de administrador"), http.StatusInternalServerError)
-			c.Abort()
-			return
-		}
-
-		// Comprobar que el rol en la base de datos sigue siendo admin
-		if dbUser.Role != "admin" {
-			log.Printf("Discrepancia de roles detectada. Token: admin, DB: %s para usuario ID: %d", dbUser.Role, user.ID)
-			SendErrorResponse(c, errors.New("acceso denegado: usuario ya no tiene permisos de administrador"), http.StatusForbidden)
-			c.Abort()
-			return
-		}
-
-		// Registrar acceso administrativo exitoso para auditoría
-		log.Printf("Acceso administrativo exitoso. Usuario ID: %d, Email: %s", user.ID, user.Email)
-
 		c.Next()
 	}
 }
 
-// checkAdmin verifica y responde si el usuario tiene rol admin
-func checkAdmin(c *gin.Context) {
-	// Obtener el usuario del contexto (establecido por authMiddleware)
-	userValue, exists := c.Get("user")
-	if !exists {
-		SendErrorResponse(c, ErrUnauthorized, http.StatusUnauthorized)
+// authenticateWithAPIKey completa authMiddleware para el esquema "ApiKey <clave>"
+func authenticateWithAPIKey(c *gin.Context, plain string) {
+	if !routeAllowsAPIKey(c) {
+		SendErrorResponse(c, errors.New("esta operación no está disponible con una clave API"), http.StatusForbidden)
+		c.Abort()
 		return
 	}
 
-	user, ok := userValue.(Usuario)
-	if !ok {
-		SendErrorResponse(c, errors.New("error al obtener información del usuario"), http.StatusInternalServerError)
+	key, user, err := authenticateAPIKey(c, plain)
+	if err != nil {
+		SendErrorResponse(c, err, http.StatusUnauthorized)
+		c.Abort()
 		return
 	}
 
-	// Verificar si el usuario tiene rol de administrador
-	isAdmin := user.Role == "admin"
+	c.Set("user", *user)
+	c.Set("api_key", key)
 
-	// Si no es admin, registrar el intento para auditoría
-	if !isAdmin {
-		log.Printf("Verificación de admin fallida. Usuario ID: %d, Email: %s, Rol: %s", 
-			user.ID, user.Email, user.Role)
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"isAdmin": isAdmin,
-	})
+	c.Next()
 }
 
-// refreshToken extiende la sesión del usuario generando un nuevo token
-func refreshToken(c *gin.Context) {
+// checkAdmin verifica y responde si el usuario tiene acceso al panel y con qué permisos
+func checkAdmin(c *gin.Context) {
 	// Obtener el usuario del contexto (establecido por authMiddleware)
 	userValue, exists := c.Get("user")
 	if !exists {
@@ -307,15 +405,19 @@ func refreshToken(c *gin.Context) {
 		return
 	}
 
-	// Generar nuevo token
-	token, err := generateToken(user.ID, user.Role)
-	if err != nil {
-		SendErrorResponse(c, errors.New("error al renovar el token"), http.StatusInternalServerError)
-		return
+	// Verificar si el usuario tiene acceso al panel de administración
+	isAdmin := hasPermission(user.Role, PermAdminPanel)
+
+	// Si no es admin, registrar el intento para auditoría
+	if !isAdmin {
+		log.Printf("Verificación de admin fallida. Usuario ID: %d, Email: %s, Rol: %s", 
+			user.ID, user.Email, user.Role)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"token": token,
+		"success":     true,
+		"isAdmin":     isAdmin,
+		"role":        user.Role,
+		"permissions": permissionsForRole(user.Role),
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidCannedResponse se devuelve cuando el cuerpo no compila o usa variables desconocidas
+var ErrInvalidCannedResponse = errors.New("la respuesta predefinida no es válida")
+
+// RespuestaPredefinida es una respuesta habitual que los administradores reutilizan al
+// contestar mensajes. El cuerpo admite variables como {{.Name}} o {{.CursoTitulo}}.
+type RespuestaPredefinida struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Titulo      string    `gorm:"size:150;not null" json:"titulo"`
+	Categoria   string    `gorm:"size:50;index" json:"categoria"`
+	Cuerpo      string    `gorm:"type:text;not null" json:"cuerpo"`
+	Usos        int64     `gorm:"not null;default:0" json:"usos"`
+	CreadaPorID *uint     `json:"creada_por_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (RespuestaPredefinida) TableName() string {
+	return "respuestas_predefinidas"
+}
+
+// RespuestaPredefinidaRequest es el cuerpo para crear o editar una respuesta predefinida
+type RespuestaPredefinidaRequest struct {
+	Titulo    string `json:"titulo" binding:"required,max=150"`
+	Categoria string `json:"categoria" binding:"max=50"`
+	Cuerpo    string `json:"cuerpo" binding:"required"`
+}
+
+// macroVariables son las variables disponibles en las respuestas predefinidas, con un
+// valor de ejemplo para validar y previsualizar
+var macroVariables = map[string]string{
+	"Name":             "Ana García",
+	"Email":            "ana@example.com",
+	"CursoTitulo":      "Desarrollo web con Go",
+	"UltimoPagoEstado": "pendiente",
+	"UltimoPagoMonto":  "29.99 EUR",
+	"UltimoPagoMetodo": "paypal",
+	"UltimoPagoFecha":  "01/03/2025",
+	"AdminNombre":      "Equipo de soporte",
+}
+
+// renderMacro ejecuta el cuerpo con las variables. Una variable desconocida es un error
+// para no enviar nunca un "<no value>" al alumno.
+func renderMacro(cuerpo string, data map[string]string) (string, error) {
+	tmpl, err := template.New("respuesta").Option("missingkey=error").Parse(cuerpo)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// macroData reúne los datos del remitente para rellenar la respuesta: su nombre y, si
+// tiene cuenta, su último pago y el curso correspondiente. overrides sustituye cualquiera
+// de ellos.
+func macroData(contacto ContactMessage, admin Usuario, overrides map[string]string) (map[string]string, error) {
+	data := map[string]string{
+		"Name":             contacto.Name,
+		"Email":            contacto.Email,
+		"CursoTitulo":      "",
+		"UltimoPagoEstado": "",
+		"UltimoPagoMonto":  "",
+		"UltimoPagoMetodo": "",
+		"UltimoPagoFecha":  "",
+		"AdminNombre":      admin.Nombre,
+	}
+
+	var usuario Usuario
+	if err := db.Where("email = ?", contacto.Email).First(&usuario).Error; err == nil {
+		var pago Pago
+		err := db.Where("usuario_id = ?", usuario.ID).Order("created_at DESC, id DESC").First(&pago).Error
+		if err == nil {
+			data["UltimoPagoEstado"] = pago.Estado
+			data["UltimoPagoMonto"] = strings.TrimSpace(fmt.Sprintf("%.2f %s", pago.Monto, pago.Moneda))
+			data["UltimoPagoMetodo"] = pago.Metodo
+			data["UltimoPagoFecha"] = pago.CreatedAt.Format("02/01/2006")
+			var curso Curso
+			if db.Select("id", "titulo").First(&curso, pago.CursoID).Error == nil {
+				data["CursoTitulo"] = curso.Titulo
+			}
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	for k, v := range overrides {
+		if _, ok := macroVariables[k]; !ok {
+			return nil, fmt.Errorf("%w: variable desconocida %q", ErrInvalidCannedResponse, k)
+		}
+		data[k] = v
+	}
+	return data, nil
+}
+
+// renderCannedResponse carga la respuesta predefinida y la rellena con los datos del mensaje
+func renderCannedResponse(id uint, contacto ContactMessage, admin Usuario, overrides map[string]string) (*RespuestaPredefinida, string, error) {
+	var r RespuestaPredefinida
+	if err := db.First(&r, id).Error; err != nil {
+		return nil, "", err
+	}
+	data, err := macroData(contacto, admin, overrides)
+	if err != nil {
+		return nil, "", err
+	}
+	texto, err := renderMacro(r.Cuerpo, data)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidCannedResponse, err)
+	}
+	if texto == "" {
+		return nil, "", fmt.Errorf("%w: el texto resultante está vacío", ErrInvalidCannedResponse)
+	}
+	return &r, texto, nil
+}
+
+func validateCannedResponseRequest(c *gin.Context, req RespuestaPredefinidaRequest) bool {
+	if strings.TrimSpace(req.Titulo) == "" || strings.TrimSpace(req.Cuerpo) == "" {
+		SendErrorResponse(c, ErrMissingFields, http.StatusBadRequest)
+		return false
+	}
+	if _, err := renderMacro(req.Cuerpo, macroVariables); err != nil {
+		SendValidationErrorResponse(c, ErrInvalidCannedResponse, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// listCannedResponses devuelve las respuestas predefinidas, las más usadas primero
+func listCannedResponses(c *gin.Context) {
+	query := db.Model(&RespuestaPredefinida{})
+	if categoria := c.Query("categoria"); categoria != "" {
+		query = query.Where("categoria = ?", categoria)
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		query = query.Where("(titulo LIKE ? OR cuerpo LIKE ?)", "%"+q+"%", "%"+q+"%")
+	}
+
+	var respuestas []RespuestaPredefinida
+	if err := query.Order("usos DESC, titulo").Find(&respuestas).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	variables := make([]string, 0, len(macroVariables))
+	for k := range macroVariables {
+		variables = append(variables, k)
+	}
+	sort.Strings(variables)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"data":      respuestas,
+		"variables": variables,
+	})
+}
+
+func getCannedResponse(c *gin.Context) {
+	var r RespuestaPredefinida
+	if err := db.First(&r, c.Param("id")).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+	SendSuccessResponse(c, gin.H{"data": r})
+}
+
+func createCannedResponse(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	var req RespuestaPredefinidaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+	if !validateCannedResponseRequest(c, req) {
+		return
+	}
+
+	r := RespuestaPredefinida{
+		Titulo:      strings.TrimSpace(req.Titulo),
+		Categoria:   strings.TrimSpace(req.Categoria),
+		Cuerpo:      req.Cuerpo,
+		CreadaPorID: &user.ID,
+	}
+	if err := db.Create(&r).Error; err != nil {
+		log.Printf("Error al crear la respuesta predefinida: %v", err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "canned_response_create", fmt.Sprintf("Respuesta predefinida %d creada: %s", r.ID, r.Titulo))
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": r})
+}
+
+func updateCannedResponse(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	var r RespuestaPredefinida
+	if err := db.First(&r, c.Param("id")).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	var req RespuestaPredefinidaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+	if !validateCannedResponseRequest(c, req) {
+		return
+	}
+
+	r.Titulo = strings.TrimSpace(req.Titulo)
+	r.Categoria = strings.TrimSpace(req.Categoria)
+	r.Cuerpo = req.Cuerpo
+	if err := db.Model(&r).Updates(map[string]interface{}{
+		"titulo":    r.Titulo,
+		"categoria": r.Categoria,
+		"cuerpo":    r.Cuerpo,
+	}).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "canned_response_update", fmt.Sprintf("Respuesta predefinida %d actualizada: %s", r.ID, r.Titulo))
+	SendSuccessResponse(c, gin.H{"data": r})
+}
+
+func deleteCannedResponse(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	var r RespuestaPredefinida
+	if err := db.First(&r, c.Param("id")).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+	if err := db.Delete(&r).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "canned_response_delete", fmt.Sprintf("Respuesta predefinida %d eliminada: %s", r.ID, r.Titulo))
+	SendSuccessResponse(c, gin.H{"message": "Respuesta predefinida eliminada correctamente"})
+}
+
+// previewCannedResponse muestra la respuesta rellenada con los datos de un mensaje, para
+// que el administrador la revise o la edite antes de enviarla
+func previewCannedResponse(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	var req struct {
+		MensajeID uint              `json:"mensaje_id" binding:"required"`
+		Variables map[string]string `json:"variables"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	var contacto ContactMessage
+	if err := db.First(&contacto, req.MensajeID).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		SendErrorResponse(c, ErrInvalidRequest, http.StatusBadRequest)
+		return
+	}
+	_, texto, err := renderCannedResponse(uint(id), contacto, user, req.Variables)
+	if err != nil {
+		sendCannedResponseError(c, err)
+		return
+	}
+	SendSuccessResponse(c, gin.H{"data": gin.H{"texto": texto}})
+}
+
+// sendCannedResponseError traduce los errores de renderCannedResponse a la respuesta HTTP
+func sendCannedResponseError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+	case errors.Is(err, ErrInvalidCannedResponse):
+		SendValidationErrorResponse(c, ErrInvalidCannedResponse, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error al preparar la respuesta predefinida: %v", err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+// mysqlErrDuplicateEntry es el código de error de MySQL para una clave única repetida
+const mysqlErrDuplicateEntry = 1062
+
+// ErrTokenAlreadyUsed indica que un token de un solo uso ya se había canjeado
+var ErrTokenAlreadyUsed = errors.New("el token ya se ha utilizado")
+
+// TokenConsumido registra los tokens de un solo uso ya canjeados (jti del token pendiente
+// de 2FA, tickets del stream SSE...). El índice único hace que el segundo canje falle en
+// cualquier instancia, también tras un reinicio.
+type TokenConsumido struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Clave     string    `gorm:"size:100;not null;uniqueIndex" json:"clave"` // Propósito e identificador, p. ej. "2fa_pending:<jti>"
+	ExpiraEn  time.Time `gorm:"not null;index" json:"expira_en"`            // A partir de aquí el token ya no es válido y la fila se puede borrar
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (TokenConsumido) TableName() string {
+	return "tokens_consumidos"
+}
+
+// consumeToken marca el token como usado dentro de tx. Devuelve ErrTokenAlreadyUsed si
+// ya se había canjeado; al ir en la misma transacción que la acción que autoriza, un
+// fallo posterior deshace también el canje.
+func consumeToken(tx *gorm.DB, clave string, expiraEn time.Time) error {
+	err := tx.Create(&TokenConsumido{Clave: clave, ExpiraEn: expiraEn}).Error
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry {
+		return ErrTokenAlreadyUsed
+	}
+	return err
+}
+
+// startConsumedTokenCleanup borra periódicamente los tokens que ya han caducado
+func startConsumedTokenCleanup() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := db.Where("expira_en < ?", time.Now()).Delete(&TokenConsumido{}).Error; err != nil {
+			log.Printf("Error al limpiar tokens consumidos: %v", err)
+		}
+	}
+}
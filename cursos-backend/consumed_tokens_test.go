@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestConsumeToken(t *testing.T) {
+	useTestDB(t)
+	clave := "test:" + randomHex(8)
+	t.Cleanup(func() { db.Where("clave = ?", clave).Delete(&TokenConsumido{}) })
+	expira := time.Now().Add(time.Minute)
+
+	// Si la acción que autoriza el token falla, el canje se deshace
+	errAccion := errors.New("fallo posterior")
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := consumeToken(tx, clave, expira); err != nil {
+			return err
+		}
+		return errAccion
+	})
+	if !errors.Is(err, errAccion) {
+		t.Fatalf("transacción: %v", err)
+	}
+
+	if err := consumeToken(db, clave, expira); err != nil {
+		t.Fatalf("primer canje: %v", err)
+	}
+	if err := consumeToken(db, clave, expira); !errors.Is(err, ErrTokenAlreadyUsed) {
+		t.Fatalf("segundo canje: err = %v, quiero ErrTokenAlreadyUsed", err)
+	}
+}
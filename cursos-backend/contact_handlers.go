@@ -1,13 +1,10 @@
 package main
 
 import (
-	"bytes"
 	"log"
 	"net/http"
-	"net/smtp"
-	"os"
-	"path/filepath"
-	"text/template"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -18,16 +15,39 @@ type ContactRequest struct {
 	Email   string `json:"email" binding:"required,email"`
 	Phone   string `json:"phone"`
 	Message string `json:"message" binding:"required"`
+
+	// Defensas contra spam: el honeypot va oculto en el formulario y el token se pide
+	// a GET /api/contact/token al cargarlo
+	Website   string `json:"website"`
+	FormToken string `json:"form_token"`
 }
 
+// ContactMessage es el primer mensaje de una conversación de soporte. Las respuestas
+// del equipo y los mensajes posteriores del remitente se guardan como MensajeRespuesta.
 type ContactMessage struct {
 	gorm.Model
-	Name    string `gorm:"size:100;not null" json:"name"`
-	Email   string `gorm:"size:100;not null" json:"email"`
+	Name    string `gorm:"size:100;not null;index:ft_contact_busqueda,class:FULLTEXT" json:"name"`
+	Email   string `gorm:"size:100;not null;index;index:ft_contact_busqueda,class:FULLTEXT" json:"email"`
 	Phone   string `gorm:"size:20" json:"phone"`
-	Message string `gorm:"type:text;not null" json:"message"`
+	Message string `gorm:"type:text;not null;index:ft_contact_busqueda,class:FULLTEXT" json:"message"`
 	Read    bool   `gorm:"default:false" json:"read"`
 	Starred bool   `gorm:"default:false" json:"starred"`
+
+	// Conversación
+	Estado          string             `gorm:"size:20;not null;default:'abierto';index" json:"estado"`
+	AsignadoID      *uint              `gorm:"index" json:"asignado_id"`
+	Asignado        *Usuario           `gorm:"foreignKey:AsignadoID" json:"asignado,omitempty"`
+	UltimaActividad *time.Time         `gorm:"index" json:"ultima_actividad"`
+	ArchivadoEn     *time.Time         `gorm:"index" json:"archivado_en"` // Fuera de la bandeja hasta que llegue otro mensaje
+	Respuestas      []MensajeRespuesta `gorm:"foreignKey:MensajeID" json:"respuestas,omitempty"`
+	MessageID       string             `gorm:"size:255;index" json:"-"`                        // Si la conversación empezó por email
+	Adjuntos        []MensajeAdjunto   `gorm:"foreignKey:MensajeID" json:"adjuntos,omitempty"` // Del primer mensaje
+
+	// Spam: se guarda sin avisar a nadie y sin mezclarlo con las conversaciones legítimas
+	Spam           bool    `gorm:"default:false;index" json:"spam"`
+	SpamPuntuacion float64 `json:"spam_puntuacion"` // Probabilidad del filtro bayesiano
+	SpamMotivos    string  `gorm:"size:255" json:"spam_motivos,omitempty"`
+	EntrenadoComo  string  `gorm:"size:4" json:"-"` // spam o ham, si ya se usó para entrenar el filtro
 }
 
 func contactHandler(c *gin.Context) {
@@ -37,69 +57,123 @@ func contactHandler(c *gin.Context) {
 		return
 	}
 
-	// Guardar en la base de datos
-	message := ContactMessage{
-		Name:    req.Name,
-		Email:   req.Email,
-		Phone:   req.Phone,
-		Message: req.Message,
+	bot, puntuacion, motivos := isContactSpam(req)
+	if bot {
+		// El honeypot solo lo rellena un bot: se descarta sin guardarlo y sin darle pistas
+		log.Printf("Mensaje de contacto descartado por el honeypot desde IP %s", c.ClientIP())
+		SendSuccessResponse(c, gin.H{"message": "Mensaje enviado exitosamente"})
+		return
+	}
+
+	if !checkRateLimit(c, ruleContactDay, c.ClientIP()) || !checkRateLimit(c, ruleContactMail, req.Email) {
+		return
 	}
 
-	if err := db.Create(&message).Error; err != nil {
+	if len(motivos) > 0 {
+		now := time.Now()
+		message := ContactMessage{
+			Name:            req.Name,
+			Email:           req.Email,
+			Phone:           req.Phone,
+			Message:         req.Message,
+			Estado:          HiloAbierto,
+			UltimaActividad: &now,
+			Spam:            true,
+			SpamPuntuacion:  puntuacion,
+			SpamMotivos:     strings.Join(motivos, ","),
+		}
+		if err := db.Create(&message).Error; err != nil {
+			log.Printf("Error guardando mensaje: %v", err)
+			SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Mensaje de contacto %d de %s marcado como spam: %s", message.ID, req.Email, message.SpamMotivos)
+		SendSuccessResponse(c, gin.H{"message": "Mensaje enviado exitosamente"})
+		return
+	}
+
+	// Guardar en la base de datos. Si quien escribe ha iniciado sesión con ese mismo email
+	// y ya tiene una conversación sin cerrar, el mensaje se añade a ella en lugar de abrir
+	// otra; desde el formulario anónimo siempre se abre una nueva
+	continuar := contactSenderOwnsEmail(c, req.Email)
+	if _, _, err := receiveContactMessage(req.Name, req.Email, req.Phone, req.Message, "", continuar); err != nil {
 		log.Printf("Error guardando mensaje: %v", err)
 		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
 		return
 	}
 
-	// Enviar por email
+	// Avisar por email; el mensaje ya está guardado, así que un fallo no se devuelve al usuario
 	if err := sendContactEmail(req); err != nil {
-		log.Printf("Error enviando email: %v", err)
-		SendErrorResponse(c, ErrEmailSendError, http.StatusInternalServerError)
-		return
+		log.Printf("Error encolando aviso de contacto: %v", err)
 	}
 
 	SendSuccessResponse(c, gin.H{"message": "Mensaje enviado exitosamente"})
 }
 
-func sendContactEmail(contact ContactRequest) error {
-	from := getEnv("EMAIL_FROM", "noreply@example.com")
-	to := getEnv("CONTACT_EMAIL", "marcelinho.nelson@gmail.com")
-	pass := getEnv("EMAIL_PASSWORD", "")
-	smtpHost := getEnv("SMTP_HOST", "smtp.gmail.com")
-	smtpPort := getEnv("SMTP_PORT", "587")
-
-	if pass == "" {
-		return ErrEmailSendError
+// contactSenderOwnsEmail indica si la petición trae una sesión válida de un usuario que
+// ha verificado el email indicado. El formulario es público: sin sesión devuelve false.
+func contactSenderOwnsEmail(c *gin.Context, email string) bool {
+	tokenString, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		return false
 	}
+	claims, err := parseAccessToken(tokenString, false)
+	if err != nil || claims.ImpersonatorID != 0 || claims.SessionID == "" || !isSessionActive(claims.SessionID) {
+		return false
+	}
+	var user Usuario
+	if err := db.First(&user, claims.UserID).Error; err != nil {
+		return false
+	}
+	return user.EmailVerificado && strings.EqualFold(user.Email, email)
+}
 
-	auth := smtp.PlainAuth("", from, pass, smtpHost)
-
-	// Cargar template desde archivo
-	tmpl, err := template.ParseFiles(filepath.Join("templates", "contact_email.html"))
+// sendContactEmail avisa del nuevo mensaje al personal con acceso a los mensajes que
+// no haya desactivado el aviso. Si nadie lo recibe, se envía a CONTACT_EMAIL.
+func sendContactEmail(contact ContactRequest) error {
+	destinatarios, err := staffRecipients(PermMessagesRead, NotifNewMessages)
 	if err != nil {
-		return err
+		log.Printf("Error al buscar destinatarios del aviso de contacto: %v", err)
 	}
 
-	var body bytes.Buffer
-	if err := tmpl.Execute(&body, contact); err != nil {
-		return err
+	enviados := 0
+	for _, u := range destinatarios {
+		err := sendNotificationEmail(u, NotifNewMessages, PlantillaContacto, EmailMessage{ReplyTo: contact.Email}, func(unsubscribeLink string) interface{} {
+			return newContactEmailData(contact, unsubscribeLink)
+		})
+		if err != nil {
+			log.Printf("Error al enviar aviso de contacto a %s: %v", u.Email, err)
+			continue
+		}
+		enviados++
 	}
-
-	headers := "MIME-version: 1.0;\r\nContent-Type: text/html; charset=\"UTF-8\";\r\n"
-	msg := "To: " + to + "\r\n" +
-		"From: " + from + "\r\n" +
-		"Subject: Nuevo mensaje de contacto - " + contact.Name + "\r\n" +
-		headers + "\r\n" +
-		body.String()
-
-	// Modo desarrollo
-	if getEnv("APP_ENV", "development") == "development" {
-		return saveEmailToFile(body.Bytes())
+	if enviados > 0 {
+		return nil
 	}
 
-	return smtp.SendMail(smtpHost+":"+smtpPort, auth, from, []string{to}, []byte(msg))
+	// Buzón general: no es un usuario, así que no tiene preferencias ni enlace de baja
+	return sendTemplateEmail(PlantillaContacto, defaultEmailLocale(), EmailMessage{
+		To:      []string{getEnv("CONTACT_EMAIL", "marcelinho.nelson@gmail.com")},
+		ReplyTo: contact.Email,
+	}, newContactEmailData(contact, ""))
+}
+
+// contactEmailData son los datos de la plantilla del aviso de contacto. No incluye el
+// honeypot ni el token del formulario.
+type contactEmailData struct {
+	Name            string
+	Email           string
+	Phone           string
+	Message         string
+	UnsubscribeLink string
 }
 
-func saveEmailToFile(content []byte) error {
-	return os.WriteFile("last_contact_email.html", content, 0644)
-}
\ No newline at end of file
+func newContactEmailData(contact ContactRequest, unsubscribeLink string) contactEmailData {
+	return contactEmailData{
+		Name:            contact.Name,
+		Email:           contact.Email,
+		Phone:           contact.Phone,
+		Message:         contact.Message,
+		UnsubscribeLink: unsubscribeLink,
+	}
+}
@@ -351,6 +351,20 @@ func getCursos(c *gin.Context) {
 		return
 	}
 
+	ids := make([]uint, len(cursos))
+	for i := range cursos {
+		ids[i] = cursos[i].ID
+	}
+	resumenes, err := courseRatingSummaries(ids)
+	if err != nil {
+		log.Printf("Error al calcular las valoraciones de los cursos: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener cursos: " + err.Error()})
+		return
+	}
+	for i := range cursos {
+		cursos[i].Valoracion = resumenes[cursos[i].ID]
+	}
+
 	c.JSON(http.StatusOK, cursos)
 }
 
@@ -364,6 +378,14 @@ func getCursoById(c *gin.Context) {
 		return
 	}
 
+	resumenes, err := courseRatingSummaries([]uint{curso.ID})
+	if err != nil {
+		log.Printf("Error al calcular las valoraciones del curso %d: %v", curso.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener el curso: " + err.Error()})
+		return
+	}
+	curso.Valoracion = resumenes[curso.ID]
+
 	c.JSON(http.StatusOK, curso)
 }
 
@@ -691,6 +713,9 @@ func createCapitulo(c *gin.Context) {
 	}
 
 	log.Printf("Capítulo creado exitosamente: ID %v", capitulo.ID)
+	if capitulo.Publicado {
+		go notifyNewChapter(capitulo)
+	}
 	c.JSON(http.StatusCreated, capitulo)
 }
 
@@ -731,6 +756,8 @@ func updateCapitulo(c *gin.Context) {
 		}
 	}
 
+	recienPublicado := req.Publicado && !capitulo.Publicado
+
 	capitulo.CursoID = req.CursoID
 	capitulo.Titulo = req.Titulo
 	capitulo.Descripcion = req.Descripcion
@@ -749,6 +776,9 @@ func updateCapitulo(c *gin.Context) {
 	}
 
 	log.Printf("Capítulo actualizado exitosamente: ID %v", capitulo.ID)
+	if recienPublicado {
+		go notifyNewChapter(capitulo)
+	}
 	c.JSON(http.StatusOK, capitulo)
 }
 
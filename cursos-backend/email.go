@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+)
+
+// sendHTMLEmail encola un correo HTML en el outbox; lo envía el worker en segundo plano
+// con el Mailer configurado. La versión en texto plano se genera a partir del HTML.
+func sendHTMLEmail(to, subject, htmlContent string) error {
+	return sendEmail(EmailMessage{To: []string{to}, Subject: subject, HTML: htmlContent})
+}
+
+// sendEmail encola un correo completo (Reply-To, cabeceras, adjuntos...)
+func sendEmail(msg EmailMessage) error {
+	return enqueueEmail(msg)
+}
+
+// renderTemplateFile renderiza una plantilla HTML de la carpeta templates
+func renderTemplateFile(path string, data interface{}) (string, error) {
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		return "", err
+	}
+
+	var htmlContent bytes.Buffer
+	if err := tmpl.Execute(&htmlContent, data); err != nil {
+		return "", err
+	}
+
+	return htmlContent.String(), nil
+}
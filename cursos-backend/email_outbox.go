@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Estados de un correo en el outbox
+const (
+	EmailPendiente = "pendiente"
+	EmailEnviando  = "enviando"
+	EmailEnviado   = "enviado"
+	EmailFallido   = "fallido" // Agotó los reintentos (dead letter): solo se reenvía a mano
+)
+
+// EmailOutbox es un correo pendiente de enviar. Los controladores solo lo encolan;
+// el worker lo envía con reintentos para que un SMTP lento o caído no afecte a la petición.
+type EmailOutbox struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	Destinatario   string     `gorm:"size:255;not null;index" json:"destinatario"` // Varios separados por coma
+	ReplyTo        string     `gorm:"size:255" json:"reply_to,omitempty"`
+	Asunto         string     `gorm:"size:255;not null" json:"asunto"`
+	CuerpoHTML     string     `gorm:"type:mediumtext" json:"cuerpo_html,omitempty"`
+	CuerpoTexto    string     `gorm:"type:mediumtext" json:"cuerpo_texto,omitempty"`
+	Cabeceras      string     `gorm:"type:text" json:"-"`     // Cabeceras extra en JSON
+	Adjuntos       string     `gorm:"type:longtext" json:"-"` // Adjuntos en JSON (datos en base64)
+	Estado         string     `gorm:"size:20;not null;index:idx_outbox_estado_proximo" json:"estado"`
+	Intentos       int        `gorm:"not null" json:"intentos"`
+	ProximoIntento time.Time  `gorm:"index:idx_outbox_estado_proximo" json:"proximo_intento"`
+	UltimoError    string     `gorm:"type:text" json:"ultimo_error,omitempty"`
+	EnviadoEn      *time.Time `json:"enviado_en"`
+	Sensible       bool       `gorm:"not null;default:false" json:"sensible"` // Lleva un enlace de acceso
+	CuerpoBorrado  bool       `gorm:"not null;default:false" json:"cuerpo_borrado"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (EmailOutbox) TableName() string {
+	return "email_outbox"
+}
+
+// message reconstruye el correo guardado
+func (m EmailOutbox) message() (EmailMessage, error) {
+	msg := EmailMessage{
+		To:      strings.Split(m.Destinatario, ","),
+		ReplyTo: m.ReplyTo,
+		Subject: m.Asunto,
+		HTML:    m.CuerpoHTML,
+		Text:    m.CuerpoTexto,
+	}
+	if m.Cabeceras != "" {
+		if err := json.Unmarshal([]byte(m.Cabeceras), &msg.Headers); err != nil {
+			return msg, fmt.Errorf("cabeceras inválidas: %v", err)
+		}
+	}
+	if m.Adjuntos != "" {
+		if err := json.Unmarshal([]byte(m.Adjuntos), &msg.Attachments); err != nil {
+			return msg, fmt.Errorf("adjuntos inválidos: %v", err)
+		}
+	}
+	return msg, nil
+}
+
+// outboxWake despierta al worker en cuanto se encola un correo
+var outboxWake = make(chan struct{}, 1)
+
+// enqueueEmail valida el correo y lo guarda en el outbox. Solo falla si el correo está
+// mal formado o no se puede guardar; los errores de envío los gestiona el worker.
+func enqueueEmail(msg EmailMessage) error {
+	_, err := queueEmail(msg)
+	return err
+}
+
+// queueEmail es enqueueEmail pero devuelve el registro del outbox, para quien necesite
+// seguir el estado de entrega
+func queueEmail(msg EmailMessage) (*EmailOutbox, error) {
+	if _, err := buildMIMEMessage(msg); err != nil {
+		return nil, err
+	}
+
+	m := EmailOutbox{
+		Destinatario:   strings.Join(msg.To, ","),
+		ReplyTo:        msg.ReplyTo,
+		Asunto:         truncate(msg.Subject, 255),
+		CuerpoHTML:     msg.HTML,
+		CuerpoTexto:    msg.Text,
+		Sensible:       msg.Sensitive,
+		Estado:         EmailPendiente,
+		ProximoIntento: time.Now(),
+	}
+	if len(msg.Headers) > 0 {
+		data, err := json.Marshal(msg.Headers)
+		if err != nil {
+			return nil, err
+		}
+		m.Cabeceras = string(data)
+	}
+	if len(msg.Attachments) > 0 {
+		data, err := json.Marshal(msg.Attachments)
+		if err != nil {
+			return nil, err
+		}
+		m.Adjuntos = string(data)
+	}
+	if err := db.Create(&m).Error; err != nil {
+		log.Printf("Error al encolar email para %s: %v", m.Destinatario, err)
+		return nil, ErrEmailSendError
+	}
+
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+	return &m, nil
+}
+
+// emailMaxAttempts es el número de intentos antes de pasar el correo a fallido
+func emailMaxAttempts() int {
+	return parseIntEnv("EMAIL_MAX_ATTEMPTS", 8)
+}
+
+// emailBackoff devuelve la espera tras el intento n: 1, 2, 4... minutos, hasta 6 horas
+func emailBackoff(intentos int) time.Duration {
+	espera := time.Minute
+	for i := 1; i < intentos && espera < 6*time.Hour; i++ {
+		espera *= 2
+	}
+	if espera > 6*time.Hour {
+		espera = 6 * time.Hour
+	}
+	return espera
+}
+
+// startEmailOutboxWorker envía los correos pendientes. Se despierta al encolar un correo
+// o, como mucho, cada EMAIL_OUTBOX_POLL para procesar los reintentos.
+func startEmailOutboxWorker() {
+	// Correos que quedaron a medias si el proceso se detuvo durante el envío
+	if err := db.Model(&EmailOutbox{}).
+		Where("estado = ? AND updated_at < ?", EmailEnviando, time.Now().Add(-10*time.Minute)).
+		Update("estado", EmailPendiente).Error; err != nil {
+		log.Printf("Error al recuperar correos interrumpidos: %v", err)
+	}
+
+	ticker := time.NewTicker(parseDurationEnv("EMAIL_OUTBOX_POLL", 15*time.Second))
+	defer ticker.Stop()
+	limpieza := time.Now()
+
+	for {
+		processEmailOutbox(getMailer())
+
+		if time.Since(limpieza) > time.Hour {
+			purgeSentEmails()
+			limpieza = time.Now()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-outboxWake:
+		}
+	}
+}
+
+func processEmailOutbox(mailer Mailer) {
+	var pendientes []EmailOutbox
+	if err := db.Where("estado = ? AND proximo_intento <= ?", EmailPendiente, time.Now()).
+		Order("proximo_intento").Limit(50).Find(&pendientes).Error; err != nil {
+		log.Printf("Error al leer el outbox de emails: %v", err)
+		return
+	}
+
+	for _, m := range pendientes {
+		// Se reclama el correo para que otra instancia no lo envíe a la vez
+		claim := db.Model(&EmailOutbox{}).Where("id = ? AND estado = ?", m.ID, EmailPendiente).
+			Update("estado", EmailEnviando)
+		if claim.Error != nil || claim.RowsAffected == 0 {
+			continue
+		}
+		deliverOutboxEmail(mailer, m)
+	}
+}
+
+// deliverOutboxEmail intenta el envío y guarda el resultado
+func deliverOutboxEmail(mailer Mailer, m EmailOutbox) {
+	intentos := m.Intentos + 1
+	msg, err := m.message()
+	if err == nil {
+		err = mailer.Send(msg)
+	}
+
+	cambios := map[string]interface{}{"intentos": intentos}
+	// El enlace de un correo sensible solo debe quedar en el buzón del destinatario
+	borrarCuerpo := func() {
+		if m.Sensible {
+			cambios["cuerpo_html"] = ""
+			cambios["cuerpo_texto"] = ""
+			cambios["cuerpo_borrado"] = true
+		}
+	}
+	switch {
+	case err == nil:
+		cambios["estado"] = EmailEnviado
+		cambios["enviado_en"] = time.Now()
+		cambios["ultimo_error"] = ""
+		borrarCuerpo()
+	case intentos >= emailMaxAttempts():
+		log.Printf("Email %d a %s descartado tras %d intentos: %v", m.ID, m.Destinatario, intentos, err)
+		cambios["estado"] = EmailFallido
+		cambios["ultimo_error"] = truncate(err.Error(), 1000)
+		borrarCuerpo()
+	default:
+		espera := emailBackoff(intentos)
+		log.Printf("Error al enviar email %d a %s (intento %d), reintento en %s: %v", m.ID, m.Destinatario, intentos, espera, err)
+		cambios["estado"] = EmailPendiente
+		cambios["proximo_intento"] = time.Now().Add(espera)
+		cambios["ultimo_error"] = truncate(err.Error(), 1000)
+	}
+
+	if err := db.Model(&EmailOutbox{}).Where("id = ?", m.ID).Updates(cambios).Error; err != nil {
+		log.Printf("Error al actualizar el email %d del outbox: %v", m.ID, err)
+		return
+	}
+	syncReplyDelivery(m.ID, cambios["estado"].(string))
+}
+
+// purgeSentEmails borra los correos enviados tras EMAIL_OUTBOX_RETENTION (30 días):
+// contienen datos personales y enlaces que ya no hacen falta
+func purgeSentEmails() {
+	limite := time.Now().Add(-parseDurationEnv("EMAIL_OUTBOX_RETENTION", 30*24*time.Hour))
+	if err := db.Where("estado = ? AND enviado_en < ?", EmailEnviado, limite).Delete(&EmailOutbox{}).Error; err != nil {
+		log.Printf("Error al limpiar el outbox de emails: %v", err)
+	}
+}
+
+// getEmailOutbox lista los correos del outbox para el panel de administración
+func getEmailOutbox(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	query := db.Model(&EmailOutbox{}).Omit("cuerpo_html", "cuerpo_texto", "adjuntos")
+	if estado := c.Query("estado"); estado != "" {
+		query = query.Where("estado = ?", estado)
+	}
+	if destinatario := c.Query("destinatario"); destinatario != "" {
+		query = query.Where("destinatario LIKE ?", "%"+destinatario+"%")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	var correos []EmailOutbox
+	if err := query.Order("created_at DESC, id DESC").
+		Offset((page - 1) * limit).Limit(limit).Find(&correos).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	var resumen []struct {
+		Estado string `json:"estado"`
+		Total  int64  `json:"total"`
+	}
+	db.Model(&EmailOutbox{}).Select("estado, COUNT(*) as total").Group("estado").Scan(&resumen)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    correos,
+		"resumen": resumen,
+		"pagination": gin.H{
+			"total": total,
+			"page":  page,
+			"limit": limit,
+			"pages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+// getEmailOutboxItem devuelve un correo con su contenido
+func getEmailOutboxItem(c *gin.Context) {
+	var m EmailOutbox
+	if err := db.First(&m, c.Param("id")).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	msg, err := m.message()
+	if err != nil {
+		log.Printf("Email %d del outbox ilegible: %v", m.ID, err)
+	}
+	// Nadie del panel debe poder leer un enlace de acceso de otro usuario
+	if m.Sensible {
+		m.CuerpoHTML = ""
+		m.CuerpoTexto = ""
+	}
+	adjuntos := make([]gin.H, 0, len(msg.Attachments))
+	for _, a := range msg.Attachments {
+		adjuntos = append(adjuntos, gin.H{"nombre": a.Nombre, "content_type": a.ContentType, "bytes": len(a.Datos)})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"data":      m,
+		"cabeceras": msg.Headers,
+		"adjuntos":  adjuntos,
+	})
+}
+
+// resendEmailOutboxItem vuelve a poner en cola un correo fallido (o ya enviado)
+func resendEmailOutboxItem(c *gin.Context) {
+	var m EmailOutbox
+	if err := db.First(&m, c.Param("id")).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+	if m.Estado == EmailPendiente || m.Estado == EmailEnviando {
+		SendErrorResponse(c, errors.New("el correo ya está en cola"), http.StatusConflict)
+		return
+	}
+	if m.CuerpoBorrado {
+		SendErrorResponse(c, errors.New("el contenido de este correo se borró tras el envío; el usuario debe solicitar un enlace nuevo"), http.StatusConflict)
+		return
+	}
+
+	if err := db.Model(&m).Updates(map[string]interface{}{
+		"estado":          EmailPendiente,
+		"intentos":        0,
+		"proximo_intento": time.Now(),
+	}).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	syncReplyDelivery(m.ID, EmailPendiente)
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+	logActivity(c, user.ID, "email_resend", fmt.Sprintf("Reenvío del email ID %d a %s", m.ID, m.Destinatario))
+
+	SendSuccessResponse(c, gin.H{"message": "Correo puesto en cola de nuevo"})
+}
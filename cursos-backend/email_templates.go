@@ -0,0 +1,586 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Nombres de las plantillas de email
+const (
+	PlantillaContacto          = "contact_message"
+	PlantillaRespuestaContacto = "contact_reply"
+	PlantillaRecuperacion      = "password_reset"
+	PlantillaVerificacion      = "verify_email"
+	PlantillaInvitacion        = "invitation"
+	PlantillaEnlaceMagico      = "magic_link"
+	PlantillaCuentaBloqueada   = "account_locked"
+	PlantillaNuevoEstudiante   = "new_student"
+	PlantillaResumen           = "notification_digest"
+)
+
+// ErrInvalidEmailTemplate indica que la plantilla no compila o no se puede renderizar
+var ErrInvalidEmailTemplate = errors.New("la plantilla de email no es válida")
+
+// plantillaIncluida es una plantilla distribuida con la aplicación. Se usa cuando no hay
+// una versión en la base de datos y sirve de referencia para las vistas previas.
+type plantillaIncluida struct {
+	Fichero     string                 `json:"fichero"`
+	Asunto      string                 `json:"asunto"`
+	Descripcion string                 `json:"descripcion"`
+	Ejemplo     map[string]interface{} `json:"ejemplo"` // Datos de muestra; mismos campos que los del envío
+
+	// Sensible: el correo lleva un enlace que da acceso a la cuenta. Su cuerpo no se
+	// muestra en el panel y se borra del outbox en cuanto se envía.
+	Sensible bool `json:"sensible"`
+}
+
+var plantillasIncluidas = map[string]plantillaIncluida{
+	PlantillaContacto: {
+		Fichero:     "contact_email.html",
+		Asunto:      "Nuevo mensaje de contacto - {{.Name}}",
+		Descripcion: "Aviso al personal de un nuevo mensaje del formulario de contacto",
+		Ejemplo: map[string]interface{}{
+			"Name": "Ana García", "Email": "ana@example.com", "Phone": "600123456",
+			"Message": "Hola, ¿el curso incluye certificado?", "UnsubscribeLink": "https://example.com/unsubscribe?token=ejemplo",
+		},
+	},
+	PlantillaRespuestaContacto: {
+		Fichero:     "contact_reply_template.html",
+		Asunto:      "{{.Subject}} - {{.Name}}",
+		Descripcion: "Respuesta del personal a un mensaje de contacto",
+		Ejemplo: map[string]interface{}{
+			"Name": "Ana García", "Email": "ana@example.com", "Subject": "Respuesta a tu mensaje",
+			"OriginalMsg": "Hola, ¿el curso incluye certificado?", "ReplyMsg": "Sí, al completar todos los capítulos.",
+			"Unsubscribe": "https://example.com/unsubscribe?token=ejemplo",
+		},
+	},
+	PlantillaRecuperacion: {
+		Fichero:     "email_template.html",
+		Asunto:      "Recuperación de contraseña",
+		Descripcion: "Enlace para restablecer la contraseña",
+		Sensible:    true,
+		Ejemplo:     map[string]interface{}{"Name": "Ana García", "ResetLink": "https://example.com/reset-password/ejemplo"},
+	},
+	PlantillaVerificacion: {
+		Fichero:     "verify_email_template.html",
+		Asunto:      "Verifica tu email",
+		Descripcion: "Enlace de verificación de la dirección de email",
+		Sensible:    true,
+		Ejemplo: map[string]interface{}{
+			"Name": "Ana García", "VerifyLink": "https://example.com/verify-email/ejemplo", "Validez": "24 horas",
+		},
+	},
+	PlantillaInvitacion: {
+		Fichero:     "invitation_template.html",
+		Asunto:      "Invitación a la plataforma de cursos",
+		Descripcion: "Invitación para crear una cuenta con un rol",
+		Sensible:    true,
+		Ejemplo: map[string]interface{}{
+			"InvitadoPor": "Admin", "Rol": RolEditor, "InviteLink": "https://example.com/invitation/ejemplo", "Validez": "7 días",
+		},
+	},
+	PlantillaEnlaceMagico: {
+		Fichero:     "magic_link_template.html",
+		Asunto:      "Tu enlace para iniciar sesión",
+		Descripcion: "Enlace de acceso sin contraseña",
+		Sensible:    true,
+		Ejemplo: map[string]interface{}{
+			"Name": "Ana García", "LoginLink": "https://example.com/magic-link/ejemplo", "Validez": "15 minutos",
+		},
+	},
+	PlantillaCuentaBloqueada: {
+		Fichero:     "account_locked_template.html",
+		Asunto:      "Tu cuenta se ha bloqueado temporalmente",
+		Descripcion: "Aviso de bloqueo por intentos fallidos de inicio de sesión",
+		Ejemplo: map[string]interface{}{
+			"Name": "Ana García", "Duracion": "15 minutos", "IP": "203.0.113.7",
+			"Fecha": "01/01/2025 10:00", "ResetLink": "https://example.com/forgot-password",
+		},
+	},
+	PlantillaNuevoEstudiante: {
+		Fichero:     "new_student_template.html",
+		Asunto:      "Nuevo estudiante registrado",
+		Descripcion: "Aviso inmediato al personal de un nuevo registro",
+		Ejemplo: map[string]interface{}{
+			"Name": "Admin", "EstudianteNombre": "Ana García", "EstudianteEmail": "ana@example.com",
+			"Fecha": "01/01/2025 10:00", "AdminLink": "https://example.com/admin/users",
+			"UnsubscribeLink": "https://example.com/unsubscribe?token=ejemplo", "UnsubscribeText": "No quiero recibir avisos de nuevos estudiantes",
+		},
+	},
+	PlantillaResumen: {
+		Fichero:     "notification_digest_template.html",
+		Asunto:      "Resumen de actividad",
+		Descripcion: "Resumen periódico de ventas y nuevos estudiantes",
+		Ejemplo: map[string]interface{}{
+			"Name": "Admin", "Desde": "01/01/2025 00:00", "Hasta": "08/01/2025 00:00",
+			"IncluirVentas": true, "Ventas": 12, "Ingresos": "359.88",
+			"IncluirEstudiantes": true, "Estudiantes": []map[string]string{{"Nombre": "Ana García", "Email": "ana@example.com"}},
+			"AdminLink": "https://example.com/admin", "UnsubscribeLink": "https://example.com/unsubscribe?token=ejemplo",
+			"UnsubscribeText": "No quiero recibir el resumen de actividad",
+		},
+	},
+}
+
+// EmailTemplate sustituye a una plantilla incluida para un idioma. Cada cambio
+// guarda una copia en EmailTemplateVersion.
+type EmailTemplate struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	Nombre           string    `gorm:"size:60;not null;uniqueIndex:idx_plantilla_nombre_locale" json:"nombre"`
+	Locale           string    `gorm:"size:10;not null;uniqueIndex:idx_plantilla_nombre_locale" json:"locale"`
+	Asunto           string    `gorm:"size:255;not null" json:"asunto"`
+	HTML             string    `gorm:"column:html;type:mediumtext" json:"html"`
+	Texto            string    `gorm:"type:mediumtext" json:"texto"` // Vacío: se genera a partir del HTML
+	Version          int       `gorm:"not null" json:"version"`
+	ActualizadaPorID *uint     `json:"actualizada_por_id"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (EmailTemplate) TableName() string {
+	return "plantillas_email"
+}
+
+// EmailTemplateVersion es una copia de una versión anterior de la plantilla
+type EmailTemplateVersion struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	PlantillaID uint      `gorm:"not null;uniqueIndex:idx_plantilla_version" json:"plantilla_id"`
+	Version     int       `gorm:"not null;uniqueIndex:idx_plantilla_version" json:"version"`
+	Asunto      string    `gorm:"size:255;not null" json:"asunto"`
+	HTML        string    `gorm:"column:html;type:mediumtext" json:"html"`
+	Texto       string    `gorm:"type:mediumtext" json:"texto"`
+	CreadaPorID *uint     `json:"creada_por_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (EmailTemplateVersion) TableName() string {
+	return "plantillas_email_versiones"
+}
+
+// EmailTemplateRequest es el cuerpo para crear, actualizar o previsualizar una plantilla
+type EmailTemplateRequest struct {
+	Nombre string `json:"nombre"`
+	Locale string `json:"locale"`
+	Asunto string `json:"asunto"`
+	HTML   string `json:"html"`
+	Texto  string `json:"texto"`
+}
+
+// renderedEmail es el resultado de renderizar una plantilla
+type renderedEmail struct {
+	Subject string `json:"asunto"`
+	HTML    string `json:"html"`
+	Text    string `json:"texto,omitempty"`
+}
+
+// defaultEmailLocale es el idioma de las plantillas incluidas
+func defaultEmailLocale() string {
+	return getEnv("EMAIL_DEFAULT_LOCALE", "es")
+}
+
+// userEmailLocale devuelve el idioma en que hay que escribir al usuario
+func userEmailLocale(user Usuario) string {
+	if user.Idioma != "" {
+		return user.Idioma
+	}
+	return defaultEmailLocale()
+}
+
+// findEmailTemplate busca la versión de la base de datos para el idioma pedido o, si no
+// existe, para el idioma por defecto. Devuelve nil si hay que usar el fichero.
+func findEmailTemplate(nombre, locale string) *EmailTemplate {
+	locales := []string{locale}
+	if locale != defaultEmailLocale() {
+		locales = append(locales, defaultEmailLocale())
+	}
+	for _, l := range locales {
+		var t EmailTemplate
+		err := db.Where("nombre = ? AND locale = ?", nombre, l).First(&t).Error
+		if err == nil {
+			return &t
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error al buscar la plantilla %s (%s): %v", nombre, l, err)
+			return nil
+		}
+	}
+	return nil
+}
+
+// executeEmailTemplate compila y ejecuta asunto, HTML y texto con los datos indicados
+func executeEmailTemplate(asunto, htmlBody, texto string, data interface{}) (renderedEmail, error) {
+	var out renderedEmail
+
+	subject, err := executeTextTemplate("asunto", asunto, data)
+	if err != nil {
+		return out, err
+	}
+	out.Subject = strings.Join(strings.Fields(subject), " ") // Sin saltos de línea en la cabecera
+
+	tmpl, err := htmltemplate.New("html").Option("missingkey=error").Parse(htmlBody)
+	if err != nil {
+		return out, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return out, err
+	}
+	out.HTML = buf.String()
+
+	if texto != "" {
+		if out.Text, err = executeTextTemplate("texto", texto, data); err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+func executeTextTemplate(nombre, fuente string, data interface{}) (string, error) {
+	tmpl, err := texttemplate.New(nombre).Option("missingkey=error").Parse(fuente)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// renderEmail renderiza la plantilla con la versión de la base de datos si existe y,
+// si no, con el fichero incluido en templates/
+func renderEmail(nombre, locale string, data interface{}) (renderedEmail, error) {
+	incluida, ok := plantillasIncluidas[nombre]
+	if !ok {
+		return renderedEmail{}, fmt.Errorf("plantilla de email desconocida: %s", nombre)
+	}
+
+	if t := findEmailTemplate(nombre, locale); t != nil {
+		out, err := executeEmailTemplate(t.Asunto, t.HTML, t.Texto, data)
+		if err == nil {
+			return out, nil
+		}
+		// Una plantilla rota en la base de datos no debe impedir el envío
+		log.Printf("Error al renderizar la plantilla %s (%s) v%d, se usa la incluida: %v", nombre, t.Locale, t.Version, err)
+	}
+
+	htmlBody, err := renderTemplateFile(filepath.Join("templates", incluida.Fichero), data)
+	if err != nil {
+		return renderedEmail{}, err
+	}
+	subject, err := executeTextTemplate("asunto", incluida.Asunto, data)
+	if err != nil {
+		return renderedEmail{}, err
+	}
+	return renderedEmail{Subject: subject, HTML: htmlBody}, nil
+}
+
+// sendTemplateEmail renderiza la plantilla y encola el correo. msg aporta destinatarios,
+// Reply-To, cabeceras o adjuntos; el asunto y el cuerpo salen de la plantilla.
+func sendTemplateEmail(nombre, locale string, msg EmailMessage, data interface{}) error {
+	msg, err := templateMessage(nombre, locale, msg, data)
+	if err != nil {
+		return err
+	}
+	return sendEmail(msg)
+}
+
+// templateMessage completa msg con el asunto y el cuerpo de la plantilla
+func templateMessage(nombre, locale string, msg EmailMessage, data interface{}) (EmailMessage, error) {
+	out, err := renderEmail(nombre, locale, data)
+	if err != nil {
+		log.Printf("Error al renderizar la plantilla de email %s: %v", nombre, err)
+		return msg, err
+	}
+	msg.Subject = out.Subject
+	msg.HTML = out.HTML
+	msg.Text = out.Text
+	msg.Sensitive = plantillasIncluidas[nombre].Sensible
+	return msg, nil
+}
+
+// validateEmailTemplateRequest comprueba que la plantilla existe y se renderiza con los
+// datos de ejemplo. Devuelve false si ya se ha respondido.
+func validateEmailTemplateRequest(c *gin.Context, req EmailTemplateRequest) bool {
+	incluida, ok := plantillasIncluidas[req.Nombre]
+	if !ok {
+		SendErrorResponse(c, fmt.Errorf("plantilla de email desconocida: %s", req.Nombre), http.StatusBadRequest)
+		return false
+	}
+	if req.Locale == "" || len(req.Locale) > 10 || strings.TrimSpace(req.Asunto) == "" || strings.TrimSpace(req.HTML) == "" {
+		SendErrorResponse(c, ErrMissingFields, http.StatusBadRequest)
+		return false
+	}
+	// Ejemplo tiene los mismos campos que los datos del envío real: con missingkey=error,
+	// un campo que no existe falla aquí en lugar de salir vacío en los correos
+	if _, err := executeEmailTemplate(req.Asunto, req.HTML, req.Texto, incluida.Ejemplo); err != nil {
+		SendErrorResponse(c, fmt.Errorf("%w: %v", ErrInvalidEmailTemplate, err), http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
+
+// listEmailTemplates devuelve las plantillas incluidas y sus versiones en la base de datos
+func listEmailTemplates(c *gin.Context) {
+	var guardadas []EmailTemplate
+	if err := db.Omit("html", "texto").Order("nombre, locale").Find(&guardadas).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	porNombre := make(map[string][]EmailTemplate)
+	for _, t := range guardadas {
+		porNombre[t.Nombre] = append(porNombre[t.Nombre], t)
+	}
+
+	nombres := make([]string, 0, len(plantillasIncluidas))
+	for nombre := range plantillasIncluidas {
+		nombres = append(nombres, nombre)
+	}
+	sort.Strings(nombres)
+
+	data := make([]gin.H, 0, len(nombres))
+	for _, nombre := range nombres {
+		incluida := plantillasIncluidas[nombre]
+		data = append(data, gin.H{
+			"nombre":         nombre,
+			"descripcion":    incluida.Descripcion,
+			"fichero":        incluida.Fichero,
+			"asunto":         incluida.Asunto,
+			"personalizadas": porNombre[nombre],
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":        true,
+		"data":           data,
+		"default_locale": defaultEmailLocale(),
+	})
+}
+
+// getEmailTemplate devuelve una plantilla guardada con sus datos de ejemplo
+func getEmailTemplate(c *gin.Context) {
+	var t EmailTemplate
+	if err := db.First(&t, c.Param("id")).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    t,
+		"ejemplo": plantillasIncluidas[t.Nombre].Ejemplo,
+	})
+}
+
+// createEmailTemplate guarda una plantilla para un nombre e idioma
+func createEmailTemplate(c *gin.Context) {
+	var req EmailTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+	if !validateEmailTemplateRequest(c, req) {
+		return
+	}
+
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	var count int64
+	db.Model(&EmailTemplate{}).Where("nombre = ? AND locale = ?", req.Nombre, req.Locale).Count(&count)
+	if count > 0 {
+		SendErrorResponse(c, errors.New("ya existe una plantilla para ese nombre e idioma"), http.StatusConflict)
+		return
+	}
+
+	t := EmailTemplate{
+		Nombre:           req.Nombre,
+		Locale:           req.Locale,
+		Asunto:           req.Asunto,
+		HTML:             req.HTML,
+		Texto:            req.Texto,
+		Version:          1,
+		ActualizadaPorID: &user.ID,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&t).Error; err != nil {
+			return err
+		}
+		return tx.Create(emailTemplateSnapshot(t)).Error
+	})
+	if err != nil {
+		log.Printf("Error al crear la plantilla %s (%s): %v", req.Nombre, req.Locale, err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "email_template_create", fmt.Sprintf("Plantilla %s (%s) creada", t.Nombre, t.Locale))
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": t})
+}
+
+// updateEmailTemplate guarda una nueva versión de la plantilla
+func updateEmailTemplate(c *gin.Context) {
+	var t EmailTemplate
+	if err := db.First(&t, c.Param("id")).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	var req EmailTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+	// El nombre y el idioma no cambian: para otro idioma se crea otra plantilla
+	req.Nombre, req.Locale = t.Nombre, t.Locale
+	if !validateEmailTemplateRequest(c, req) {
+		return
+	}
+
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	if err := saveEmailTemplateVersion(&t, req.Asunto, req.HTML, req.Texto, user.ID); err != nil {
+		log.Printf("Error al actualizar la plantilla %d: %v", t.ID, err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "email_template_update", fmt.Sprintf("Plantilla %s (%s) actualizada a v%d", t.Nombre, t.Locale, t.Version))
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": t})
+}
+
+// saveEmailTemplateVersion actualiza el contenido y guarda la copia de la nueva versión
+func saveEmailTemplateVersion(t *EmailTemplate, asunto, htmlBody, texto string, userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		t.Asunto = asunto
+		t.HTML = htmlBody
+		t.Texto = texto
+		t.Version++
+		t.ActualizadaPorID = &userID
+		if err := tx.Save(t).Error; err != nil {
+			return err
+		}
+		return tx.Create(emailTemplateSnapshot(*t)).Error
+	})
+}
+
+func emailTemplateSnapshot(t EmailTemplate) *EmailTemplateVersion {
+	return &EmailTemplateVersion{
+		PlantillaID: t.ID,
+		Version:     t.Version,
+		Asunto:      t.Asunto,
+		HTML:        t.HTML,
+		Texto:       t.Texto,
+		CreadaPorID: t.ActualizadaPorID,
+	}
+}
+
+// deleteEmailTemplate borra la plantilla y su historial: se vuelve a usar el fichero incluido
+func deleteEmailTemplate(c *gin.Context) {
+	var t EmailTemplate
+	if err := db.First(&t, c.Param("id")).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("plantilla_id = ?", t.ID).Delete(&EmailTemplateVersion{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&t).Error
+	})
+	if err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+	logActivity(c, user.ID, "email_template_delete", fmt.Sprintf("Plantilla %s (%s) eliminada", t.Nombre, t.Locale))
+
+	SendSuccessResponse(c, gin.H{"message": "Plantilla eliminada; se usará la plantilla incluida"})
+}
+
+// listEmailTemplateVersions devuelve el historial de versiones de una plantilla
+func listEmailTemplateVersions(c *gin.Context) {
+	var versiones []EmailTemplateVersion
+	if err := db.Where("plantilla_id = ?", c.Param("id")).Order("version DESC").Find(&versiones).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": versiones})
+}
+
+// restoreEmailTemplateVersion vuelve a una versión anterior creando una versión nueva
+func restoreEmailTemplateVersion(c *gin.Context) {
+	var t EmailTemplate
+	if err := db.First(&t, c.Param("id")).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+	var v EmailTemplateVersion
+	if err := db.Where("plantilla_id = ? AND version = ?", t.ID, c.Param("version")).First(&v).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	if err := saveEmailTemplateVersion(&t, v.Asunto, v.HTML, v.Texto, user.ID); err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "email_template_restore",
+		fmt.Sprintf("Plantilla %s (%s) restaurada a la v%d como v%d", t.Nombre, t.Locale, v.Version, t.Version))
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": t})
+}
+
+// previewEmailTemplate renderiza con datos de ejemplo. Si se envía asunto y HTML se
+// previsualiza ese borrador; si no, la plantilla que se usaría al enviar.
+func previewEmailTemplate(c *gin.Context) {
+	var req EmailTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+	incluida, ok := plantillasIncluidas[req.Nombre]
+	if !ok {
+		SendErrorResponse(c, fmt.Errorf("plantilla de email desconocida: %s", req.Nombre), http.StatusBadRequest)
+		return
+	}
+	if req.Locale == "" {
+		req.Locale = defaultEmailLocale()
+	}
+
+	var out renderedEmail
+	var err error
+	if req.HTML != "" {
+		out, err = executeEmailTemplate(req.Asunto, req.HTML, req.Texto, incluida.Ejemplo)
+	} else {
+		out, err = renderEmail(req.Nombre, req.Locale, incluida.Ejemplo)
+	}
+	if err != nil {
+		SendErrorResponse(c, fmt.Errorf("%w: %v", ErrInvalidEmailTemplate, err), http.StatusUnprocessableEntity)
+		return
+	}
+	if out.Text == "" {
+		out.Text = htmlToText(out.HTML)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": out})
+}
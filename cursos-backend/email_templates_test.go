@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// datosEnvio son los tipos con los que se envía de verdad cada plantilla
+var datosEnvio = map[string]interface{}{
+	PlantillaContacto:          contactEmailData{},
+	PlantillaRespuestaContacto: contactReplyEmailData{},
+	PlantillaRecuperacion:      passwordResetEmailData{},
+	PlantillaVerificacion:      verifyEmailData{},
+	PlantillaInvitacion:        invitationEmailData{},
+	PlantillaEnlaceMagico:      magicLinkEmailData{},
+	PlantillaCuentaBloqueada:   accountLockedEmailData{},
+	PlantillaNuevoEstudiante:   newStudentEmailData{},
+	PlantillaResumen:           digestEmailData{},
+}
+
+// TestEmailTemplateExamplesMatchSendData comprueba que los datos de ejemplo con los que se
+// valida una plantilla tienen exactamente los campos del envío real, y que las plantillas
+// incluidas se renderizan con ellos
+func TestEmailTemplateExamplesMatchSendData(t *testing.T) {
+	for nombre, incluida := range plantillasIncluidas {
+		t.Run(nombre, func(t *testing.T) {
+			datos, ok := datosEnvio[nombre]
+			if !ok {
+				t.Fatalf("falta el tipo de datos del envío de %s en datosEnvio", nombre)
+			}
+
+			var campos []string
+			tipo := reflect.TypeOf(datos)
+			for i := 0; i < tipo.NumField(); i++ {
+				campos = append(campos, tipo.Field(i).Name)
+			}
+			var claves []string
+			for clave := range incluida.Ejemplo {
+				claves = append(claves, clave)
+			}
+			sort.Strings(campos)
+			sort.Strings(claves)
+			if !reflect.DeepEqual(claves, campos) {
+				t.Errorf("claves del ejemplo = %v, quiero los campos del envío %v", claves, campos)
+			}
+
+			html, err := os.ReadFile(filepath.Join("templates", incluida.Fichero))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := executeEmailTemplate(incluida.Asunto, string(html), "", incluida.Ejemplo); err != nil {
+				t.Errorf("la plantilla incluida no se renderiza con el ejemplo: %v", err)
+			}
+		})
+	}
+}
+
+func TestExecuteEmailTemplateUnknownField(t *testing.T) {
+	ejemplo := plantillasIncluidas[PlantillaRecuperacion].Ejemplo
+	_, err := executeEmailTemplate("Hola {{.Nombre}}", "<p>{{.ResetLink}}</p>", "", ejemplo)
+	if err == nil || !strings.Contains(err.Error(), "Nombre") {
+		t.Errorf("campo desconocido en el asunto: err = %v, quiero un error", err)
+	}
+	_, err = executeEmailTemplate("Hola", "<p>{{.Enlace}}</p>", "", ejemplo)
+	if err == nil {
+		t.Error("campo desconocido en el HTML: quiero un error")
+	}
+	if _, err := executeEmailTemplate("Hola {{.Name}}", "<p>{{.ResetLink}}</p>", "{{.Name}}", ejemplo); err != nil {
+		t.Errorf("plantilla válida: %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Políticas de verificación de email (EMAIL_VERIFICATION_POLICY)
+const (
+	PoliticaVerificacionNinguna = "none"     // Solo se informa, no se bloquea nada
+	PoliticaVerificacionCompra  = "purchase" // Bloquea las compras
+	PoliticaVerificacionAcceso  = "access"   // Bloquea las compras y el acceso al contenido de los cursos
+)
+
+// Límites para el reenvío del email de verificación
+const (
+	verificationResendInterval = 1 * time.Minute
+	verificationMaxPerDay      = 5
+)
+
+// VerifyEmailRequest estructura para confirmar el email
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// emailVerificationTTL devuelve la validez del enlace de verificación (por defecto 48 horas)
+func emailVerificationTTL() time.Duration {
+	return parseDurationEnv("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+}
+
+// emailVerificationPolicy devuelve la política configurada
+func emailVerificationPolicy() string {
+	switch policy := getEnv("EMAIL_VERIFICATION_POLICY", PoliticaVerificacionCompra); policy {
+	case PoliticaVerificacionNinguna, PoliticaVerificacionCompra, PoliticaVerificacionAcceso:
+		return policy
+	default:
+		log.Printf("Advertencia: EMAIL_VERIFICATION_POLICY inválida (%q), usando %q", policy, PoliticaVerificacionCompra)
+		return PoliticaVerificacionCompra
+	}
+}
+
+// defaultLinkSecret solo se usa en desarrollo si no se configura EMAIL_LINK_SECRET
+const defaultLinkSecret = "clave_enlaces_solo_desarrollo"
+
+// checkLinkSigningSecret comprueba al arrancar que en producción haya un EMAIL_LINK_SECRET
+// propio. Con él se firman los enlaces de verificación, baja y acceso, el token del
+// formulario de contacto y las direcciones de respuesta, así que no puede ser un valor
+// público ni depender de la configuración de los JWT.
+func checkLinkSigningSecret() error {
+	secret := getEnv("EMAIL_LINK_SECRET", "")
+	if getEnv("APP_ENV", "development") == "production" &&
+		(secret == "" || secret == defaultLinkSecret || secret == defaultJWTSecret) {
+		return errors.New("EMAIL_LINK_SECRET propio es obligatorio en producción")
+	}
+	if secret == "" {
+		log.Printf("Advertencia: EMAIL_LINK_SECRET no configurado, usando el secreto por defecto (solo desarrollo)")
+	}
+	return nil
+}
+
+// linkSigningSecret es la clave con la que se firman los enlaces enviados por email
+func linkSigningSecret() []byte {
+	return []byte(getEnv("EMAIL_LINK_SECRET", defaultLinkSecret))
+}
+
+// linkSignature calcula la firma HMAC de los campos de un enlace enviado por email
+func linkSignature(campos ...string) string {
+	mac := hmac.New(sha256.New, linkSigningSecret())
+	mac.Write([]byte(strings.Join(campos, "|")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signEmailLink firma un token junto con su destinatario, propósito y caducidad,
+// de forma que el enlace no sirva si se altera o se usa para otro fin
+func signEmailLink(reset *PasswordReset) string {
+	firma := linkSignature(reset.Proposito, reset.Token, strings.ToLower(reset.Email), fmt.Sprint(reset.ExpiresAt.Unix()))
+	return reset.Token + "." + firma
+}
+
+// verifyEmailLink comprueba la firma de un enlace y devuelve el token vigente
+func verifyEmailLink(signed, proposito string) (*PasswordReset, error) {
+	token, _, ok := strings.Cut(signed, ".")
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	reset, err := validateEmailToken(token, proposito)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal([]byte(signEmailLink(reset)), []byte(signed)) {
+		return nil, ErrInvalidToken
+	}
+	return reset, nil
+}
+
+// sendVerificationEmail genera un enlace de verificación para el usuario y lo envía.
+// Devuelve el enlace para poder mostrarlo en desarrollo.
+func sendVerificationEmail(user Usuario) (string, error) {
+	// Los enlaces anteriores dejan de valer; se conservan para el límite de reenvíos
+	if err := db.Model(&PasswordReset{}).
+		Where("email = ? AND proposito = ? AND used = ?", user.Email, PropositoVerificacionEmail, false).
+		Update("used", true).Error; err != nil {
+		log.Printf("Error al invalidar enlaces de verificación anteriores: %v", err)
+	}
+
+	ttl := emailVerificationTTL()
+	reset, err := generateEmailToken(user.Email, PropositoVerificacionEmail, ttl)
+	if err != nil {
+		return "", err
+	}
+
+	frontendURL := getEnv("FRONTEND_URL", "http://localhost:3000")
+	verifyLink := frontendURL + "/verify-email/" + signEmailLink(reset)
+
+	return verifyLink, sendTemplateEmail(PlantillaVerificacion, userEmailLocale(user), EmailMessage{To: []string{user.Email}}, verifyEmailData{
+		Name:       user.Nombre,
+		VerifyLink: verifyLink,
+		Validez:    formatDuracion(ttl),
+	})
+}
+
+// verifyEmailData son los datos de la plantilla de verificación de email
+type verifyEmailData struct {
+	Name       string
+	VerifyLink string
+	Validez    string
+}
+
+// formatDuracion expresa una duración en horas o días para mostrarla en los correos
+func formatDuracion(d time.Duration) string {
+	horas := int(d.Hours())
+	switch {
+	case horas >= 48 && horas%24 == 0:
+		return fmt.Sprintf("%d días", horas/24)
+	case horas == 1:
+		return "1 hora"
+	case horas > 1:
+		return fmt.Sprintf("%d horas", horas)
+	default:
+		return fmt.Sprintf("%d minutos", int(d.Minutes()))
+	}
+}
+
+// markExistingUsersVerified da por verificadas las cuentas creadas antes de existir
+// la verificación de email, para no bloquear a usuarios que ya estaban activos
+func markExistingUsersVerified() error {
+	return db.Model(&Usuario{}).Where("1 = 1").Update("email_verificado", true).Error
+}
+
+// requireVerifiedEmail bloquea la ruta si la política activa exige email verificado
+// para el ámbito indicado (compra o acceso). Debe ir después de authMiddleware.
+func requireVerifiedEmail(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userValue, exists := c.Get("user")
+		if !exists {
+			SendErrorResponse(c, ErrUnauthorized, http.StatusUnauthorized)
+			return
+		}
+		user := userValue.(Usuario)
+
+		policy := emailVerificationPolicy()
+		bloquea := policy == PoliticaVerificacionAcceso ||
+			(policy == PoliticaVerificacionCompra && scope == PoliticaVerificacionCompra)
+
+		if bloquea && !user.EmailVerificado {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success":                     false,
+				"error":                       ErrEmailNotVerified.Error(),
+				"email_verification_required": true,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// verifyEmail confirma el email a partir del enlace firmado
+func verifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	reset, err := verifyEmailLink(req.Token, PropositoVerificacionEmail)
+	if err != nil {
+		SendErrorResponse(c, ErrInvalidToken, http.StatusBadRequest)
+		return
+	}
+
+	var user Usuario
+	if err := db.Where("email = ?", reset.Email).First(&user).Error; err != nil {
+		SendErrorResponse(c, ErrInvalidToken, http.StatusBadRequest)
+		return
+	}
+
+	if err := MarkTokenAsUsed(reset.Token); err != nil {
+		SendErrorResponse(c, ErrInvalidToken, http.StatusBadRequest)
+		return
+	}
+
+	if err := db.Model(&user).Updates(map[string]interface{}{
+		"email_verificado":    true,
+		"email_verificado_en": time.Now(),
+	}).Error; err != nil {
+		log.Printf("Error al marcar email verificado para usuario %d: %v", user.ID, err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "email_verified", fmt.Sprintf("Email %s verificado", user.Email))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Email verificado correctamente",
+	})
+}
+
+// resendVerificationEmail reenvía el enlace de verificación al usuario autenticado
+func resendVerificationEmail(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	if user.EmailVerificado {
+		SendErrorResponse(c, errors.New("tu email ya está verificado"), http.StatusBadRequest)
+		return
+	}
+
+	// Límite de reenvíos: uno por minuto y un máximo diario
+	var ultimo PasswordReset
+	if err := db.Where("email = ? AND proposito = ?", user.Email, PropositoVerificacionEmail).
+		Order("created_at DESC").First(&ultimo).Error; err == nil {
+		if espera := time.Until(ultimo.CreatedAt.Add(verificationResendInterval)); espera > 0 {
+			c.Header("Retry-After", fmt.Sprint(int(espera.Seconds())+1))
+			SendErrorResponse(c, errors.New("espera un momento antes de solicitar otro email de verificación"), http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	var enviadosHoy int64
+	db.Model(&PasswordReset{}).
+		Where("email = ? AND proposito = ? AND created_at > ?", user.Email, PropositoVerificacionEmail, time.Now().Add(-24*time.Hour)).
+		Count(&enviadosHoy)
+	if enviadosHoy >= verificationMaxPerDay {
+		SendErrorResponse(c, errors.New("has alcanzado el límite diario de emails de verificación"), http.StatusTooManyRequests)
+		return
+	}
+
+	verifyLink, err := sendVerificationEmail(user)
+	if err != nil {
+		log.Printf("Error al enviar email de verificación a usuario %d: %v", user.ID, err)
+		SendErrorResponse(c, ErrEmailSendError, http.StatusInternalServerError)
+		return
+	}
+
+	response := gin.H{
+		"success": true,
+		"message": "Te hemos enviado un nuevo enlace de verificación",
+	}
+	if getEnv("APP_ENV", "development") == "development" {
+		response["verifyLink"] = verifyLink
+	}
+
+	c.JSON(http.StatusOK, response)
+}
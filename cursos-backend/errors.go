@@ -27,6 +27,15 @@ var (
 	ErrPaymentExists    = errors.New("pago ya existente")
 	ErrPaymentRejected  = errors.New("pago rechazado")
 	ErrPaymentNotFound  = errors.New("pago no encontrado")
+	ErrSessionRevoked   = errors.New("sesión revocada o expirada")
+	ErrInvalid2FACode   = errors.New("código de verificación inválido")
+	ErrEmailNotVerified = errors.New("debes verificar tu email para realizar esta acción")
+	ErrTooManyRequests  = errors.New("demasiadas solicitudes, inténtalo de nuevo más tarde")
+	ErrAccountLocked    = errors.New("cuenta bloqueada temporalmente por demasiados intentos fallidos")
+	ErrWeakPassword     = errors.New("la contraseña no cumple la política de seguridad")
+
+	ErrTwoFactorSetupRequired = errors.New("tu rol requiere autenticación en dos pasos: configúrala en tu perfil")
+	ErrImpersonationForbidden = errors.New("esta operación no está permitida mientras se suplanta a un usuario")
 )
 
 // SendErrorResponse envía una respuesta de error estandarizada
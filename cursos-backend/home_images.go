@@ -33,10 +33,10 @@ func initHomeImagesDirs() {
 }
 
 func registerHomeImageRoutes(router *gin.Engine) {
-	// Rutas protegidas que requieren autenticación de administrador
+	// Rutas protegidas que requieren permiso de gestión de imágenes de inicio
 	homeImages := router.Group("/api/home-images")
-	homeImages.Use(authMiddleware())
-	homeImages.Use(adminMiddleware())
+	homeImages.Use(allowAPIKey(), authMiddleware())
+	homeImages.Use(requirePermission(PermHomeImagesWrite))
 	{
 		homeImages.GET("", getHomeImages)
 		homeImages.POST("", uploadHomeImage)
@@ -71,21 +71,6 @@ func getHomeImages(c *gin.Context) {
 }
 
 func uploadHomeImage(c *gin.Context) {
-	// Verificar autenticación
-	userValue, exists := c.Get("user")
-	if !exists {
-		log.Println("Error en uploadHomeImage: Usuario no encontrado en el contexto")
-		SendErrorResponse(c, errors.New("usuario no autorizado"), http.StatusUnauthorized)
-		return
-	}
-	
-	user, ok := userValue.(Usuario)
-	if !ok || user.Role != "admin" {
-		log.Printf("Error en uploadHomeImage: Usuario no es admin, role: %s", user.Role)
-		SendErrorResponse(c, errors.New("permisos insuficientes"), http.StatusForbidden)
-		return
-	}
-
 	// Procesar archivo de imagen
 	file, err := c.FormFile("image")
 	if err != nil {
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// ImpersonateRequest permite indicar el motivo de la suplantación para la auditoría
+type ImpersonateRequest struct {
+	Motivo string `json:"motivo"`
+}
+
+// impersonationTTL devuelve la validez del token de suplantación (por defecto 30 minutos).
+// No se emite refresh token: al caducar hay que volver a solicitarlo.
+func impersonationTTL() time.Duration {
+	return parseDurationEnv("IMPERSONATION_TTL", 30*time.Minute)
+}
+
+// currentImpersonator devuelve el administrador que actúa en nombre del usuario, si lo hay
+func currentImpersonator(c *gin.Context) (*Usuario, bool) {
+	value, exists := c.Get("impersonator")
+	if !exists {
+		return nil, false
+	}
+	admin, ok := value.(Usuario)
+	return &admin, ok
+}
+
+// denyImpersonation bloquea operaciones sensibles (contraseña, pagos, credenciales)
+// cuando la petición se hace suplantando a un usuario
+func denyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if admin, ok := currentImpersonator(c); ok {
+			log.Printf("Operación bloqueada durante suplantación. Admin ID: %d, ruta: %s %s", admin.ID, c.Request.Method, c.FullPath())
+			SendErrorResponse(c, ErrImpersonationForbidden, http.StatusForbidden)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// loadImpersonator valida el administrador de un token de suplantación: debe existir
+// y conservar el permiso. Se llama desde authMiddleware.
+func loadImpersonator(claims *Claims) (*Usuario, error) {
+	var admin Usuario
+	if err := db.First(&admin, claims.ImpersonatorID).Error; err != nil {
+		return nil, ErrUserNotFound
+	}
+	if !hasPermission(admin.Role, PermUsersImpersonate) {
+		return nil, ErrUnauthorized
+	}
+	return &admin, nil
+}
+
+// auditImpersonatedRequest registra en ActivityLog cada petición hecha bajo suplantación.
+// Se ejecuta después del controlador para incluir el código de respuesta.
+func auditImpersonatedRequest(c *gin.Context, user Usuario) {
+	logActivity(c, user.ID, "impersonated_request",
+		fmt.Sprintf("%s %s -> %d", c.Request.Method, truncate(c.Request.URL.Path, 200), c.Writer.Status()))
+}
+
+// impersonateUser emite un token de acceso de corta duración para actuar como otro usuario
+func impersonateUser(c *gin.Context) {
+	// El cuerpo es opcional
+	var req ImpersonateRequest
+	_ = c.ShouldBindJSON(&req)
+
+	userValue, _ := c.Get("user")
+	adminUser := userValue.(Usuario)
+
+	var target Usuario
+	if err := db.First(&target, c.Param("id")).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	if target.ID == adminUser.ID {
+		SendErrorResponse(c, errors.New("no puedes suplantarte a ti mismo"), http.StatusBadRequest)
+		return
+	}
+	if !canGrantRole(adminUser.Role, target.Role) {
+		SendErrorResponse(c, errors.New("no puedes suplantar a un usuario con permisos que tú no tienes"), http.StatusForbidden)
+		return
+	}
+
+	// La sesión se asocia al usuario suplantado para que se pueda revocar como cualquier otra,
+	// pero el refresh token nunca se entrega
+	refresh, err := generateOpaqueToken()
+	if err != nil {
+		SendErrorResponse(c, ErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	ttl := impersonationTTL()
+	now := time.Now()
+	sesion := Sesion{
+		UsuarioID:       target.ID,
+		FamiliaID:       uuid.New().String(),
+		TokenHash:       hashToken(refresh),
+		IP:              c.ClientIP(),
+		UserAgent:       truncate(c.GetHeader("User-Agent"), 255),
+		Ubicacion:       approximateLocation(c),
+		IniciadaEn:      now,
+		LastSeenAt:      now,
+		ExpiresAt:       now.Add(ttl),
+		SuplantadaPorID: &adminUser.ID,
+	}
+	if err := db.Create(&sesion).Error; err != nil {
+		log.Printf("Error al crear sesión de suplantación: %v", err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	claims := &Claims{
+		UserID:         target.ID,
+		Role:           target.Role,
+		SessionID:      sesion.FamiliaID,
+		ImpersonatorID: adminUser.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(sesion.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	token, err := signClaims(claims)
+	if err != nil {
+		log.Printf("Error al firmar token de suplantación: %v", err)
+		SendErrorResponse(c, errors.New("error al generar token"), http.StatusInternalServerError)
+		return
+	}
+
+	detalles := fmt.Sprintf("Admin ID %d (%s) suplanta al usuario ID %d (%s) durante %s",
+		adminUser.ID, adminUser.Email, target.ID, target.Email, formatDuracion(ttl))
+	if req.Motivo != "" {
+		detalles += ". Motivo: " + truncate(req.Motivo, 200)
+	}
+	logActivity(c, adminUser.ID, "impersonate_start", detalles)
+
+	target.Password = ""
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"token":        token,
+		"expires_in":   int(ttl.Seconds()),
+		"user":         target,
+		"impersonator": gin.H{"id": adminUser.ID, "nombre": adminUser.Nombre, "email": adminUser.Email},
+	})
+}
@@ -0,0 +1,632 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Los correos entrantes llegan como mensajes RFC 5322 completos, ya sea desde el MTA local
+// (un alias tipo "soporte: |curl --data-binary @- -H 'X-Inbound-Secret: ...' URL") o desde
+// un relay HTTP que reenvía el mensaje original en el campo "email" o "body-mime".
+
+var (
+	ErrInboundDisabled     = errors.New("la recepción de correos no está configurada")
+	ErrInvalidInboundEmail = errors.New("correo entrante no válido")
+)
+
+// MensajeAdjunto es un fichero recibido por email en una conversación de soporte.
+// Se guarda fuera de static/ y solo se sirve a quien puede leer los mensajes.
+type MensajeAdjunto struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	MensajeID   uint      `gorm:"not null;index" json:"mensaje_id"`
+	RespuestaID *uint     `gorm:"index" json:"respuesta_id"` // Nulo si pertenece al primer mensaje
+	Nombre      string    `gorm:"size:255;not null" json:"nombre"`
+	ContentType string    `gorm:"size:100" json:"content_type"`
+	Tamano      int64     `json:"tamano"`
+	Ruta        string    `gorm:"size:255;not null" json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (MensajeAdjunto) TableName() string {
+	return "mensaje_adjuntos"
+}
+
+// inboundEmail es el resultado de analizar un correo entrante
+type inboundEmail struct {
+	MessageID     string
+	From          *mail.Address
+	Subject       string
+	Destinatarios []string // To, Cc y las cabeceras de entrega del MTA
+	Referencias   []string // In-Reply-To y References, sin los <>
+	Automatico    bool     // Respuesta automática, rebote o lista de correo
+	Texto         string
+	HTML          string
+	Adjuntos      []EmailAttachment
+}
+
+func messageAttachmentsDir() string {
+	return getEnv("MESSAGE_ATTACHMENTS_DIR", "./adjuntos_mensajes")
+}
+
+func initMessageAttachmentsDir() {
+	createDirIfNotExists(messageAttachmentsDir())
+}
+
+// threadReplyToken firma el ID de la conversación. Va en hexadecimal porque algunos
+// servidores de correo pasan la parte local de la dirección a minúsculas.
+func threadReplyToken(hiloID uint) string {
+	mac := hmac.New(sha256.New, linkSigningSecret())
+	fmt.Fprintf(mac, "hilo|%d", hiloID)
+	return fmt.Sprintf("%d.%s", hiloID, hex.EncodeToString(mac.Sum(nil)[:10]))
+}
+
+// threadReplyAddress devuelve la dirección de respuesta de la conversación
+// (soporte+<token>@dominio) o "" si SUPPORT_REPLY_ADDRESS no está configurada
+func threadReplyAddress(hiloID uint) string {
+	base := getEnv("SUPPORT_REPLY_ADDRESS", "")
+	local, dominio, ok := strings.Cut(base, "@")
+	if !ok {
+		return ""
+	}
+	return local + "+" + threadReplyToken(hiloID) + "@" + dominio
+}
+
+// threadFromReplyAddress extrae y verifica el ID de conversación de una dirección con token
+func threadFromReplyAddress(direccion string) (uint, bool) {
+	local, _, ok := strings.Cut(strings.ToLower(direccion), "@")
+	if !ok {
+		return 0, false
+	}
+	_, token, ok := strings.Cut(local, "+")
+	if !ok {
+		return 0, false
+	}
+	idTexto, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(idTexto, 10, 64)
+	if err != nil || !hmac.Equal([]byte(threadReplyToken(uint(id))), []byte(token)) {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// newThreadMessageID genera el Message-ID de una respuesta de la conversación
+func newThreadMessageID(hiloID uint) string {
+	from, err := mail.ParseAddress(defaultFromAddress())
+	dominio := "localhost"
+	if err == nil {
+		dominio = from.Address[strings.LastIndex(from.Address, "@")+1:]
+	}
+	return fmt.Sprintf("hilo.%d.%d.%s@%s", hiloID, time.Now().UnixNano(), randomHex(8), dominio)
+}
+
+// parseMessageIDs extrae los identificadores de In-Reply-To o References
+func parseMessageIDs(valor string) []string {
+	var ids []string
+	for {
+		inicio := strings.IndexByte(valor, '<')
+		if inicio < 0 {
+			return ids
+		}
+		fin := strings.IndexByte(valor[inicio:], '>')
+		if fin < 0 {
+			return ids
+		}
+		if id := strings.TrimSpace(valor[inicio+1 : inicio+fin]); id != "" {
+			ids = append(ids, id)
+		}
+		valor = valor[inicio+fin+1:]
+	}
+}
+
+// Tabla de windows-1252 para 0x80-0x9F; el resto coincide con ISO-8859-1
+var cp1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// charsetReader convierte a UTF-8 los juegos de caracteres habituales en el correo
+// en español. Los demás se dejan tal cual.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	switch charset {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return input, nil
+	case "iso-8859-1", "latin1", "iso-8859-15", "windows-1252", "cp1252":
+		data, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		var out strings.Builder
+		for _, b := range data {
+			switch {
+			case charset == "iso-8859-15" && b == 0xA4:
+				out.WriteRune('€')
+			case (charset == "windows-1252" || charset == "cp1252") && b >= 0x80 && b <= 0x9F:
+				out.WriteRune(cp1252[b-0x80])
+			default:
+				out.WriteRune(rune(b))
+			}
+		}
+		return strings.NewReader(out.String()), nil
+	default:
+		return input, nil
+	}
+}
+
+var headerDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+func decodeHeader(valor string) string {
+	if decoded, err := headerDecoder.DecodeHeader(valor); err == nil {
+		return decoded
+	}
+	return valor
+}
+
+// parseInboundEmail analiza un correo RFC 5322 con sus partes MIME
+func parseInboundEmail(raw []byte) (*inboundEmail, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInboundEmail, err)
+	}
+
+	parser := mail.AddressParser{WordDecoder: headerDecoder}
+	from, err := parser.Parse(msg.Header.Get("From"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: remitente inválido: %v", ErrInvalidInboundEmail, err)
+	}
+
+	correo := &inboundEmail{
+		MessageID: strings.Trim(strings.TrimSpace(msg.Header.Get("Message-ID")), "<>"),
+		From:      from,
+		Subject:   decodeHeader(msg.Header.Get("Subject")),
+	}
+	for _, cabecera := range []string{"To", "Cc", "Delivered-To", "X-Original-To", "Envelope-To"} {
+		for _, valor := range msg.Header[textproto.CanonicalMIMEHeaderKey(cabecera)] {
+			if lista, err := parser.ParseList(valor); err == nil {
+				for _, a := range lista {
+					correo.Destinatarios = append(correo.Destinatarios, a.Address)
+				}
+			}
+		}
+	}
+	correo.Referencias = append(parseMessageIDs(msg.Header.Get("In-Reply-To")), parseMessageIDs(msg.Header.Get("References"))...)
+
+	// RFC 3834: no se contesta ni se abre conversación con respuestas automáticas o rebotes
+	autoSubmitted := strings.ToLower(msg.Header.Get("Auto-Submitted"))
+	precedence := strings.ToLower(msg.Header.Get("Precedence"))
+	correo.Automatico = (autoSubmitted != "" && autoSubmitted != "no") ||
+		precedence == "bulk" || precedence == "junk" || precedence == "list" || precedence == "auto_reply" ||
+		msg.Header.Get("X-Autoreply") != "" || msg.Header.Get("X-Autorespond") != "" ||
+		strings.TrimSpace(msg.Header.Get("Return-Path")) == "<>" ||
+		strings.HasPrefix(strings.ToLower(from.Address), "mailer-daemon@") ||
+		strings.HasPrefix(strings.ToLower(from.Address), "postmaster@")
+
+	if err := walkMIMEPart(correo, textproto.MIMEHeader(msg.Header), msg.Body, 0); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInboundEmail, err)
+	}
+	return correo, nil
+}
+
+// walkMIMEPart recorre la parte y sus subpartes: se queda con el primer cuerpo de
+// texto y HTML y guarda como adjunto todo lo demás
+func walkMIMEPart(correo *inboundEmail, header textproto.MIMEHeader, body io.Reader, nivel int) error {
+	if nivel > 10 {
+		return errors.New("demasiados niveles de partes MIME")
+	}
+	get := header.Get
+
+	mediaType, params, err := mime.ParseMediaType(get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := walkMIMEPart(correo, part.Header, part, nivel+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	var contenido io.Reader = body
+	switch strings.ToLower(strings.TrimSpace(get("Content-Transfer-Encoding"))) {
+	case "base64":
+		contenido = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		contenido = quotedprintable.NewReader(body)
+	}
+
+	disposicion, dispParams, _ := mime.ParseMediaType(get("Content-Disposition"))
+	nombre := decodeHeader(firstNonEmpty(dispParams["filename"], params["name"]))
+	esCuerpo := disposicion != "attachment" && nombre == "" &&
+		(mediaType == "text/plain" && correo.Texto == "" || mediaType == "text/html" && correo.HTML == "")
+
+	if esCuerpo {
+		convertido, err := charsetReader(params["charset"], contenido)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(convertido)
+		if err != nil {
+			return err
+		}
+		texto := strings.ToValidUTF8(string(data), "�")
+		if mediaType == "text/plain" {
+			correo.Texto = texto
+		} else {
+			correo.HTML = texto
+		}
+		return nil
+	}
+
+	if strings.HasPrefix(mediaType, "text/") && nombre == "" && disposicion != "attachment" {
+		// Texto adicional sin nombre (p. ej. una segunda parte text/plain): se descarta
+		_, err := io.Copy(io.Discard, contenido)
+		return err
+	}
+
+	data, err := io.ReadAll(contenido)
+	if err != nil {
+		return err
+	}
+	if nombre == "" {
+		nombre = "adjunto"
+		if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+			nombre += exts[0]
+		} else if mediaType == "message/rfc822" {
+			nombre += ".eml"
+		}
+	}
+	correo.Adjuntos = append(correo.Adjuntos, EmailAttachment{Nombre: nombre, ContentType: mediaType, Datos: data})
+	return nil
+}
+
+var (
+	// "El lun, 3 mar 2025 a las 10:00, Ana <ana@...> escribió:" / "On Mon, ... wrote:"
+	quoteHeaderPattern = regexp.MustCompile(`(?i)^\s*(on|el)\s.*(wrote|escribió|escribio)\s*:\s*$`)
+	quoteStartPattern  = regexp.MustCompile(`(?i)^\s*(on|el)\s`)
+	// Separadores de Outlook y otros clientes
+	quoteSeparatorPattern = regexp.MustCompile(`(?i)^\s*(-{2,}\s*(original message|mensaje original|forwarded message|mensaje reenviado)\s*-{2,}|_{10,})\s*$`)
+	quoteFromPattern      = regexp.MustCompile(`(?i)^\s*\*?(from|de)\s*:\*?\s`)
+	quoteNextPattern      = regexp.MustCompile(`(?i)^\s*\*?(sent|enviado|date|fecha|to|para)\s*:\*?\s`)
+)
+
+// stripQuotedText quita del cuerpo la cita del mensaje anterior y la firma, para guardar
+// solo lo que ha escrito el remitente. Si no quedara nada se devuelve el texto completo.
+func stripQuotedText(texto string) string {
+	lineas := strings.Split(strings.ReplaceAll(texto, "\r\n", "\n"), "\n")
+	var out []string
+	for i, linea := range lineas {
+		siguiente := ""
+		if i+1 < len(lineas) {
+			siguiente = lineas[i+1]
+		}
+		if strings.TrimRight(linea, " ") == "--" ||
+			quoteHeaderPattern.MatchString(linea) ||
+			// Gmail parte en dos líneas la cabecera de la cita cuando es larga
+			quoteStartPattern.MatchString(linea) && quoteHeaderPattern.MatchString(linea+" "+siguiente) ||
+			quoteSeparatorPattern.MatchString(linea) ||
+			quoteFromPattern.MatchString(linea) && quoteNextPattern.MatchString(siguiente) {
+			break
+		}
+		if strings.HasPrefix(strings.TrimLeft(linea, " "), ">") {
+			continue
+		}
+		out = append(out, strings.TrimRight(linea, " \t"))
+	}
+
+	limpio := strings.TrimSpace(strings.Join(out, "\n"))
+	if limpio == "" {
+		return strings.TrimSpace(texto)
+	}
+	return limpio
+}
+
+// body devuelve el texto que se guarda en la conversación
+func (correo *inboundEmail) body() string {
+	texto := correo.Texto
+	if strings.TrimSpace(texto) == "" && correo.HTML != "" {
+		texto = htmlToText(correo.HTML)
+	}
+	return stripQuotedText(texto)
+}
+
+// findInboundThread busca la conversación a la que responde el correo: primero por la
+// dirección con token y después por In-Reply-To/References. En este segundo caso el
+// remitente debe coincidir, ya que los Message-ID no están firmados.
+func findInboundThread(correo *inboundEmail) (*ContactMessage, error) {
+	for _, destinatario := range correo.Destinatarios {
+		if id, ok := threadFromReplyAddress(destinatario); ok {
+			var hilo ContactMessage
+			err := db.First(&hilo, id).Error
+			if err == nil {
+				if !inboundSenderMatches(&hilo, correo) {
+					return nil, nil
+				}
+				return &hilo, nil
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, err
+			}
+		}
+	}
+
+	if len(correo.Referencias) == 0 {
+		return nil, nil
+	}
+	var respuesta MensajeRespuesta
+	err := db.Where("message_id IN ?", correo.Referencias).Order("id DESC").First(&respuesta).Error
+	var hilo ContactMessage
+	switch {
+	case err == nil:
+		err = db.First(&hilo, respuesta.MensajeID).Error
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		err = db.Where("message_id IN ?", correo.Referencias).First(&hilo).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !inboundSenderMatches(&hilo, correo) {
+		return nil, nil
+	}
+	return &hilo, nil
+}
+
+// inboundSenderMatches comprueba que el correo lo envía el dueño de la conversación.
+// Si no, se trata como un mensaje nuevo para que nadie pueda escribir en hilos ajenos.
+func inboundSenderMatches(hilo *ContactMessage, correo *inboundEmail) bool {
+	if strings.EqualFold(hilo.Email, correo.From.Address) {
+		return true
+	}
+	log.Printf("Correo de %s responde a la conversación %d de %s: se trata como mensaje nuevo", correo.From.Address, hilo.ID, hilo.Email)
+	return false
+}
+
+// isDuplicateInboundEmail indica si el correo ya se recibió (el MTA reintenta si no
+// obtiene respuesta a tiempo)
+func isDuplicateInboundEmail(messageID string) bool {
+	if messageID == "" {
+		return false
+	}
+	var total int64
+	db.Model(&MensajeRespuesta{}).Where("message_id = ?", messageID).Count(&total)
+	if total > 0 {
+		return true
+	}
+	db.Model(&ContactMessage{}).Where("message_id = ?", messageID).Count(&total)
+	return total > 0
+}
+
+// ingestInboundEmail añade el correo a su conversación, o a la abierta del remitente, o
+// empieza una nueva. Devuelve la conversación y la respuesta creada (nil si es nueva).
+func ingestInboundEmail(correo *inboundEmail) (*ContactMessage, *MensajeRespuesta, error) {
+	cuerpo := correo.body()
+	if cuerpo == "" && len(correo.Adjuntos) > 0 {
+		cuerpo = "(Sin texto, solo adjuntos)"
+	}
+	if cuerpo == "" {
+		return nil, nil, fmt.Errorf("%w: el correo no tiene contenido", ErrInvalidInboundEmail)
+	}
+
+	hilo, err := findInboundThread(correo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var respuesta *MensajeRespuesta
+	if hilo != nil {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			respuesta, err = appendToThread(tx, hilo, cuerpo, correo.MessageID)
+			return err
+		})
+	} else {
+		nombre := firstNonEmpty(correo.From.Name, correo.From.Address)
+		hilo, respuesta, err = receiveContactMessage(truncate(nombre, 100), correo.From.Address, "", cuerpo, correo.MessageID, true)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var respuestaID *uint
+	if respuesta != nil {
+		respuestaID = &respuesta.ID
+	}
+	for _, a := range correo.Adjuntos {
+		if err := saveMessageAttachment(hilo.ID, respuestaID, a); err != nil {
+			log.Printf("Error al guardar el adjunto %q de la conversación %d: %v", a.Nombre, hilo.ID, err)
+		}
+	}
+
+	notifyThreadActivity(hilo, correo.From, cuerpo)
+	return hilo, respuesta, nil
+}
+
+// saveMessageAttachment guarda el fichero con un nombre aleatorio y registra el original
+func saveMessageAttachment(hiloID uint, respuestaID *uint, a EmailAttachment) error {
+	dir := filepath.Join(messageAttachmentsDir(), strconv.FormatUint(uint64(hiloID), 10))
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	nombre := filepath.Base(strings.ReplaceAll(a.Nombre, "\\", "/"))
+	ext := strings.ToLower(filepath.Ext(nombre))
+	if len(ext) > 10 || !utf8.ValidString(ext) {
+		ext = ""
+	}
+	ruta := filepath.Join(dir, uuid.New().String()+ext)
+	if err := os.WriteFile(ruta, a.Datos, 0640); err != nil {
+		return err
+	}
+
+	adjunto := MensajeAdjunto{
+		MensajeID:   hiloID,
+		RespuestaID: respuestaID,
+		Nombre:      truncate(nombre, 255),
+		ContentType: truncate(a.ContentType, 100),
+		Tamano:      int64(len(a.Datos)),
+		Ruta:        ruta,
+	}
+	if err := db.Create(&adjunto).Error; err != nil {
+		os.Remove(ruta)
+		return err
+	}
+	return nil
+}
+
+// notifyThreadActivity avisa de un mensaje entrante: en la aplicación a quien tiene
+// asignada la conversación o, si nadie la tiene, por email al equipo
+func notifyThreadActivity(hilo *ContactMessage, from *mail.Address, cuerpo string) {
+	if hilo.AsignadoID != nil {
+		publishNotification(*hilo.AsignadoID, NotifTipoMensajeRecibido, "Nuevo mensaje de "+hilo.Name,
+			truncate(cuerpo, 200), fmt.Sprintf("/admin/messages/%d", hilo.ID))
+		return
+	}
+	if err := sendContactEmail(ContactRequest{Name: hilo.Name, Email: from.Address, Phone: hilo.Phone, Message: cuerpo}); err != nil {
+		log.Printf("Error encolando aviso de correo entrante: %v", err)
+	}
+}
+
+// inboundEmailMaxBytes limita el tamaño del correo aceptado (25 MB por defecto)
+func inboundEmailMaxBytes() int64 {
+	return int64(parseIntEnv("INBOUND_EMAIL_MAX_BYTES", 25*1024*1024))
+}
+
+// readInboundEmail obtiene el mensaje original: el cuerpo de la petición o, si llega
+// como formulario, el campo "email" (SendGrid) o "body-mime" (Mailgun)
+func readInboundEmail(c *gin.Context) ([]byte, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, inboundEmailMaxBytes())
+	contentType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if contentType == "multipart/form-data" || contentType == "application/x-www-form-urlencoded" {
+		if err := c.Request.ParseMultipartForm(inboundEmailMaxBytes()); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			return nil, err
+		}
+		raw := firstNonEmpty(c.Request.PostFormValue("email"), c.Request.PostFormValue("body-mime"))
+		if raw == "" {
+			return nil, fmt.Errorf("%w: falta el campo email", ErrInvalidInboundEmail)
+		}
+		return []byte(raw), nil
+	}
+	return io.ReadAll(c.Request.Body)
+}
+
+// inboundEmailHandler recibe un correo en bruto y lo añade a su conversación de soporte.
+// Se autentica con el secreto compartido INBOUND_EMAIL_SECRET.
+func inboundEmailHandler(c *gin.Context) {
+	secreto := getEnv("INBOUND_EMAIL_SECRET", "")
+	if secreto == "" {
+		SendErrorResponse(c, ErrInboundDisabled, http.StatusServiceUnavailable)
+		return
+	}
+	recibido := c.GetHeader("X-Inbound-Secret")
+	if recibido == "" {
+		recibido = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	}
+	if !hmac.Equal([]byte(recibido), []byte(secreto)) {
+		SendErrorResponse(c, ErrUnauthorized, http.StatusUnauthorized)
+		return
+	}
+
+	raw, err := readInboundEmail(c)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			SendErrorResponse(c, errors.New("el correo supera el tamaño máximo permitido"), http.StatusRequestEntityTooLarge)
+			return
+		}
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	correo, err := parseInboundEmail(raw)
+	if err != nil {
+		log.Printf("Correo entrante descartado: %v", err)
+		SendErrorResponse(c, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Se responde 200 en los casos que no deben reintentarse
+	if correo.Automatico {
+		log.Printf("Correo automático de %s ignorado: %s", correo.From.Address, correo.Subject)
+		SendSuccessResponse(c, gin.H{"message": "Correo automático ignorado", "ignorado": true})
+		return
+	}
+	if isDuplicateInboundEmail(correo.MessageID) {
+		SendSuccessResponse(c, gin.H{"message": "Correo ya recibido", "duplicado": true})
+		return
+	}
+
+	hilo, respuesta, err := ingestInboundEmail(correo)
+	if err != nil {
+		if errors.Is(err, ErrInvalidInboundEmail) {
+			SendErrorResponse(c, err, http.StatusUnprocessableEntity)
+			return
+		}
+		log.Printf("Error al guardar el correo entrante de %s: %v", correo.From.Address, err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Correo entrante de %s añadido a la conversación %d", correo.From.Address, hilo.ID)
+	resultado := gin.H{"message": "Correo recibido", "mensaje_id": hilo.ID}
+	if respuesta != nil {
+		resultado["respuesta_id"] = respuesta.ID
+	}
+	SendSuccessResponse(c, resultado)
+}
+
+// downloadMessageAttachment sirve un adjunto de una conversación
+func downloadMessageAttachment(c *gin.Context) {
+	var adjunto MensajeAdjunto
+	if err := db.Where("id = ? AND mensaje_id = ?", c.Param("attachmentId"), c.Param("id")).First(&adjunto).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+	if _, err := os.Stat(adjunto.Ruta); err != nil {
+		log.Printf("Adjunto %d sin fichero en %s: %v", adjunto.ID, adjunto.Ruta, err)
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	// Nunca se muestra en línea: el contenido viene de fuera y podría ser HTML activo
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.FileAttachment(adjunto.Ruta, adjunto.Nombre)
+}
@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestStripQuotedText(t *testing.T) {
+	tests := []struct {
+		name  string
+		texto string
+		want  string
+	}{
+		{
+			name:  "sin cita",
+			texto: "Gracias, ya funciona.\n",
+			want:  "Gracias, ya funciona.",
+		},
+		{
+			name:  "cabecera de Gmail en español",
+			texto: "Perfecto, gracias.\n\nEl lun, 3 mar 2025 a las 10:00, Soporte <soporte@example.com> escribió:\n> ¿Sigue el problema?\n",
+			want:  "Perfecto, gracias.",
+		},
+		{
+			name:  "cabecera en inglés partida en dos líneas",
+			texto: "Thanks!\n\nOn Mon, Mar 3, 2025 at 10:00 AM Soporte Cursos <soporte@example.com>\nwrote:\n> Hola\n",
+			want:  "Thanks!",
+		},
+		{
+			name:  "separador de Outlook",
+			texto: "Lo reviso mañana.\r\n\r\n-----Mensaje original-----\r\nDe: Soporte\r\nEnviado: lunes\r\n",
+			want:  "Lo reviso mañana.",
+		},
+		{
+			name:  "bloque De/Enviado sin separador",
+			texto: "Adjunto la captura.\n\n*De:* Soporte <soporte@example.com>\n*Enviado:* lunes, 3 de marzo de 2025\n",
+			want:  "Adjunto la captura.",
+		},
+		{
+			name:  "firma",
+			texto: "Hecho.\n\n-- \nAna García\nTel. 600 000 000\n",
+			want:  "Hecho.",
+		},
+		{
+			name:  "respuesta intercalada conserva el texto propio",
+			texto: "> ¿Qué navegador usas?\nFirefox 125\n> ¿Y el sistema?\nLinux\n",
+			want:  "Firefox 125\nLinux",
+		},
+		{
+			name:  "una línea que empieza por «el» no es una cita",
+			texto: "El curso me ha gustado mucho.\nGracias",
+			want:  "El curso me ha gustado mucho.\nGracias",
+		},
+		{
+			name:  "solo cita devuelve el texto completo",
+			texto: "> Texto citado\n> más cita\n",
+			want:  "> Texto citado\n> más cita",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripQuotedText(tt.texto); got != tt.want {
+				t.Errorf("stripQuotedText = %q, quiero %q", got, tt.want)
+			}
+		})
+	}
+}
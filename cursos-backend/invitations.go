@@ -0,0 +1,427 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Invitacion permite dar de alta a personal con un rol distinto al de estudiante.
+// El token solo se envía por email; en la base de datos se guarda su hash.
+type Invitacion struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	Email         string     `gorm:"size:100;not null;index" json:"email"`
+	Rol           string     `gorm:"size:20;not null" json:"rol"`
+	TokenHash     string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	InvitadoPorID uint       `gorm:"not null" json:"invitado_por_id"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	AceptadaEn    *time.Time `json:"aceptada_en"`
+	UsuarioID     *uint      `json:"usuario_id"` // Cuenta creada al aceptar
+	RevocadaEn    *time.Time `json:"revocada_en"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (Invitacion) TableName() string {
+	return "invitaciones"
+}
+
+// Estado calcula el estado actual de la invitación
+func (i Invitacion) Estado() string {
+	switch {
+	case i.AceptadaEn != nil:
+		return "aceptada"
+	case i.RevocadaEn != nil:
+		return "revocada"
+	case time.Now().After(i.ExpiresAt):
+		return "expirada"
+	default:
+		return "pendiente"
+	}
+}
+
+// Estructuras para solicitudes de invitación
+type InvitacionRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"required"`
+}
+
+type AcceptInvitationRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Nombre   string `json:"nombre" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// invitationTTL devuelve la validez de las invitaciones (por defecto 7 días)
+func invitationTTL() time.Duration {
+	return parseDurationEnv("INVITATION_TTL", 7*24*time.Hour)
+}
+
+// invitacionResponse añade el estado calculado a la invitación
+func invitacionResponse(inv Invitacion) gin.H {
+	return gin.H{
+		"id":              inv.ID,
+		"email":           inv.Email,
+		"rol":             inv.Rol,
+		"estado":          inv.Estado(),
+		"invitado_por_id": inv.InvitadoPorID,
+		"expires_at":      inv.ExpiresAt,
+		"aceptada_en":     inv.AceptadaEn,
+		"usuario_id":      inv.UsuarioID,
+		"revocada_en":     inv.RevocadaEn,
+		"created_at":      inv.CreatedAt,
+	}
+}
+
+// sendInvitationEmail envía el enlace de la invitación. Devuelve el enlace para
+// poder mostrarlo en desarrollo.
+func sendInvitationEmail(inv Invitacion, invitador Usuario, token string) (string, error) {
+	frontendURL := getEnv("FRONTEND_URL", "http://localhost:3000")
+	inviteLink := frontendURL + "/invitation/" + token
+
+	// La persona invitada aún no tiene cuenta: se usa el idioma por defecto
+	return inviteLink, sendTemplateEmail(PlantillaInvitacion, defaultEmailLocale(), EmailMessage{To: []string{inv.Email}}, invitationEmailData{
+		InvitadoPor: invitador.Nombre,
+		Rol:         inv.Rol,
+		InviteLink:  inviteLink,
+		Validez:     formatDuracion(time.Until(inv.ExpiresAt).Round(time.Hour)),
+	})
+}
+
+// invitationEmailData son los datos de la plantilla de invitación
+type invitationEmailData struct {
+	InvitadoPor string
+	Rol         string
+	InviteLink  string
+	Validez     string
+}
+
+// findPendingInvitation busca una invitación vigente a partir del token en claro
+func findPendingInvitation(tx *gorm.DB, token string) (*Invitacion, error) {
+	var inv Invitacion
+	err := tx.Where("token_hash = ? AND aceptada_en IS NULL AND revocada_en IS NULL AND expires_at > ?", hashToken(token), time.Now()).
+		First(&inv).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// createInvitation invita a un email con un rol determinado
+func createInvitation(c *gin.Context) {
+	var req InvitacionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	userValue, _ := c.Get("user")
+	adminUser := userValue.(Usuario)
+
+	email := strings.TrimSpace(strings.ToLower(req.Email))
+
+	if !roleExists(req.Role) {
+		SendErrorResponse(c, errors.New("rol no válido"), http.StatusBadRequest)
+		return
+	}
+	if !canGrantRole(adminUser.Role, req.Role) {
+		SendErrorResponse(c, errors.New("no puedes invitar con un rol con permisos que tú no tienes"), http.StatusForbidden)
+		return
+	}
+
+	var existente Usuario
+	if err := db.Where("email = ?", email).First(&existente).Error; err == nil {
+		SendErrorResponse(c, errors.New("ya existe una cuenta con ese email; cambia su rol desde la gestión de usuarios"), http.StatusConflict)
+		return
+	}
+
+	// Una nueva invitación sustituye a las pendientes para el mismo email
+	if err := db.Model(&Invitacion{}).
+		Where("email = ? AND aceptada_en IS NULL AND revocada_en IS NULL", email).
+		Update("revocada_en", time.Now()).Error; err != nil {
+		log.Printf("Error al revocar invitaciones anteriores para %s: %v", email, err)
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		log.Printf("Error al generar token de invitación: %v", err)
+		SendErrorResponse(c, ErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	inv := Invitacion{
+		Email:         email,
+		Rol:           req.Role,
+		TokenHash:     hashToken(token),
+		InvitadoPorID: adminUser.ID,
+		ExpiresAt:     time.Now().Add(invitationTTL()),
+	}
+	if err := db.Create(&inv).Error; err != nil {
+		log.Printf("Error al crear invitación: %v", err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	inviteLink, err := sendInvitationEmail(inv, adminUser, token)
+	if err != nil {
+		log.Printf("Error al enviar invitación a %s: %v", email, err)
+	}
+
+	logActivity(c, adminUser.ID, "create_invitation",
+		fmt.Sprintf("Admin invitó a %s con rol '%s'", email, req.Role))
+
+	response := gin.H{
+		"success": true,
+		"message": "Invitación enviada correctamente",
+		"data":    invitacionResponse(inv),
+	}
+	if err != nil {
+		response["message"] = "Invitación creada, pero no se pudo enviar el email"
+	}
+	if getEnv("APP_ENV", "development") == "development" {
+		response["inviteLink"] = inviteLink
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// listInvitations devuelve las invitaciones, opcionalmente filtradas por estado
+func listInvitations(c *gin.Context) {
+	query := db.Model(&Invitacion{}).Order("created_at desc")
+
+	now := time.Now()
+	switch c.Query("estado") {
+	case "":
+	case "pendiente":
+		query = query.Where("aceptada_en IS NULL AND revocada_en IS NULL AND expires_at > ?", now)
+	case "aceptada":
+		query = query.Where("aceptada_en IS NOT NULL")
+	case "revocada":
+		query = query.Where("revocada_en IS NOT NULL")
+	case "expirada":
+		query = query.Where("aceptada_en IS NULL AND revocada_en IS NULL AND expires_at <= ?", now)
+	default:
+		SendErrorResponse(c, errors.New("estado no válido"), http.StatusBadRequest)
+		return
+	}
+
+	var invitaciones []Invitacion
+	if err := query.Find(&invitaciones).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	data := make([]gin.H, 0, len(invitaciones))
+	for _, inv := range invitaciones {
+		data = append(data, invitacionResponse(inv))
+	}
+
+	SendSuccessResponse(c, data)
+}
+
+// revokeInvitation anula una invitación pendiente
+func revokeInvitation(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		SendErrorResponse(c, ErrInvalidRequest, http.StatusBadRequest)
+		return
+	}
+
+	var inv Invitacion
+	if err := db.First(&inv, id).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	if inv.Estado() != "pendiente" {
+		SendErrorResponse(c, fmt.Errorf("la invitación ya está %s", inv.Estado()), http.StatusBadRequest)
+		return
+	}
+
+	userValue, _ := c.Get("user")
+	adminUser := userValue.(Usuario)
+
+	if !canGrantRole(adminUser.Role, inv.Rol) {
+		SendErrorResponse(c, errors.New("no puedes revocar una invitación con un rol con permisos que tú no tienes"), http.StatusForbidden)
+		return
+	}
+
+	if err := db.Model(&inv).Update("revocada_en", time.Now()).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, adminUser.ID, "revoke_invitation",
+		fmt.Sprintf("Admin revocó la invitación ID: %d para %s", inv.ID, inv.Email))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Invitación revocada correctamente",
+	})
+}
+
+// resendInvitation genera un enlace nuevo (el anterior deja de valer) y lo reenvía
+func resendInvitation(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		SendErrorResponse(c, ErrInvalidRequest, http.StatusBadRequest)
+		return
+	}
+
+	var inv Invitacion
+	if err := db.First(&inv, id).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	if estado := inv.Estado(); estado != "pendiente" && estado != "expirada" {
+		SendErrorResponse(c, fmt.Errorf("la invitación ya está %s", estado), http.StatusBadRequest)
+		return
+	}
+
+	userValue, _ := c.Get("user")
+	adminUser := userValue.(Usuario)
+
+	if !canGrantRole(adminUser.Role, inv.Rol) {
+		SendErrorResponse(c, errors.New("no puedes invitar con un rol con permisos que tú no tienes"), http.StatusForbidden)
+		return
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		log.Printf("Error al generar token de invitación: %v", err)
+		SendErrorResponse(c, ErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	// El enlace anterior deja de valer y el plazo vuelve a empezar
+	inv.ExpiresAt = time.Now().Add(invitationTTL())
+	if err := db.Model(&inv).Updates(map[string]interface{}{
+		"token_hash": hashToken(token),
+		"expires_at": inv.ExpiresAt,
+	}).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	inviteLink, err := sendInvitationEmail(inv, adminUser, token)
+	if err != nil {
+		log.Printf("Error al reenviar invitación %d: %v", inv.ID, err)
+		SendErrorResponse(c, ErrEmailSendError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, adminUser.ID, "resend_invitation",
+		fmt.Sprintf("Admin reenvió la invitación ID: %d para %s", inv.ID, inv.Email))
+
+	response := gin.H{
+		"success": true,
+		"message": "Invitación reenviada correctamente",
+	}
+	if getEnv("APP_ENV", "development") == "development" {
+		response["inviteLink"] = inviteLink
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// validateInvitation permite al frontend mostrar el email y rol antes de aceptar
+func validateInvitation(c *gin.Context) {
+	inv, err := findPendingInvitation(db, c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invitación inválida o expirada", "valid": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"valid": true, "email": inv.Email, "role": inv.Rol})
+}
+
+// acceptInvitation completa el alta con el rol de la invitación e inicia sesión
+func acceptInvitation(c *gin.Context) {
+	var req AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	// La invitación se busca antes para validar la contraseña también contra su email;
+	// se vuelve a comprobar con bloqueo dentro de la transacción
+	pendiente, err := findPendingInvitation(db, req.Token)
+	if err != nil {
+		SendErrorResponse(c, errors.New("invitación inválida o expirada"), http.StatusBadRequest)
+		return
+	}
+	if !checkPasswordPolicy(c, "password", req.Password, pendiente.Email, req.Nombre) {
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		SendErrorResponse(c, errors.New("error al procesar la contraseña"), http.StatusInternalServerError)
+		return
+	}
+
+	var user Usuario
+	var inv *Invitacion
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		inv, err = findPendingInvitation(tx.Clauses(clause.Locking{Strength: "UPDATE"}), req.Token)
+		if err != nil {
+			return err
+		}
+
+		var existente Usuario
+		if err := tx.Where("email = ?", inv.Email).First(&existente).Error; err == nil {
+			return ErrEmailExists
+		}
+
+		// El enlace llegó al email invitado, así que la dirección queda verificada
+		now := time.Now()
+		user = Usuario{
+			Nombre:            req.Nombre,
+			Email:             inv.Email,
+			Password:          string(hashedPassword),
+			Role:              inv.Rol,
+			EmailVerificado:   true,
+			EmailVerificadoEn: &now,
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(inv).Updates(map[string]interface{}{
+			"aceptada_en": now,
+			"usuario_id":  user.ID,
+		}).Error
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidToken):
+			SendErrorResponse(c, errors.New("invitación inválida o expirada"), http.StatusBadRequest)
+		case errors.Is(err, ErrEmailExists):
+			SendErrorResponse(c, ErrEmailExists, http.StatusConflict)
+		default:
+			log.Printf("Error al aceptar invitación: %v", err)
+			SendErrorResponse(c, errors.New("error al crear usuario"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	logActivity(c, user.ID, "accept_invitation",
+		fmt.Sprintf("Cuenta creada por invitación ID: %d con rol '%s'", inv.ID, inv.Rol))
+
+	finishLogin(c, user, "invitation")
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// defaultJWTSecret es el valor histórico de JWT_SECRET; no se admite en producción
+const defaultJWTSecret = "mi_clave_secreta_muy_segura"
+
+// jwtKey es una clave de firma o verificación identificada por su kid
+type jwtKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private interface{} // nil si la clave solo sirve para verificar (clave retirada)
+	public  interface{}
+	modTime time.Time
+}
+
+// jwtKeyRing contiene las claves cargadas y la que se usa para firmar
+type jwtKeyRing struct {
+	mu     sync.RWMutex
+	keys   map[string]*jwtKey
+	active *jwtKey
+}
+
+var keyRing = &jwtKeyRing{keys: map[string]*jwtKey{}}
+
+// jwtKeyActivationDelay es el tiempo que una clave nueva solo sirve para verificar antes
+// de empezar a firmar, para que todas las instancias la hayan cargado (por defecto 2 minutos)
+func jwtKeyActivationDelay() time.Duration {
+	return parseDurationEnv("JWT_KEY_ACTIVATION_DELAY", 2*time.Minute)
+}
+
+// initJWTKeys carga las claves de JWT_KEYS_DIR. Sin directorio se mantiene HS256 con
+// JWT_SECRET, salvo en producción si el secreto falta o es el valor por defecto.
+func initJWTKeys() error {
+	dir := getEnv("JWT_KEYS_DIR", "")
+	if dir == "" {
+		secret := getEnv("JWT_SECRET", "")
+		if getEnv("APP_ENV", "development") == "production" && (secret == "" || secret == defaultJWTSecret) {
+			return errors.New("JWT_KEYS_DIR o un JWT_SECRET propio son obligatorios en producción")
+		}
+		if secret == "" {
+			log.Printf("Advertencia: JWT_SECRET no configurado, usando el secreto por defecto (solo desarrollo)")
+			secret = defaultJWTSecret
+		}
+
+		hs := &jwtKey{kid: "hs256", method: jwt.SigningMethodHS256, private: []byte(secret), public: []byte(secret)}
+		keyRing.replace(map[string]*jwtKey{hs.kid: hs})
+		return nil
+	}
+
+	if err := keyRing.loadDir(dir); err != nil {
+		return err
+	}
+
+	go watchJWTKeys(dir)
+	return nil
+}
+
+// watchJWTKeys recarga las claves periódicamente y al recibir SIGHUP, sin reiniciar
+func watchJWTKeys(dir string) {
+	interval := parseDurationEnv("JWT_KEYS_RELOAD_INTERVAL", time.Minute)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-hup:
+			log.Printf("SIGHUP recibido, recargando claves JWT")
+		}
+		if err := keyRing.loadDir(dir); err != nil {
+			// Se conservan las claves anteriores
+			log.Printf("Error al recargar claves JWT: %v", err)
+		}
+	}
+}
+
+// loadDir lee todas las claves PEM del directorio. El nombre del fichero (sin extensión)
+// es el kid. Las claves públicas sueltas solo verifican tokens ya emitidos.
+func (r *jwtKeyRing) loadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*jwtKey)
+	for _, file := range files {
+		key, err := loadJWTKeyFile(file)
+		if err != nil {
+			log.Printf("Advertencia: clave JWT %s ignorada: %v", file, err)
+			continue
+		}
+		if existente, ok := keys[key.kid]; ok && existente.private != nil {
+			continue // Si hay privada y pública con el mismo kid, basta la privada
+		}
+		keys[key.kid] = key
+	}
+
+	return r.replace(keys)
+}
+
+// replace sustituye el conjunto de claves y elige la clave activa: la privada más
+// reciente que ya haya superado el periodo de activación
+func (r *jwtKeyRing) replace(keys map[string]*jwtKey) error {
+	var privadas []*jwtKey
+	for _, k := range keys {
+		if k.private != nil {
+			privadas = append(privadas, k)
+		}
+	}
+	if len(privadas) == 0 {
+		return errors.New("no hay ninguna clave privada para firmar tokens")
+	}
+
+	sort.Slice(privadas, func(i, j int) bool {
+		if privadas[i].modTime.Equal(privadas[j].modTime) {
+			return privadas[i].kid < privadas[j].kid
+		}
+		return privadas[i].modTime.Before(privadas[j].modTime)
+	})
+
+	// Si ninguna ha madurado (p. ej. primer arranque) se usa la más antigua
+	active := privadas[0]
+	limite := time.Now().Add(-jwtKeyActivationDelay())
+	for _, k := range privadas {
+		if !k.modTime.After(limite) {
+			active = k
+		}
+	}
+
+	r.mu.Lock()
+	anterior := r.active
+	r.keys = keys
+	r.active = active
+	r.mu.Unlock()
+
+	if anterior == nil || anterior.kid != active.kid {
+		log.Printf("Clave de firma JWT activa: %s (%s), %d claves cargadas", active.kid, active.method.Alg(), len(keys))
+	}
+	return nil
+}
+
+// signingKey devuelve la clave con la que se firman los tokens nuevos
+func (r *jwtKeyRing) signingKey() *jwtKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.active
+}
+
+// verificationKey busca la clave por kid
+func (r *jwtKeyRing) verificationKey(kid string) *jwtKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.keys[kid]
+}
+
+// loadJWTKeyFile interpreta un fichero PEM con una clave RSA o Ed25519
+func loadJWTKeyFile(path string) (*jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no es un fichero PEM")
+	}
+
+	key := &jwtKey{
+		kid:     strings.TrimSuffix(strings.TrimSuffix(filepath.Base(path), ".pem"), ".pub"),
+		modTime: info.ModTime(),
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("tipo PEM no soportado: %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.private, key.public = k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.public = k
+	case ed25519.PrivateKey:
+		key.private, key.public = k, k.Public()
+	case ed25519.PublicKey:
+		key.public = k
+	default:
+		return nil, errors.New("solo se admiten claves RSA y Ed25519")
+	}
+
+	switch pub := key.public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, errors.New("las claves RSA deben tener al menos 2048 bits")
+		}
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+	}
+
+	return key, nil
+}
+
+// jwksKeys devuelve las claves públicas en formato JWK (RFC 7517)
+func (r *jwtKeyRing) jwksKeys() []gin.H {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	kids := make([]string, 0, len(r.keys))
+	for kid := range r.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	keys := make([]gin.H, 0, len(kids))
+	for _, kid := range kids {
+		k := r.keys[kid]
+		switch pub := k.public.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, gin.H{
+				"kty": "RSA",
+				"use": "sig",
+				"alg": k.method.Alg(),
+				"kid": k.kid,
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, gin.H{
+				"kty": "OKP",
+				"use": "sig",
+				"alg": k.method.Alg(),
+				"kid": k.kid,
+				"crv": "Ed25519",
+				"x":   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+		// Las claves simétricas (HS256) nunca se publican
+	}
+	return keys
+}
+
+// getJWKS publica las claves públicas para que otros servicios verifiquen nuestros tokens
+func getJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": keyRing.jwksKeys()})
+}
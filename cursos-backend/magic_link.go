@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// EnlaceMagico es un enlace de acceso sin contraseña de un solo uso.
+// A diferencia de PasswordReset, el token nunca se guarda en claro.
+type EnlaceMagico struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UsuarioID uint       `gorm:"not null;index" json:"usuario_id"`
+	TokenHash string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	IP        string     `gorm:"size:50" json:"ip"` // IP que lo solicitó
+	ExpiresAt time.Time  `gorm:"index" json:"expires_at"`
+	UsadoEn   *time.Time `json:"usado_en"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (EnlaceMagico) TableName() string {
+	return "enlaces_magicos"
+}
+
+// Estructuras para solicitudes de enlace mágico
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type MagicLinkConsumeRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// magicLinkTTL devuelve la validez del enlace (por defecto 15 minutos)
+func magicLinkTTL() time.Duration {
+	return parseDurationEnv("MAGIC_LINK_TTL", 15*time.Minute)
+}
+
+// signMagicLink firma el token con el usuario y la caducidad del enlace
+func signMagicLink(token string, enlace EnlaceMagico) string {
+	return token + "." + linkSignature("magic_link", token, fmt.Sprint(enlace.UsuarioID), fmt.Sprint(enlace.ExpiresAt.Unix()))
+}
+
+// requestMagicLink envía un enlace de acceso. La respuesta es la misma exista o no el email.
+func requestMagicLink(c *gin.Context) {
+	var req MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	// Mismos límites por cuenta que el login con contraseña
+	if !checkRateLimit(c, ruleLoginEmail, req.Email) {
+		return
+	}
+
+	response := gin.H{
+		"message": "Si el email existe en nuestra base de datos, recibirás un enlace para iniciar sesión.",
+	}
+
+	var user Usuario
+	if err := db.Where("email = ?", req.Email).First(&user).Error; err != nil {
+		log.Printf("Enlace mágico solicitado para email no registrado: %s", req.Email)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	if accountLockedFor(user) > 0 {
+		log.Printf("Enlace mágico denegado: cuenta bloqueada. Usuario ID: %d", user.ID)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		log.Printf("Error al generar enlace mágico: %v", err)
+		SendErrorResponse(c, ErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	// Solo un enlace vigente por usuario
+	if err := db.Model(&EnlaceMagico{}).
+		Where("usuario_id = ? AND usado_en IS NULL AND expires_at > ?", user.ID, time.Now()).
+		Update("expires_at", time.Now()).Error; err != nil {
+		log.Printf("Error al invalidar enlaces mágicos anteriores: %v", err)
+	}
+
+	ttl := magicLinkTTL()
+	enlace := EnlaceMagico{
+		UsuarioID: user.ID,
+		TokenHash: hashToken(token),
+		IP:        c.ClientIP(),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := db.Create(&enlace).Error; err != nil {
+		log.Printf("Error al guardar enlace mágico: %v", err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	frontendURL := getEnv("FRONTEND_URL", "http://localhost:3000")
+	loginLink := frontendURL + "/magic-link/" + signMagicLink(token, enlace)
+
+	err = sendTemplateEmail(PlantillaEnlaceMagico, userEmailLocale(user), EmailMessage{To: []string{user.Email}}, magicLinkEmailData{
+		Name:      user.Nombre,
+		LoginLink: loginLink,
+		Validez:   formatDuracion(ttl),
+	})
+	if err != nil {
+		log.Printf("Error al enviar enlace mágico a %s: %v", user.Email, err)
+	}
+
+	logActivity(c, user.ID, "magic_link_requested", "Enlace de acceso sin contraseña solicitado")
+
+	if getEnv("APP_ENV", "development") == "development" {
+		response["magicLink"] = loginLink
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// magicLinkEmailData son los datos de la plantilla del enlace mágico
+type magicLinkEmailData struct {
+	Name      string
+	LoginLink string
+	Validez   string
+}
+
+// consumeMagicLink canjea un enlace válido por una sesión
+func consumeMagicLink(c *gin.Context) {
+	var req MagicLinkConsumeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	token, _, ok := strings.Cut(req.Token, ".")
+	if !ok {
+		SendErrorResponse(c, ErrInvalidToken, http.StatusUnauthorized)
+		return
+	}
+
+	var enlace EnlaceMagico
+	if err := db.Where("token_hash = ? AND usado_en IS NULL AND expires_at > ?", hashToken(token), time.Now()).
+		First(&enlace).Error; err != nil {
+		log.Printf("Enlace mágico inválido o caducado desde IP %s", c.ClientIP())
+		SendErrorResponse(c, ErrInvalidToken, http.StatusUnauthorized)
+		return
+	}
+
+	if !hmac.Equal([]byte(signMagicLink(token, enlace)), []byte(req.Token)) {
+		log.Printf("Firma de enlace mágico inválida desde IP %s", c.ClientIP())
+		SendErrorResponse(c, ErrInvalidToken, http.StatusUnauthorized)
+		return
+	}
+
+	// Marcar como usado de forma atómica: solo una petición puede canjearlo
+	result := db.Model(&EnlaceMagico{}).
+		Where("id = ? AND usado_en IS NULL", enlace.ID).
+		Update("usado_en", time.Now())
+	if result.Error != nil || result.RowsAffected == 0 {
+		SendErrorResponse(c, ErrInvalidToken, http.StatusUnauthorized)
+		return
+	}
+
+	var user Usuario
+	if err := db.First(&user, enlace.UsuarioID).Error; err != nil {
+		SendErrorResponse(c, ErrInvalidToken, http.StatusUnauthorized)
+		return
+	}
+
+	if restante := accountLockedFor(user); restante > 0 {
+		sendAccountLockedResponse(c, restante)
+		return
+	}
+
+	// Abrir el enlace demuestra que el email es del usuario
+	if !user.EmailVerificado {
+		if err := claimUnverifiedAccount(c, &user); err != nil {
+			log.Printf("Error al verificar la cuenta %d con enlace mágico: %v", user.ID, err)
+			SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	completeLogin(c, user, "magic_link")
+}
+
+// claimUnverifiedAccount marca el email como verificado cuando su dueño abre un enlace
+// mágico. La cuenta pudo registrarla otra persona con ese email antes que él: la
+// contraseña y el 2FA que tuviera se descartan y se cierran sus sesiones, de modo que
+// solo quien controla el buzón conserva el acceso y tiene que crear una contraseña nueva
+// con "olvidé mi contraseña".
+func claimUnverifiedAccount(c *gin.Context, user *Usuario) error {
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"email_verificado":    true,
+			"email_verificado_en": now,
+			"password":            "",
+			"two_factor_enabled":  false,
+			"totp_secret":         "",
+			"totp_last_step":      0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("usuario_id = ?", user.ID).Delete(&CodigoRecuperacion{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	if err := revokeAllSessions(user.ID, "email_claimed", ""); err != nil {
+		return err
+	}
+
+	user.EmailVerificado = true
+	user.EmailVerificadoEn = &now
+	user.Password = ""
+	user.TwoFactorEnabled = false
+	logActivity(c, user.ID, "claim_unverified_account", "Email verificado con enlace mágico: contraseña, 2FA y sesiones anteriores descartados")
+	return nil
+}
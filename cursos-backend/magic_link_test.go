@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+// TestClaimUnverifiedAccount comprueba que verificar el email con un enlace mágico deja
+// fuera a quien hubiera registrado la cuenta antes que el dueño del buzón
+func TestClaimUnverifiedAccount(t *testing.T) {
+	useTestDB(t)
+	user := createTestUser(t, RolUser)
+	if err := db.Model(&user).Updates(map[string]interface{}{
+		"email_verificado":   false,
+		"password":           "$2a$10$hashdelaotrapersona",
+		"two_factor_enabled": true,
+		"totp_secret":        "JBSWY3DPEHPK3PXP",
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&CodigoRecuperacion{UsuarioID: user.ID, CodigoHash: hashToken("codigo")}).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Where("usuario_id = ?", user.ID).Delete(&CodigoRecuperacion{}) })
+
+	previa, err := createSession(testContext(), user)
+	if err != nil {
+		t.Fatalf("createSession: %v", err)
+	}
+	claims, err := parseAccessToken(previa.AccessToken, false)
+	if err != nil {
+		t.Fatalf("parseAccessToken: %v", err)
+	}
+
+	if err := claimUnverifiedAccount(testContext(), &user); err != nil {
+		t.Fatalf("claimUnverifiedAccount: %v", err)
+	}
+
+	var guardado Usuario
+	if err := db.First(&guardado, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !guardado.EmailVerificado || guardado.EmailVerificadoEn == nil {
+		t.Error("el email no ha quedado verificado")
+	}
+	if guardado.Password != "" {
+		t.Error("la contraseña anterior sigue sirviendo")
+	}
+	if guardado.TwoFactorEnabled || guardado.TOTPSecret != "" {
+		t.Error("el 2FA configurado antes de verificar sigue activo")
+	}
+	var codigos int64
+	db.Model(&CodigoRecuperacion{}).Where("usuario_id = ?", user.ID).Count(&codigos)
+	if codigos != 0 {
+		t.Errorf("quedan %d códigos de recuperación", codigos)
+	}
+	if isSessionActive(claims.SessionID) {
+		t.Error("la sesión abierta antes de verificar sigue activa")
+	}
+}
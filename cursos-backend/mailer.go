@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EmailAttachment es un fichero adjunto
+type EmailAttachment struct {
+	Nombre      string `json:"nombre"`
+	ContentType string `json:"content_type"`
+	Datos       []byte `json:"datos"`
+}
+
+// EmailMessage es un correo listo para enviar con cualquier Mailer
+type EmailMessage struct {
+	To          []string          `json:"to"`
+	From        string            `json:"from,omitempty"` // Vacío: EMAIL_FROM
+	ReplyTo     string            `json:"reply_to,omitempty"`
+	Subject     string            `json:"subject"`
+	HTML        string            `json:"html,omitempty"`
+	Text        string            `json:"text,omitempty"` // Vacío: se genera a partir del HTML
+	Headers     map[string]string `json:"headers,omitempty"`
+	Attachments []EmailAttachment `json:"attachments,omitempty"`
+
+	// Sensitive marca los correos con enlaces de acceso (recuperación, verificación...):
+	// el outbox no expone su cuerpo y lo borra tras enviarlo
+	Sensitive bool `json:"-"`
+}
+
+// Mailer entrega correos. Hay implementaciones para SMTP, ficheros (maildir) y memoria.
+type Mailer interface {
+	Send(msg EmailMessage) error
+}
+
+// Modos de cifrado de la conexión SMTP
+const (
+	SMTPTLSStartTLS = "starttls" // Conexión en claro que se cifra con STARTTLS (puerto 587)
+	SMTPTLSImplicit = "tls"      // TLS desde el inicio (puerto 465)
+	SMTPTLSNone     = "none"     // Sin cifrado: solo para servidores locales de pruebas
+)
+
+// defaultFromAddress devuelve el remitente configurado, con nombre si lo hay
+func defaultFromAddress() string {
+	from := getEnv("EMAIL_FROM", "noreply@example.com")
+	if nombre := getEnv("EMAIL_FROM_NAME", ""); nombre != "" {
+		return (&mail.Address{Name: nombre, Address: from}).String()
+	}
+	return from
+}
+
+// SMTPMailer envía por SMTP
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	TLSMode  string
+	Timeout  time.Duration
+}
+
+// smtpMailerFromEnv lee la configuración SMTP. Es el único sitio donde se leen estas variables.
+func smtpMailerFromEnv() *SMTPMailer {
+	m := &SMTPMailer{
+		Host:     getEnv("SMTP_HOST", "smtp.gmail.com"),
+		Port:     getEnv("SMTP_PORT", "587"),
+		Username: getEnv("SMTP_USERNAME", getEnv("EMAIL_FROM", "noreply@example.com")),
+		Password: getEnv("EMAIL_PASSWORD", ""),
+		TLSMode:  getEnv("SMTP_TLS", ""),
+		Timeout:  parseDurationEnv("SMTP_TIMEOUT", 30*time.Second),
+	}
+	if m.TLSMode == "" {
+		m.TLSMode = SMTPTLSStartTLS
+		if m.Port == "465" {
+			m.TLSMode = SMTPTLSImplicit
+		}
+	}
+	return m
+}
+
+// Send abre una conexión, envía el correo y la cierra
+func (m *SMTPMailer) Send(msg EmailMessage) error {
+	if m.Password == "" && m.TLSMode != SMTPTLSNone {
+		return errors.New("la contraseña de email no está configurada")
+	}
+
+	data, err := buildMIMEMessage(msg)
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(firstNonEmpty(msg.From, defaultFromAddress()))
+	if err != nil {
+		return fmt.Errorf("remitente inválido: %v", err)
+	}
+
+	client, err := m.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if m.Password != "" {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+				return fmt.Errorf("error de autenticación SMTP: %v", err)
+			}
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return fmt.Errorf("destinatario inválido %q: %v", to, err)
+		}
+		if err := client.Rcpt(addr.Address); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// dial conecta con el servidor según el modo de cifrado
+func (m *SMTPMailer) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(m.Host, m.Port)
+	tlsConfig := &tls.Config{ServerName: m.Host, MinVersion: tls.VersionTLS12}
+	dialer := &net.Dialer{Timeout: m.Timeout}
+
+	var conn net.Conn
+	var err error
+	if m.TLSMode == SMTPTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo conectar con %s: %v", addr, err)
+	}
+	if m.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(m.Timeout))
+	}
+
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if m.TLSMode == SMTPTLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("el servidor SMTP no admite STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("error en STARTTLS: %v", err)
+		}
+	}
+	return client, nil
+}
+
+// FileMailer guarda cada correo como .eml. Con Maildir usa la estructura tmp/new/cur,
+// que pueden abrir directamente clientes como mutt o Thunderbird.
+type FileMailer struct {
+	Dir     string
+	Maildir bool
+}
+
+// Send escribe el correo completo en disco
+func (m FileMailer) Send(msg EmailMessage) error {
+	data, err := buildMIMEMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	nombre := fmt.Sprintf("%d.%s.eml", time.Now().UnixNano(), randomHex(4))
+	if !m.Maildir {
+		if err := os.MkdirAll(m.Dir, 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(m.Dir, nombre), data, 0644)
+	}
+
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(m.Dir, sub), 0755); err != nil {
+			return err
+		}
+	}
+	// Se escribe en tmp y se mueve a new para que nadie lea un fichero a medias
+	tmp := filepath.Join(m.Dir, "tmp", nombre)
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(m.Dir, "new", nombre))
+}
+
+// MemoryMailer guarda los correos en memoria; pensado para pruebas. Además del mensaje
+// guarda el correo tal como se habría enviado, para comprobar el MIME generado.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []EmailMessage
+	raw      [][]byte
+}
+
+// Send guarda una copia del correo y del MIME generado
+func (m *MemoryMailer) Send(msg EmailMessage) error {
+	raw, err := buildMIMEMessage(msg)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	m.raw = append(m.raw, raw)
+	return nil
+}
+
+// Sent devuelve los correos capturados
+func (m *MemoryMailer) Sent() []EmailMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]EmailMessage(nil), m.messages...)
+}
+
+// SentRaw devuelve los correos capturados en formato RFC 5322, en el orden de envío
+func (m *MemoryMailer) SentRaw() [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([][]byte(nil), m.raw...)
+}
+
+// Reset descarta los correos capturados
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = nil
+	m.raw = nil
+}
+
+var (
+	mailerMu      sync.Mutex
+	currentMailer Mailer
+)
+
+// getMailer devuelve el Mailer configurado con EMAIL_TRANSPORT (smtp, file, maildir o
+// memory). Si no se indica, en desarrollo con MOCK_EMAIL activo se usa file.
+func getMailer() Mailer {
+	mailerMu.Lock()
+	defer mailerMu.Unlock()
+	if currentMailer != nil {
+		return currentMailer
+	}
+
+	transporte := getEnv("EMAIL_TRANSPORT", "")
+	if transporte == "" {
+		transporte = "smtp"
+		if getEnv("APP_ENV", "development") == "development" && getEnv("MOCK_EMAIL", "true") == "true" {
+			transporte = "file"
+		}
+	}
+
+	switch transporte {
+	case "file":
+		currentMailer = FileMailer{Dir: getEnv("EMAIL_FILE_DIR", "emails")}
+	case "maildir":
+		currentMailer = FileMailer{Dir: getEnv("EMAIL_FILE_DIR", "emails"), Maildir: true}
+	case "memory":
+		currentMailer = &MemoryMailer{}
+	default:
+		if transporte != "smtp" {
+			log.Printf("Advertencia: EMAIL_TRANSPORT desconocido %q, se usa smtp", transporte)
+		}
+		currentMailer = smtpMailerFromEnv()
+	}
+	log.Printf("Transporte de email: %s", transporte)
+	return currentMailer
+}
+
+// setMailer sustituye el Mailer (p. ej. por un MemoryMailer en pruebas)
+func setMailer(m Mailer) {
+	mailerMu.Lock()
+	defer mailerMu.Unlock()
+	currentMailer = m
+}
+
+// buildMIMEMessage genera el correo en formato RFC 5322: multipart/alternative con texto
+// y HTML y, si hay adjuntos, dentro de un multipart/mixed
+func buildMIMEMessage(msg EmailMessage) ([]byte, error) {
+	if len(msg.To) == 0 {
+		return nil, errors.New("el correo no tiene destinatarios")
+	}
+	campos := append([]string{msg.From, msg.ReplyTo, msg.Subject}, msg.To...)
+	for k, v := range msg.Headers {
+		campos = append(campos, k, v)
+	}
+	for _, campo := range campos {
+		if strings.ContainsAny(campo, "\r\n") {
+			return nil, errors.New("las cabeceras del correo no pueden contener saltos de línea")
+		}
+	}
+
+	from := firstNonEmpty(msg.From, defaultFromAddress())
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("remitente inválido: %v", err)
+	}
+
+	var buf bytes.Buffer
+	writeHeader := func(k, v string) {
+		buf.WriteString(k + ": " + v + "\r\n")
+	}
+
+	writeHeader("From", fromAddr.String())
+	writeHeader("To", strings.Join(msg.To, ", "))
+	if msg.ReplyTo != "" {
+		writeHeader("Reply-To", msg.ReplyTo)
+	}
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	writeHeader("Date", time.Now().Format(time.RFC1123Z))
+	if _, ok := msg.Headers["Message-ID"]; !ok {
+		dominio := fromAddr.Address[strings.LastIndex(fromAddr.Address, "@")+1:]
+		writeHeader("Message-ID", fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), randomHex(8), dominio))
+	}
+	writeHeader("MIME-Version", "1.0")
+
+	claves := make([]string, 0, len(msg.Headers))
+	for k := range msg.Headers {
+		claves = append(claves, k)
+	}
+	sort.Strings(claves)
+	for _, k := range claves {
+		writeHeader(textproto.CanonicalMIMEHeaderKey(k), msg.Headers[k])
+	}
+
+	texto := msg.Text
+	if texto == "" && msg.HTML != "" {
+		texto = htmlToText(msg.HTML)
+	}
+
+	if len(msg.Attachments) == 0 {
+		if err := writeAlternative(&buf, texto, msg.HTML); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mixed := multipart.NewWriter(&buf)
+	writeHeader("Content-Type", "multipart/mixed; boundary="+mixed.Boundary())
+	buf.WriteString("\r\n")
+
+	var alt bytes.Buffer
+	if err := writeAlternative(&alt, texto, msg.HTML); err != nil {
+		return nil, err
+	}
+	altHeader, altBody, _ := bytes.Cut(alt.Bytes(), []byte("\r\n\r\n"))
+	part, err := mixed.CreatePart(parseMIMEHeader(string(altHeader)))
+	if err != nil {
+		return nil, err
+	}
+	part.Write(altBody)
+
+	for _, a := range msg.Attachments {
+		contentType := firstNonEmpty(a.ContentType, mime.TypeByExtension(filepath.Ext(a.Nombre)), "application/octet-stream")
+		nombre := mime.QEncoding.Encode("utf-8", filepath.Base(a.Nombre))
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", fmt.Sprintf("%s; name=%q", contentType, nombre))
+		h.Set("Content-Transfer-Encoding", "base64")
+		h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", nombre))
+		part, err := mixed.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		writeBase64Lines(part, a.Datos)
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeAlternative escribe el bloque multipart/alternative con su cabecera Content-Type.
+// Si solo hay texto, escribe una única parte text/plain.
+func writeAlternative(buf *bytes.Buffer, texto, htmlBody string) error {
+	if htmlBody == "" {
+		buf.WriteString("Content-Type: text/plain; charset=\"UTF-8\"\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+		return writeQuotedPrintable(buf, texto)
+	}
+
+	// Las partes se generan aparte porque la cabecera con el boundary va delante
+	contenido := new(bytes.Buffer)
+	alt := multipart.NewWriter(contenido)
+	for _, p := range []struct{ tipo, cuerpo string }{
+		{"text/plain", texto},
+		{"text/html", htmlBody},
+	} {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", p.tipo+"; charset=\"UTF-8\"")
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+		part, err := alt.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		if err := writeQuotedPrintable(part, p.cuerpo); err != nil {
+			return err
+		}
+	}
+	if err := alt.Close(); err != nil {
+		return err
+	}
+
+	buf.WriteString("Content-Type: multipart/alternative; boundary=" + alt.Boundary() + "\r\n\r\n")
+	_, err := buf.Write(contenido.Bytes())
+	return err
+}
+
+func writeQuotedPrintable(w interface{ Write([]byte) (int, error) }, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeBase64Lines codifica en base64 con líneas de 76 caracteres (RFC 2045)
+func writeBase64Lines(w interface{ Write([]byte) (int, error) }, datos []byte) {
+	enc := base64.StdEncoding.EncodeToString(datos)
+	for len(enc) > 76 {
+		w.Write([]byte(enc[:76] + "\r\n"))
+		enc = enc[76:]
+	}
+	w.Write([]byte(enc + "\r\n"))
+}
+
+// parseMIMEHeader convierte un bloque de cabeceras "Clave: valor" en MIMEHeader
+func parseMIMEHeader(bloque string) textproto.MIMEHeader {
+	h := textproto.MIMEHeader{}
+	for _, linea := range strings.Split(bloque, "\r\n") {
+		if k, v, ok := strings.Cut(linea, ":"); ok {
+			h.Set(strings.TrimSpace(k), strings.TrimSpace(v))
+		}
+	}
+	return h
+}
+
+var (
+	reHTMLBloque   = regexp.MustCompile(`(?i)<\s*(br|/p|/div|/h[1-6]|/li|/tr)\s*/?>`)
+	reHTMLEnlace   = regexp.MustCompile(`(?is)<a\s[^>]*href="([^"]+)"[^>]*>(.*?)</a>`)
+	reHTMLOmitir   = regexp.MustCompile(`(?is)<(style|script|head)[^>]*>.*?</(style|script|head)>`)
+	reHTMLEtiqueta = regexp.MustCompile(`(?s)<[^>]+>`)
+	reEspacios     = regexp.MustCompile(`[ \t]+`)
+	reLineasVacias = regexp.MustCompile(`\n\s*\n\s*\n+`)
+)
+
+// htmlToText genera una versión en texto plano aceptable de un correo HTML
+func htmlToText(s string) string {
+	s = reHTMLOmitir.ReplaceAllString(s, "")
+	s = reHTMLEnlace.ReplaceAllString(s, "$2 ($1)")
+	s = reHTMLBloque.ReplaceAllString(s, "\n")
+	s = reHTMLEtiqueta.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+
+	lineas := strings.Split(s, "\n")
+	for i, l := range lineas {
+		lineas[i] = strings.TrimSpace(reEspacios.ReplaceAllString(l, " "))
+	}
+	s = strings.Join(lineas, "\n")
+	return strings.TrimSpace(reLineasVacias.ReplaceAllString(s, "\n\n"))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+func firstNonEmpty(valores ...string) string {
+	for _, v := range valores {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// mimePart es una parte hoja del correo ya decodificada
+type mimePart struct {
+	contentType string
+	filename    string
+	body        string
+}
+
+// parseMIMEParts recorre el correo y devuelve sus partes hoja decodificadas
+func parseMIMEParts(t *testing.T, contentType string, cte string, body io.Reader) []mimePart {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("Content-Type inválido %q: %v", contentType, err)
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		var r io.Reader = body
+		switch strings.ToLower(cte) {
+		case "quoted-printable":
+			r = quotedprintable.NewReader(body)
+		case "base64":
+			r = base64.NewDecoder(base64.StdEncoding, body)
+		}
+		datos, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("error al leer la parte %s: %v", mediaType, err)
+		}
+		return []mimePart{{contentType: mediaType, body: string(datos)}}
+	}
+
+	var partes []mimePart
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("error al leer %s: %v", mediaType, err)
+		}
+		hijas := parseMIMEParts(t, p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"), p)
+		if _, dp, err := mime.ParseMediaType(p.Header.Get("Content-Disposition")); err == nil && len(hijas) == 1 {
+			hijas[0].filename = dp["filename"]
+		}
+		partes = append(partes, hijas...)
+	}
+	return partes
+}
+
+func TestBuildMIMEMessage(t *testing.T) {
+	t.Setenv("EMAIL_FROM", "noreply@example.com")
+	t.Setenv("EMAIL_FROM_NAME", "")
+
+	tests := []struct {
+		name     string
+		msg      EmailMessage
+		wantType string
+		want     []mimePart
+	}{
+		{
+			name:     "solo texto",
+			msg:      EmailMessage{To: []string{"ana@example.com"}, Subject: "Hola", Text: "Línea con acentos: áéí"},
+			wantType: "text/plain",
+			want:     []mimePart{{contentType: "text/plain", body: "Línea con acentos: áéí"}},
+		},
+		{
+			name:     "html genera alternativa de texto",
+			msg:      EmailMessage{To: []string{"ana@example.com"}, Subject: "Restablecer", HTML: "<p>Pulsa <b>aquí</b></p>"},
+			wantType: "multipart/alternative",
+			want: []mimePart{
+				{contentType: "text/plain", body: htmlToText("<p>Pulsa <b>aquí</b></p>")},
+				{contentType: "text/html", body: "<p>Pulsa <b>aquí</b></p>"},
+			},
+		},
+		{
+			name: "con adjunto",
+			msg: EmailMessage{
+				To: []string{"ana@example.com"}, Subject: "Factura", Text: "Adjunta", HTML: "<p>Adjunta</p>",
+				Attachments: []EmailAttachment{{Nombre: "factura.txt", ContentType: "text/plain", Datos: []byte("contenido del adjunto")}},
+			},
+			wantType: "multipart/mixed",
+			want: []mimePart{
+				{contentType: "text/plain", body: "Adjunta"},
+				{contentType: "text/html", body: "<p>Adjunta</p>"},
+				{contentType: "text/plain", filename: "factura.txt", body: "contenido del adjunto"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := buildMIMEMessage(tt.msg)
+			if err != nil {
+				t.Fatalf("buildMIMEMessage: %v", err)
+			}
+			m, err := mail.ReadMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("el correo no es RFC 5322 válido: %v\n%s", err, raw)
+			}
+
+			dec := new(mime.WordDecoder)
+			if asunto, _ := dec.DecodeHeader(m.Header.Get("Subject")); asunto != tt.msg.Subject {
+				t.Errorf("Subject = %q, quiero %q", asunto, tt.msg.Subject)
+			}
+			if m.Header.Get("Message-ID") == "" {
+				t.Error("falta la cabecera Message-ID")
+			}
+			mediaType, _, _ := mime.ParseMediaType(m.Header.Get("Content-Type"))
+			if mediaType != tt.wantType {
+				t.Fatalf("Content-Type = %q, quiero %q\n%s", mediaType, tt.wantType, raw)
+			}
+
+			partes := parseMIMEParts(t, m.Header.Get("Content-Type"), m.Header.Get("Content-Transfer-Encoding"), m.Body)
+			if len(partes) != len(tt.want) {
+				t.Fatalf("%d partes, quiero %d: %+v", len(partes), len(tt.want), partes)
+			}
+			for i, want := range tt.want {
+				got := partes[i]
+				got.body = strings.TrimRight(got.body, "\r\n")
+				if got != want {
+					t.Errorf("parte %d = %+v, quiero %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildMIMEMessageRejectsHeaderInjection(t *testing.T) {
+	tests := []EmailMessage{
+		{To: []string{"ana@example.com"}, Subject: "Hola\r\nBcc: otro@example.com", Text: "x"},
+		{To: []string{"ana@example.com\nBcc: otro@example.com"}, Subject: "Hola", Text: "x"},
+		{To: []string{"ana@example.com"}, Subject: "Hola", Text: "x", Headers: map[string]string{"X-Test": "a\r\nb"}},
+		{Subject: "Sin destinatarios", Text: "x"},
+	}
+	for i, msg := range tests {
+		if _, err := buildMIMEMessage(msg); err == nil {
+			t.Errorf("caso %d: se esperaba un error", i)
+		}
+	}
+}
+
+func TestMemoryMailerCapturesMIME(t *testing.T) {
+	m := &MemoryMailer{}
+	if err := m.Send(EmailMessage{To: []string{"ana@example.com"}, Subject: "Hola", HTML: "<p>Hola</p>"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	raw := m.SentRaw()
+	if len(raw) != 1 || len(m.Sent()) != 1 {
+		t.Fatalf("capturados %d mensajes y %d MIME, quiero 1", len(m.Sent()), len(raw))
+	}
+	if !bytes.Contains(raw[0], []byte("Content-Type: multipart/alternative")) {
+		t.Errorf("el MIME capturado no tiene cuerpo:\n%s", raw[0])
+	}
+	m.Reset()
+	if len(m.SentRaw()) != 0 {
+		t.Error("Reset no descarta los correos capturados")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -29,6 +30,30 @@ type Usuario struct {
 	LastLogin time.Time `json:"last_login"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	// Protección contra fuerza bruta
+	FailedLoginAttempts int        `gorm:"default:0" json:"-"`
+	LastFailedLogin     *time.Time `json:"-"`
+	LockedUntil         *time.Time `json:"locked_until,omitempty"`
+
+	// Verificación de email
+	EmailVerificado   bool       `gorm:"default:false" json:"email_verificado"`
+	EmailVerificadoEn *time.Time `json:"email_verificado_en,omitempty"`
+
+	// Autenticación en dos pasos (TOTP)
+	TwoFactorEnabled bool   `gorm:"default:false" json:"two_factor_enabled"`
+	TOTPSecret       string `gorm:"column:totp_secret;size:64" json:"-"`
+	TOTPLastStep     int64  `gorm:"column:totp_last_step;default:0" json:"-"` // Evita reutilizar un código ya aceptado
+
+	// Cuenta de servicio: sin contraseña, solo se autentica con claves API
+	EsServicio bool `gorm:"default:false" json:"es_servicio"`
+
+	// Eliminación solicitada por el usuario, pendiente del periodo de gracia
+	EliminacionSolicitadaEn *time.Time `json:"eliminacion_solicitada_en,omitempty"`
+	EliminacionProgramada   *time.Time `gorm:"index" json:"eliminacion_programada,omitempty"`
+
+	// Idioma de los emails; vacío usa EMAIL_DEFAULT_LOCALE
+	Idioma string `gorm:"size:10" json:"idioma"`
 }
 
 type Curso struct {
@@ -42,6 +67,8 @@ type Curso struct {
 	CreatedAt   time.Time  `json:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at"`
 	Capitulos   []Capitulo `gorm:"foreignKey:CursoID" json:"capitulos,omitempty"`
+
+	Valoracion *ResumenValoraciones `gorm:"-" json:"valoracion,omitempty"` // Media y reparto de estrellas, calculado al consultar
 }
 
 type Capitulo struct {
@@ -61,7 +88,7 @@ type Capitulo struct {
 
 type Pago struct {
 	ID            uint      `gorm:"primaryKey" json:"id"`
-	UsuarioID     uint      `gorm:"not null" json:"usuario_id"`
+	UsuarioID     *uint     `json:"usuario_id"` // NULL si el usuario eliminó su cuenta (el pago se conserva)
 	CursoID       uint      `gorm:"not null" json:"curso_id"`
 	Monto         float64   `gorm:"type:decimal(10,2);not null" json:"monto"`
 	Metodo        string    `gorm:"size:50;not null" json:"metodo"`
@@ -80,6 +107,8 @@ type ActivityLog struct {
 	IP        string    `gorm:"size:50" json:"ip"`
 	UserAgent string    `gorm:"size:255" json:"user_agent"`
 	CreatedAt time.Time `json:"created_at"`
+
+	ImpersonatorID *uint `gorm:"index" json:"impersonator_id,omitempty"` // Admin que actuaba en nombre del usuario
 }
 
 var db *gorm.DB
@@ -88,12 +117,26 @@ func main() {
 	setupLogging()
 	loadEnv()
 
+	if err := initJWTKeys(); err != nil {
+		log.Fatalf("Error al cargar las claves JWT: %v", err)
+	}
+	if err := checkLinkSigningSecret(); err != nil {
+		log.Fatalf("Error en la configuración de los enlaces firmados: %v", err)
+	}
+
 	if err := setupDatabase(); err != nil {
 		log.Fatalf("Error al configurar la base de datos: %v", err)
 	}
 	
 	initStaticDirs()
 	initPaymentProviders()
+	initRateLimiter()
+	initOAuthProviders()
+
+	go startAccountDeletionWorker()
+	go startNotificationDigestWorker()
+	go startEmailOutboxWorker()
+	go startConsumedTokenCleanup()
 
 	router := setupRouter()
 	registerRoutes(router)
@@ -143,6 +186,18 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// trustedProxies devuelve los proxies de TRUSTED_PROXIES (IPs o rangos CIDR separados
+// por comas). Sin esa variable devuelve nil y no se confía en ninguno.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(getEnv("TRUSTED_PROXIES", ""), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
 func createDirIfNotExists(dirPath string) {
 	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
 		log.Printf("Creando directorio: %s", dirPath)
@@ -158,6 +213,7 @@ func initStaticDirs() {
 	initProfilesDir()
 	initPortfolioDir()
 	initHomeImagesDirs()
+	initMessageAttachmentsDir()
 	createDirIfNotExists("./static/images")
 }
 
@@ -304,10 +360,28 @@ func migrateWithConstraints() error {
 		log.Printf("Advertencia: No se pudo eliminar constraint fk_progreso_capitulo_capitulo: %v", err)
 	}
 
-	if err := db.AutoMigrate(&Usuario{}, &Curso{}, &Capitulo{}, &Pago{}, &ProgresoUsuario{}, &ProgresoCapitulo{}, &ActivityLog{}, &ContactMessage{}, &ProjectPortfolio{}, &HomeImage{}); err != nil {
+	// Si la columna aún no existe, las cuentas actuales se darán por verificadas tras migrar
+	verificacionNueva := db.Migrator().HasTable(&Usuario{}) && !db.Migrator().HasColumn(&Usuario{}, "EmailVerificado")
+
+	if err := db.AutoMigrate(&Usuario{}, &Curso{}, &Capitulo{}, &Pago{}, &ProgresoUsuario{}, &ProgresoCapitulo{}, &ActivityLog{}, &ContactMessage{}, &ProjectPortfolio{}, &HomeImage{}, &Rol{}, &RolPermiso{}, &Sesion{}, &CodigoRecuperacion{}, &PasswordReset{}, &Invitacion{}, &RateLimitBucket{}, &UsuarioIdentidad{}, &OAuthEstado{}, &EnlaceMagico{}, &APIKey{}, &PreferenciasNotificacion{}, &Notificacion{}, &EmailOutbox{}, &EmailTemplate{}, &EmailTemplateVersion{}, &MensajeRespuesta{}, &MensajeAdjunto{}, &FiltroSpamToken{}, &RespuestaPredefinida{}, &Valoracion{}, &ValoracionHistorial{}, &TokenConsumido{}); err != nil {
 		return fmt.Errorf("error al migrar tablas base: %v", err)
 	}
 
+	if verificacionNueva {
+		if err := markExistingUsersVerified(); err != nil {
+			return fmt.Errorf("error al marcar usuarios existentes como verificados: %v", err)
+		}
+	}
+
+	// Los mensajes anteriores a las conversaciones toman su fecha como última actividad
+	if err := db.Exec("UPDATE contact_messages SET ultima_actividad = created_at WHERE ultima_actividad IS NULL").Error; err != nil {
+		log.Printf("Advertencia: No se pudo inicializar la última actividad de los mensajes: %v", err)
+	}
+
+	if err := seedRoles(); err != nil {
+		return fmt.Errorf("error al crear roles predefinidos: %v", err)
+	}
+
 	if !db.Migrator().HasTable(&Pago{}) {
 		if err := db.Migrator().CreateTable(&Pago{}); err != nil {
 			return fmt.Errorf("error al crear tabla Pago: %v", err)
@@ -316,6 +390,9 @@ func migrateWithConstraints() error {
 		if err := db.Exec("ALTER TABLE pagos MODIFY COLUMN estado varchar(20) NOT NULL DEFAULT 'pendiente'").Error; err != nil {
 			log.Printf("Advertencia: No se pudo modificar columna estado: %v", err)
 		}
+		if err := db.Exec("ALTER TABLE pagos MODIFY COLUMN usuario_id bigint unsigned NULL").Error; err != nil {
+			log.Printf("Advertencia: No se pudo modificar columna usuario_id: %v", err)
+		}
 	}
 
 	if !db.Migrator().HasTable(&ProgresoUsuario{}) {
@@ -331,7 +408,7 @@ func migrateWithConstraints() error {
 	}
 
 	if err := db.Exec(
-		"ALTER TABLE pagos ADD CONSTRAINT fk_pagos_usuario FOREIGN KEY (usuario_id) REFERENCES usuarios(id) ON DELETE CASCADE",
+		"ALTER TABLE pagos ADD CONSTRAINT fk_pagos_usuario FOREIGN KEY (usuario_id) REFERENCES usuarios(id) ON DELETE SET NULL",
 	).Error; err != nil {
 		log.Printf("Advertencia: No se pudo crear constraint fk_pagos_usuario: %v", err)
 	}
@@ -378,6 +455,24 @@ func migrateWithConstraints() error {
 		log.Printf("Advertencia: No se pudo crear constraint fk_progreso_capitulo_capitulo: %v", err)
 	}
 
+	if err := db.Exec(
+		"ALTER TABLE valoraciones ADD CONSTRAINT fk_valoraciones_usuario FOREIGN KEY (usuario_id) REFERENCES usuarios(id) ON DELETE CASCADE",
+	).Error; err != nil {
+		log.Printf("Advertencia: No se pudo crear constraint fk_valoraciones_usuario: %v", err)
+	}
+
+	if err := db.Exec(
+		"ALTER TABLE valoraciones ADD CONSTRAINT fk_valoraciones_curso FOREIGN KEY (curso_id) REFERENCES cursos(id) ON DELETE CASCADE",
+	).Error; err != nil {
+		log.Printf("Advertencia: No se pudo crear constraint fk_valoraciones_curso: %v", err)
+	}
+
+	if err := db.Exec(
+		"ALTER TABLE valoraciones_historial ADD CONSTRAINT fk_valoraciones_historial FOREIGN KEY (valoracion_id) REFERENCES valoraciones(id) ON DELETE CASCADE",
+	).Error; err != nil {
+		log.Printf("Advertencia: No se pudo crear constraint fk_valoraciones_historial: %v", err)
+	}
+
 	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_usuario_curso ON progreso_usuarios(usuario_id, curso_id)").Error; err != nil {
 		log.Printf("Advertencia: No se pudo crear índice idx_usuario_curso: %v", err)
 	}
@@ -396,6 +491,12 @@ func migrateWithConstraints() error {
 func setupRouter() *gin.Engine {
 	router := gin.Default()
 
+	// Solo se confía en X-Forwarded-For y demás cabeceras de proxy si la conexión
+	// llega desde uno de los proxies configurados
+	if err := router.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatalf("TRUSTED_PROXIES no válido: %v", err)
+	}
+
 	frontendURL := getEnv("FRONTEND_URL", "http://localhost:3000")
 	log.Printf("Configurando CORS para permitir origen: %s", frontendURL)
 
@@ -419,73 +520,153 @@ func registerRoutes(router *gin.Engine) {
 	auth := router.Group("/api/auth")
 	{
 		auth.POST("/register", register)
-		auth.POST("/login", login)
-		auth.POST("/forgot-password", forgotPassword)
+		auth.POST("/login", rateLimitByIP(ruleLoginIP), login)
+		auth.POST("/login/2fa", rateLimitByIP(ruleLoginIP), verify2FALogin)
+		auth.POST("/magic-link", rateLimitByIP(ruleLoginIP), requestMagicLink)
+		auth.POST("/magic-link/consume", rateLimitByIP(ruleLoginIP), consumeMagicLink)
+		auth.POST("/forgot-password", rateLimitByIP(ruleForgotIP), forgotPassword)
 		auth.GET("/reset-password/:token/validate", validateResetToken)
 		auth.POST("/reset-password", resetPassword)
-		auth.GET("/check-admin", authMiddleware(), checkAdmin)
+		auth.GET("/password-policy", getPasswordPolicy)
+		auth.POST("/verify-email", verifyEmail)
+		auth.GET("/invitations/:token/validate", validateInvitation)
+		auth.POST("/invitations/accept", acceptInvitation)
+		auth.GET("/oauth/providers", listOAuthProviders)
+		auth.GET("/oauth/:provider/start", rateLimitByIP(ruleLoginIP), startOAuthLogin)
+		auth.GET("/oauth/:provider/callback", oauthCallback)
+		auth.POST("/oauth/exchange", rateLimitByIP(ruleLoginIP), exchangeOAuthCode)
+		auth.POST("/refresh-token", refreshToken)
+		auth.POST("/logout", logout)
+		auth.GET("/check-admin", allowAPIKey(), authMiddleware(), checkAdmin)
 		
 		profile := auth.Group("")
-		profile.Use(authMiddleware())
+		profile.Use(authMiddleware(), denyAPIKey())
 		{
 			profile.GET("/profile", getProfile)
-			profile.PUT("/profile", updateProfile)
-			profile.POST("/change-password", changePassword)
+			profile.PUT("/profile", denyImpersonation(), updateProfile)
+			profile.POST("/change-password", denyImpersonation(), changePassword)
+			profile.POST("/verify-email/resend", resendVerificationEmail)
 			profile.POST("/profile/image", uploadProfileImage)
 			profile.GET("/notification-settings", getNotificationSettings)
 			profile.PUT("/notification-settings", updateNotificationSettings)
-			profile.POST("/refresh-token", refreshToken)
+			profile.GET("/sessions", getMySessions)
+			profile.DELETE("/sessions", denyImpersonation(), revokeOtherSessions)
+			profile.DELETE("/sessions/:id", denyImpersonation(), revokeMySession)
+			profile.GET("/identities", listMyIdentities)
+			profile.DELETE("/identities/:id", denyImpersonation(), unlinkIdentity)
+			profile.POST("/oauth/:provider/link", denyImpersonation(), startOAuthLink)
+			profile.GET("/2fa/status", get2FAStatus)
+			profile.POST("/2fa/setup", denyImpersonation(), setup2FA)
+			profile.POST("/2fa/enable", denyImpersonation(), enable2FA)
+			profile.POST("/2fa/disable", denyImpersonation(), disable2FA)
+			profile.POST("/2fa/recovery-codes", denyImpersonation(), regenerateRecoveryCodes)
+			profile.GET("/api-keys", listMyAPIKeys)
+			profile.POST("/api-keys", denyImpersonation(), createMyAPIKey)
+			profile.DELETE("/api-keys/:id", denyImpersonation(), revokeMyAPIKey)
+			profile.GET("/me/export", denyImpersonation(), exportMyData)
+			profile.DELETE("/me", denyImpersonation(), deleteMyAccount)
 		}
 	}
 
 	admin := router.Group("/api/admin")
-	admin.Use(authMiddleware(), adminMiddleware())
+	admin.Use(allowAPIKey(), authMiddleware(), requirePermission(PermAdminPanel))
 	{
-		admin.GET("/stats", getAdminStats)
-		admin.GET("/dashboard", getAdminDashboard)
-		admin.GET("/activity-log", getActivityLog)
-		admin.GET("/sales-stats", getSalesStats)
-		admin.GET("/users", listUsers)
-		admin.GET("/users/:id", getUserById)
-		admin.PUT("/users/:id", updateUser)
-		admin.DELETE("/users/:id", deleteUser)
-		admin.PUT("/users/:id/role", changeUserRole)
+		admin.GET("/stats", requirePermission(PermStatsRead), getAdminStats)
+		admin.GET("/dashboard", requirePermission(PermStatsRead), getAdminDashboard)
+		admin.GET("/activity-log", requirePermission(PermActivityRead), getActivityLog)
+		admin.GET("/sales-stats", requirePermission(PermStatsRead), getSalesStats)
+		admin.GET("/users", requirePermission(PermUsersRead), listUsers)
+		admin.GET("/users/:id", requirePermission(PermUsersRead), getUserById)
+		admin.PUT("/users/:id", requirePermission(PermUsersManage), updateUser)
+		admin.DELETE("/users/:id", requirePermission(PermUsersManage), deleteUser)
+		admin.PUT("/users/:id/role", requirePermission(PermUsersManage), changeUserRole)
+		admin.POST("/users/:id/unlock", requirePermission(PermUsersManage), unlockUser)
+		admin.POST("/users/:id/impersonate", denyImpersonation(), denyAPIKey(), requirePermission(PermUsersImpersonate), impersonateUser)
+		admin.GET("/users/:id/sessions", requirePermission(PermUsersRead), getUserSessions)
+		admin.DELETE("/users/:id/sessions", requirePermission(PermUsersManage), revokeUserSessions)
+		admin.DELETE("/users/:id/sessions/:sessionId", requirePermission(PermUsersManage), revokeUserSessionByID)
 		
-		admin.GET("/messages", getContactMessages)
-		admin.GET("/messages/:id", getContactMessage)
-		admin.PATCH("/messages/:id/:action", updateMessageStatus)
-		admin.DELETE("/messages/:id", deleteContactMessage)
-		admin.POST("/messages/:id/reply", replyToMessage)
+		admin.GET("/messages", requirePermission(PermMessagesRead), getContactMessages)
+		admin.GET("/messages/counts", requirePermission(PermMessagesRead), getContactMessageCounts)
+		admin.POST("/messages/bulk", requirePermission(PermMessagesManage), bulkUpdateMessages)
+		admin.GET("/messages/:id", requirePermission(PermMessagesRead), getContactMessage)
+		admin.PATCH("/messages/:id/:action", requirePermission(PermMessagesManage), updateMessageStatus)
+		admin.DELETE("/messages/:id", requirePermission(PermMessagesManage), deleteContactMessage)
+		admin.POST("/messages/:id/reply", requirePermission(PermMessagesReply), replyToMessage)
+		admin.PUT("/messages/:id/status", requirePermission(PermMessagesReply), updateMessageThreadStatus)
+		admin.PUT("/messages/:id/assign", requirePermission(PermMessagesManage), assignMessageThread)
+		admin.GET("/messages/:id/attachments/:attachmentId", requirePermission(PermMessagesRead), downloadMessageAttachment)
+		admin.GET("/canned-responses", requirePermission(PermMessagesReply), listCannedResponses)
+		admin.GET("/canned-responses/:id", requirePermission(PermMessagesReply), getCannedResponse)
+		admin.POST("/canned-responses", requirePermission(PermMessagesManage), createCannedResponse)
+		admin.PUT("/canned-responses/:id", requirePermission(PermMessagesManage), updateCannedResponse)
+		admin.DELETE("/canned-responses/:id", requirePermission(PermMessagesManage), deleteCannedResponse)
+		admin.POST("/canned-responses/:id/preview", requirePermission(PermMessagesReply), previewCannedResponse)
+
+		admin.GET("/reviews", requirePermission(PermReviewsModerate), listReviewsAdmin)
+		admin.GET("/reviews/:id", requirePermission(PermReviewsModerate), getReviewAdmin)
+		admin.PUT("/reviews/:id/moderate", requirePermission(PermReviewsModerate), moderateReview)
+
+		admin.GET("/permissions", requirePermission(PermRolesManage), listPermissions)
+		admin.GET("/roles", requirePermission(PermRolesManage), listRoles)
+		admin.POST("/roles", requirePermission(PermRolesManage), createRole)
+		admin.PUT("/roles/:id", requirePermission(PermRolesManage), updateRole)
+		admin.DELETE("/roles/:id", requirePermission(PermRolesManage), deleteRole)
+		admin.GET("/invitations", requirePermission(PermUsersRead), listInvitations)
+		admin.POST("/invitations", requirePermission(PermUsersManage), createInvitation)
+		admin.POST("/invitations/:id/resend", requirePermission(PermUsersManage), resendInvitation)
+		admin.DELETE("/invitations/:id", requirePermission(PermUsersManage), revokeInvitation)
+		admin.GET("/service-accounts", requirePermission(PermUsersRead), listServiceAccounts)
+		admin.POST("/service-accounts", requirePermission(PermUsersManage), createServiceAccount)
+		admin.GET("/service-accounts/:id/api-keys", requirePermission(PermUsersRead), listServiceAccountKeys)
+		admin.POST("/service-accounts/:id/api-keys", requirePermission(PermUsersManage), createServiceAccountKey)
+		admin.DELETE("/api-keys/:id", requirePermission(PermUsersManage), revokeAPIKey)
+
+		admin.GET("/emails", requirePermission(PermEmailsManage), getEmailOutbox)
+		admin.GET("/emails/:id", requirePermission(PermEmailsManage), getEmailOutboxItem)
+		admin.POST("/emails/:id/resend", requirePermission(PermEmailsManage), resendEmailOutboxItem)
+		admin.GET("/email-templates", requirePermission(PermEmailsManage), listEmailTemplates)
+		admin.POST("/email-templates", requirePermission(PermEmailsManage), createEmailTemplate)
+		admin.POST("/email-templates/preview", requirePermission(PermEmailsManage), previewEmailTemplate)
+		admin.GET("/email-templates/:id", requirePermission(PermEmailsManage), getEmailTemplate)
+		admin.PUT("/email-templates/:id", requirePermission(PermEmailsManage), updateEmailTemplate)
+		admin.DELETE("/email-templates/:id", requirePermission(PermEmailsManage), deleteEmailTemplate)
+		admin.GET("/email-templates/:id/versions", requirePermission(PermEmailsManage), listEmailTemplateVersions)
+		admin.POST("/email-templates/:id/versions/:version/restore", requirePermission(PermEmailsManage), restoreEmailTemplateVersion)
 	}
 
 	cursos := router.Group("/api/cursos")
 	{
 		cursos.GET("", getCursos)
 		cursos.GET("/:id", getCursoById)
-		cursos.POST("", authMiddleware(), createCurso)
-		cursos.PUT("/:id", authMiddleware(), updateCurso)
-		cursos.DELETE("/:id", authMiddleware(), deleteCurso)
+		cursos.POST("", allowAPIKey(), authMiddleware(), requirePermission(PermCoursesWrite), createCurso)
+		cursos.PUT("/:id", allowAPIKey(), authMiddleware(), requirePermission(PermCoursesWrite), updateCurso)
+		cursos.DELETE("/:id", allowAPIKey(), authMiddleware(), requirePermission(PermCoursesWrite), deleteCurso)
+		cursos.POST("/:id/anuncios", allowAPIKey(), authMiddleware(), requirePermission(PermCoursesWrite), announceCourse)
+		cursos.GET("/:id/valoraciones", getCourseReviews)
+		cursos.GET("/:id/valoraciones/mia", authMiddleware(), getMyCourseReview)
+		cursos.POST("/:id/valoraciones", authMiddleware(), requireVerifiedEmail(PoliticaVerificacionAcceso), createCourseReview)
+		cursos.PUT("/:id/valoraciones", authMiddleware(), requireVerifiedEmail(PoliticaVerificacionAcceso), updateCourseReview)
 	}
 
 	capitulos := router.Group("/api/capitulos")
 	{
-		capitulos.Use(authMiddleware())
-		capitulos.GET("/curso/:cursoId", getCapitulosByCurso)
-		capitulos.POST("", createCapitulo)
-		capitulos.PUT("/:id", updateCapitulo)
-		capitulos.DELETE("/:id", deleteCapitulo)
+		capitulos.GET("/curso/:cursoId", authMiddleware(), requireVerifiedEmail(PoliticaVerificacionAcceso), getCapitulosByCurso)
+		capitulos.POST("", allowAPIKey(), authMiddleware(), requirePermission(PermCoursesWrite), createCapitulo)
+		capitulos.PUT("/:id", allowAPIKey(), authMiddleware(), requirePermission(PermCoursesWrite), updateCapitulo)
+		capitulos.DELETE("/:id", allowAPIKey(), authMiddleware(), requirePermission(PermCoursesWrite), deleteCapitulo)
 	}
 
 	videos := router.Group("/api/videos")
 	{
-		videos.Use(authMiddleware())
+		videos.Use(allowAPIKey(), authMiddleware(), requirePermission(PermCoursesWrite))
 		videos.POST("/upload", uploadVideo)
 		videos.DELETE("/:cursoId/:filename", deleteVideo)
 	}
 
 	progreso := router.Group("/api/progreso")
 	{
-		progreso.Use(authMiddleware())
+		progreso.Use(authMiddleware(), requireVerifiedEmail(PoliticaVerificacionAcceso))
 		progreso.GET("/curso/:cursoId", getProgresoUsuario)
 		progreso.POST("/capitulo/completado", marcarCapituloCompletado)
 		progreso.POST("/ultimo-capitulo", guardarUltimoCapitulo)
@@ -496,11 +677,11 @@ func registerRoutes(router *gin.Engine) {
 		portfolio.GET("", getAllProjects)
 		portfolio.GET("/:id", getProjectById)
 		portfolio.GET("/category/:category", getProjectsByCategory)
-		portfolio.POST("", authMiddleware(), adminMiddleware(), createProject)
-		portfolio.PUT("/:id", authMiddleware(), adminMiddleware(), updateProject)
-		portfolio.DELETE("/:id", authMiddleware(), adminMiddleware(), deleteProject)
-		portfolio.POST("/reorder", authMiddleware(), adminMiddleware(), reorderProjects)
-		portfolio.GET("/stats", authMiddleware(), adminMiddleware(), getPortfolioStats)
+		portfolio.POST("", allowAPIKey(), authMiddleware(), requirePermission(PermPortfolioWrite), createProject)
+		portfolio.PUT("/:id", allowAPIKey(), authMiddleware(), requirePermission(PermPortfolioWrite), updateProject)
+		portfolio.DELETE("/:id", allowAPIKey(), authMiddleware(), requirePermission(PermPortfolioWrite), deleteProject)
+		portfolio.POST("/reorder", allowAPIKey(), authMiddleware(), requirePermission(PermPortfolioWrite), reorderProjects)
+		portfolio.GET("/stats", allowAPIKey(), authMiddleware(), requirePermission(PermStatsRead), getPortfolioStats)
 	}
 
 	registerHomeImageRoutes(router)
@@ -538,7 +719,7 @@ func registerRoutes(router *gin.Engine) {
 	pagos := router.Group("/api/pagos")
 	{
 		pagos.Use(authMiddleware())
-		pagos.POST("", crearPago)
+		pagos.POST("", denyImpersonation(), requireVerifiedEmail(PoliticaVerificacionCompra), crearPago)
 		pagos.GET("/:id", verificarPagoPorCurso)
 		pagos.POST("/webhook", webhookPago)
 		pagos.POST("/paypal/webhook", webhookPayPal)
@@ -548,8 +729,22 @@ func registerRoutes(router *gin.Engine) {
 	// Esta ruta debe estar fuera del grupo que usa authMiddleware
 	router.GET("/api/pagos/paypal/callback", callbackPayPal)
 
-	router.POST("/api/contact", contactHandler)
+	router.GET("/api/contact/token", getContactFormToken)
+	router.POST("/api/contact", rateLimitByIP(ruleContactIP), contactHandler)
+	router.POST("/api/inbound/email", inboundEmailHandler)
+	router.POST("/api/notifications/unsubscribe", unsubscribeNotifications)
+
+	notificaciones := router.Group("/api/notificaciones")
+	{
+		notificaciones.GET("/stream", sseTicketAuth(), streamNotificaciones)
+		notificaciones.Use(authMiddleware())
+		notificaciones.POST("/stream-ticket", createStreamTicket)
+		notificaciones.GET("", getNotificaciones)
+		notificaciones.PATCH("/:id/read", markNotificacionLeida)
+		notificaciones.POST("/read-all", markAllNotificacionesLeidas)
+	}
 	router.GET("/api/health", healthCheck)
+	router.GET("/.well-known/jwks.json", getJWKS)
 }
 
 // Middlewares y funciones de ayuda
\ No newline at end of file
@@ -1,23 +1,55 @@
 package main
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"net/smtp"
-	"os"
-	
-	"text/template"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 func getContactMessages(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	query, err := contactInboxQuery(c, user)
+	if err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+	query, cursorDe, err := paginateInbox(c, query)
+	if err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	// Se pide uno más para saber si hay página siguiente
 	var messages []ContactMessage
+	if err := query.Preload("Asignado", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "nombre", "email", "image_url")
+	}).Limit(limit + 1).Find(&messages).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
 
-	if err := db.Order("created_at DESC").Find(&messages).Error; err != nil {
+	siguiente := ""
+	if len(messages) > limit {
+		messages = messages[:limit]
+		siguiente = cursorDe(messages[limit-1])
+	}
+
+	counts, err := contactInboxCounts(user.ID)
+	if err != nil {
 		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
 		return
 	}
@@ -26,6 +58,12 @@ func getContactMessages(c *gin.Context) {
 	response := gin.H{
 		"success": true,
 		"data":    messages,
+		"counts":  counts,
+		"pagination": gin.H{
+			"limit":       limit,
+			"next_cursor": siguiente,
+			"has_more":    siguiente != "",
+		},
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -34,8 +72,8 @@ func getContactMessages(c *gin.Context) {
 func getContactMessage(c *gin.Context) {
 	id := c.Param("id")
 
-	var message ContactMessage
-	if err := db.First(&message, id).Error; err != nil {
+	message, err := loadMessageThread(id)
+	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
 		} else {
@@ -70,6 +108,21 @@ func updateMessageStatus(c *gin.Context) {
 		db.Model(&message).Update("read", !message.Read)
 	case "star":
 		db.Model(&message).Update("starred", !message.Starred)
+	case "archive":
+		if message.ArchivadoEn == nil {
+			now := time.Now()
+			message.ArchivadoEn = &now
+		} else {
+			message.ArchivadoEn = nil
+		}
+		db.Model(&message).Update("archivado_en", message.ArchivadoEn)
+	case "spam":
+		// Marcar o desmarcar como spam también entrena el filtro bayesiano
+		if err := markMessageSpam(&message, !message.Spam); err != nil {
+			log.Printf("Error al marcar el mensaje %d como spam: %v", message.ID, err)
+			SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+			return
+		}
 	default:
 		SendErrorResponse(c, ErrInvalidRequest, http.StatusBadRequest)
 		return
@@ -91,15 +144,26 @@ func deleteContactMessage(c *gin.Context) {
 
 func replyToMessage(c *gin.Context) {
 	id := c.Param("id")
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
 
+	// La respuesta es el texto de message o una respuesta predefinida (respuesta_id)
+	// rellenada con los datos del remitente; variables sustituye los valores que se deseen
 	var req struct {
-		Message string `json:"message" binding:"required"`
+		Message     string            `json:"message"`
+		RespuestaID *uint             `json:"respuesta_id"`
+		Variables   map[string]string `json:"variables"`
+		Cerrar      bool              `json:"cerrar"` // Cierra la conversación tras responder
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		SendErrorResponse(c, err, http.StatusBadRequest)
 		return
 	}
+	if (strings.TrimSpace(req.Message) == "") == (req.RespuestaID == nil) {
+		SendErrorResponse(c, errors.New("indica el texto de la respuesta o una respuesta predefinida, pero no ambos"), http.StatusBadRequest)
+		return
+	}
 
 	var contactMsg ContactMessage
 	if err := db.First(&contactMsg, id).Error; err != nil {
@@ -111,178 +175,176 @@ func replyToMessage(c *gin.Context) {
 		return
 	}
 
+	var predefinida *RespuestaPredefinida
+	if req.RespuestaID != nil {
+		var err error
+		predefinida, req.Message, err = renderCannedResponse(*req.RespuestaID, contactMsg, user, req.Variables)
+		if err != nil {
+			sendCannedResponseError(c, err)
+			return
+		}
+	}
+
+	// Si el remitente tiene cuenta, la respuesta también aparece en su centro de notificaciones
+	entregadaEnApp := false
+	var destinatario Usuario
+	if err := db.Where("email = ?", contactMsg.Email).First(&destinatario).Error; err == nil {
+		entregadaEnApp = publishNotification(destinatario.ID, NotifTipoRespuestaMensaje,
+			"Respuesta a tu mensaje", req.Message, "")
+	}
+
 	// Enviar email de respuesta al usuario
-	if err := sendReplyEmail(contactMsg, req.Message); err != nil {
+	respuesta := MensajeRespuesta{
+		MensajeID: contactMsg.ID,
+		Direccion: RespuestaSaliente,
+		AutorID:   &user.ID,
+		Cuerpo:    req.Message,
+		EnviadoEn: time.Now(),
+		MessageID: newThreadMessageID(contactMsg.ID),
+	}
+	mensaje := "Respuesta enviada correctamente"
+	correo, err := sendReplyEmail(contactMsg, req.Message, respuesta.MessageID)
+	switch {
+	case err == nil:
+		respuesta.EstadoEntrega = correo.Estado
+		respuesta.EmailOutboxID = &correo.ID
+	case errors.Is(err, ErrEmailOptOut) && entregadaEnApp:
+		respuesta.EstadoEntrega = EntregaSoloApp
+		mensaje = "El usuario no recibe emails: la respuesta se ha entregado en la aplicación"
+	case errors.Is(err, ErrEmailOptOut):
+		SendErrorResponse(c, err, http.StatusConflict)
+		return
+	default:
 		log.Printf("Error al enviar email de respuesta: %v", err)
 		SendErrorResponse(c, ErrEmailSendError, http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("Respuesta enviada a %s (%s): %s", contactMsg.Name, contactMsg.Email, req.Message)
+	// Tras responder, la conversación queda a la espera del remitente y, si nadie la
+	// tenía asignada, pasa a quien ha respondido
+	estado := HiloPendiente
+	if req.Cerrar {
+		estado = HiloCerrado
+	}
+	cambios := map[string]interface{}{
+		"estado":           estado,
+		"read":             true,
+		"ultima_actividad": respuesta.EnviadoEn,
+	}
+	if contactMsg.AsignadoID == nil {
+		cambios["asignado_id"] = user.ID
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&respuesta).Error; err != nil {
+			return err
+		}
+		return tx.Model(&contactMsg).Updates(cambios).Error
+	})
+	if err != nil {
+		// El correo ya está en cola: se avisa del fallo, pero no se reintenta el envío
+		log.Printf("Error al guardar la respuesta al mensaje %d: %v", contactMsg.ID, err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	if respuesta.EmailOutboxID != nil {
+		// El worker puede haber entregado el correo antes de guardar la respuesta
+		var actual EmailOutbox
+		if err := db.Select("estado").First(&actual, *respuesta.EmailOutboxID).Error; err == nil && actual.Estado != respuesta.EstadoEntrega {
+			syncReplyDelivery(*respuesta.EmailOutboxID, actual.Estado)
+		}
+	}
+
+	if predefinida != nil {
+		db.Model(predefinida).UpdateColumn("usos", gorm.Expr("usos + 1"))
+	}
 
-	SendSuccessResponse(c, gin.H{"message": "Respuesta enviada correctamente"})
+	// Un mensaje respondido es legítimo: sirve de ejemplo para el filtro de spam, salvo
+	// que un administrador ya lo haya clasificado
+	if contactMsg.EntrenadoComo == "" {
+		if err := trainContactMessage(&contactMsg, false); err != nil {
+			log.Printf("Error al entrenar el filtro de spam con el mensaje %d: %v", contactMsg.ID, err)
+		}
+	}
+
+	logActivity(c, user.ID, "message_replied",
+		fmt.Sprintf("Respuesta a la conversación %d con %s", contactMsg.ID, contactMsg.Email))
+
+	SendSuccessResponse(c, gin.H{"message": mensaje, "data": respuesta})
 }
 
-func sendReplyEmail(contact ContactMessage, replyText string) error {
-	from := getEnv("EMAIL_FROM", "noreply@example.com")
-	pass := getEnv("EMAIL_PASSWORD", "")
-	smtpHost := getEnv("SMTP_HOST", "smtp.gmail.com")
-	smtpPort := getEnv("SMTP_PORT", "587")
-
-	// Log detallado de la configuración (ocultando la contraseña)
-	log.Printf("Configuración de correo: FROM=%s, HOST=%s, PORT=%s, PASS=***", 
-		from, smtpHost, smtpPort)
-
-	// Si estamos en modo desarrollo, simular el envío del correo
-	if getEnv("APP_ENV", "development") == "development" && getEnv("MOCK_EMAIL", "true") == "true" {
-		log.Println("Modo desarrollo con MOCK_EMAIL=true: Simulando envío de correo")
-		
-		// Crear un mensaje simple para modo desarrollo
-		msg := fmt.Sprintf(`
-De: %s
-Para: %s (%s)
-Asunto: Respuesta a tu mensaje
-
-Mensaje original:
-%s
-
-Respuesta:
-%s
-`, from, contact.Name, contact.Email, contact.Message, replyText)
-		
-		return os.WriteFile("last_reply_email.txt", []byte(msg), 0644)
+// contactReplyEmailData son los datos de la plantilla de respuesta a un mensaje de contacto
+type contactReplyEmailData struct {
+	Name        string
+	Email       string
+	Subject     string
+	OriginalMsg string
+	ReplyMsg    string
+	Unsubscribe string
+}
+
+// sendReplyEmail encola la respuesta y devuelve el correo del outbox para seguir su entrega.
+// El Message-ID y la dirección de respuesta con token permiten enlazar la contestación
+// del remitente con la conversación cuando llegue por correo.
+func sendReplyEmail(contact ContactMessage, replyText, messageID string) (*EmailOutbox, error) {
+	// Si el remitente tiene cuenta, se respetan sus preferencias y su idioma y se incluye el enlace de baja
+	var unsubscribeLink string
+	var extraHeaders map[string]string
+	locale := defaultEmailLocale()
+	var destinatario Usuario
+	if err := db.Where("email = ?", contact.Email).First(&destinatario).Error; err == nil {
+		prefs := loadNotificationPreferences(destinatario.ID)
+		if !prefs.allowsEmail(NotifTodas) {
+			return nil, ErrEmailOptOut
+		}
+		var oneClick string
+		unsubscribeLink, oneClick = unsubscribeLinks(prefs, NotifTodas)
+		extraHeaders = unsubscribeHeaders(oneClick)
+		locale = userEmailLocale(destinatario)
 	}
-	
-	// Verificar la configuración requerida
-	if pass == "" {
-		log.Println("Error: EMAIL_PASSWORD no está configurado")
-		return ErrEmailSendError
+
+	// Se cita el último mensaje del remitente, que puede no ser el que abrió la conversación
+	original := contact.Message
+	referencia := contact.MessageID
+	var ultimo MensajeRespuesta
+	if err := db.Where("mensaje_id = ? AND direccion = ?", contact.ID, RespuestaEntrante).
+		Order("enviado_en DESC, id DESC").First(&ultimo).Error; err == nil {
+		original = ultimo.Cuerpo
+		referencia = ultimo.MessageID
 	}
 
-	// Autenticación SMTP
-	auth := smtp.PlainAuth("", from, pass, smtpHost)
+	if extraHeaders == nil {
+		extraHeaders = map[string]string{}
+	}
+	extraHeaders["Message-ID"] = "<" + messageID + ">"
+	if referencia != "" {
+		extraHeaders["In-Reply-To"] = "<" + referencia + ">"
+		extraHeaders["References"] = "<" + referencia + ">"
+	}
 
-	// Preparar contenido del email
-	subject := "Respuesta a tu mensaje"
-	
-	// Email plano (sin HTML) como fallback si hay problemas con el template
-	plainBody := fmt.Sprintf("Hola %s,\n\nGracias por contactarnos. A continuación encontrarás nuestra respuesta a tu mensaje:\n\nTu mensaje original:\n%s\n\nNuestra respuesta:\n%s\n\nSaludos cordiales,\nEl equipo de soporte", 
-		contact.Name, contact.Message, replyText)
-	
-	// Intentar crear un email HTML
-	var emailBody string
-	
 	// Datos para el template
-	data := struct {
-		Name        string
-		Email       string
-		Subject     string
-		OriginalMsg string
-		ReplyMsg    string
-	}{
+	data := contactReplyEmailData{
 		Name:        contact.Name,
 		Email:       contact.Email,
-		Subject:     subject,
-		OriginalMsg: contact.Message,
+		Subject:     "Respuesta a tu mensaje",
+		OriginalMsg: original,
 		ReplyMsg:    replyText,
+		Unsubscribe: unsubscribeLink,
 	}
-	
-	// Intentar usar un template HTML
-	htmlTemplate := `<!DOCTYPE html>
-<html>
-<head>
-    <meta charset="UTF-8">
-    <title>{{.Subject}}</title>
-    <style>
-        body { font-family: Arial, sans-serif; line-height: 1.6; color: #333; max-width: 600px; margin: 0 auto; }
-        .header { background-color: #4a86e8; color: white; padding: 20px; text-align: center; }
-        .content { padding: 20px; }
-        .original-message { background-color: #f5f5f5; padding: 15px; border-left: 4px solid #ccc; margin: 20px 0; }
-        .footer { text-align: center; padding: 20px; font-size: 0.8em; color: #777; }
-    </style>
-</head>
-<body>
-    <div class="header">
-        <h1>{{.Subject}}</h1>
-    </div>
-    <div class="content">
-        <p>Hola {{.Name}},</p>
-        <p>Gracias por contactarnos. A continuación encontrarás nuestra respuesta a tu mensaje:</p>
-        
-        <div class="original-message">
-            <p><strong>Tu mensaje original:</strong></p>
-            <p>{{.OriginalMsg}}</p>
-        </div>
-        
-        <p><strong>Nuestra respuesta:</strong></p>
-        <p>{{.ReplyMsg}}</p>
-        
-        <p>Saludos cordiales,<br>El equipo de soporte</p>
-    </div>
-    <div class="footer">
-        <p>Este es un mensaje automático, por favor no respondas a este correo.</p>
-    </div>
-</body>
-</html>`
-	
-	// Crear el template desde el string en lugar de intentar cargar el archivo
-	tmpl, err := template.New("email_template").Parse(htmlTemplate)
-	if err != nil {
-		log.Printf("Error al procesar template HTML: %v", err)
-		// Fallback a email de texto plano
-		emailBody = plainBody
-	} else {
-		var htmlBuffer bytes.Buffer
-		if err := tmpl.Execute(&htmlBuffer, data); err != nil {
-			log.Printf("Error al renderizar template: %v", err)
-			// Fallback a email de texto plano
-			emailBody = plainBody
-		} else {
-			emailBody = htmlBuffer.String()
-		}
-	}
-	
-	// Determinar si estamos usando HTML o texto plano
-	var contentType string
-	if emailBody == plainBody {
-		contentType = "text/plain; charset=\"UTF-8\""
-	} else {
-		contentType = "text/html; charset=\"UTF-8\""
-	}
-	
-	// Construir el mensaje de correo
-	headers := "MIME-version: 1.0;\r\nContent-Type: " + contentType + ";\r\n"
-	msg := "To: " + contact.Email + "\r\n" +
-		"From: " + from + "\r\n" +
-		"Subject: " + subject + " - " + contact.Name + "\r\n" +
-		headers + "\r\n" +
-		emailBody
-	
-	// En modo desarrollo, también guardar una copia del email
-	if getEnv("APP_ENV", "development") == "development" {
-		log.Println("Guardando copia del email en last_reply_email.html")
-		os.WriteFile("last_reply_email.html", []byte(emailBody), 0644)
-	}
-	
-	// Enviar email
-	serverAddr := smtpHost + ":" + smtpPort
-	log.Printf("Intentando enviar email a %s a través de %s", contact.Email, serverAddr)
-	
-	err = smtp.SendMail(serverAddr, auth, from, []string{contact.Email}, []byte(msg))
+
+	msg, err := templateMessage(PlantillaRespuestaContacto, locale, EmailMessage{
+		To:      []string{contact.Email},
+		ReplyTo: threadReplyAddress(contact.ID),
+		Headers: extraHeaders,
+	}, data)
 	if err != nil {
-		log.Printf("Error SMTP al enviar email: %v", err)
-		return err
+		return nil, err
 	}
-	
-	log.Printf("Email enviado exitosamente a %s", contact.Email)
-	return nil
+	return queueEmail(msg)
 }
 
 func testSmtpConnection(c *gin.Context) {
+	mailer := smtpMailerFromEnv()
 	from := getEnv("EMAIL_FROM", "noreply@example.com")
-	pass := getEnv("EMAIL_PASSWORD", "")
-	smtpHost := getEnv("SMTP_HOST", "smtp.gmail.com")
-	smtpPort := getEnv("SMTP_PORT", "587")
 	testEmail := c.Query("email") // Email para prueba
 	
 	if testEmail == "" {
@@ -291,38 +353,20 @@ func testSmtpConnection(c *gin.Context) {
 	
 	// Añadir información de configuración
 	result := gin.H{
-		"smtp_host": smtpHost,
-		"smtp_port": smtpPort,
+		"smtp_host": mailer.Host,
+		"smtp_port": mailer.Port,
+		"smtp_tls": mailer.TLSMode,
 		"from_email": from,
-		"password_configured": pass != "",
+		"password_configured": mailer.Password != "",
 		"test_email": testEmail,
 	}
 	
-	// Verificar que las variables de entorno necesarias estén configuradas
-	if pass == "" {
-		result["error"] = "EMAIL_PASSWORD no está configurado"
-		result["success"] = false
-		c.JSON(http.StatusBadRequest, result)
-		return
-	}
-	
-	// Autenticación SMTP
-	auth := smtp.PlainAuth("", from, pass, smtpHost)
-	
-	// Mensaje simple para prueba
-	headers := "MIME-version: 1.0;\r\nContent-Type: text/plain; charset=\"UTF-8\";\r\n"
-	subject := "Prueba de conexión SMTP"
-	body := "Este es un mensaje de prueba para verificar la configuración SMTP."
-	
-	msg := "To: " + testEmail + "\r\n" +
-		"From: " + from + "\r\n" +
-		"Subject: " + subject + "\r\n" +
-		headers + "\r\n" +
-		body
-	
-	// Intentar conectar al servidor SMTP
-	serverAddr := smtpHost + ":" + smtpPort
-	err := smtp.SendMail(serverAddr, auth, from, []string{testEmail}, []byte(msg))
+	// Se envía directamente, sin pasar por el outbox, para ver el error en la respuesta
+	err := mailer.Send(EmailMessage{
+		To:      []string{testEmail},
+		Subject: "Prueba de conexión SMTP",
+		Text:    "Este es un mensaje de prueba para verificar la configuración SMTP.",
+	})
 	
 	if err != nil {
 		log.Printf("Error al probar conexión SMTP: %v", err)
@@ -335,4 +379,4 @@ func testSmtpConnection(c *gin.Context) {
 	result["success"] = true
 	result["message"] = "Conexión SMTP exitosa. Email de prueba enviado a " + testEmail
 	c.JSON(http.StatusOK, result)
-}
\ No newline at end of file
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidCursor se devuelve cuando el cursor de paginación no se puede interpretar
+var ErrInvalidCursor = errors.New("cursor de paginación no válido")
+
+// Columnas por las que se puede ordenar la bandeja
+var inboxSortColumns = map[string]string{
+	"actividad": "ultima_actividad",
+	"recibido":  "created_at",
+	"nombre":    "name",
+}
+
+// inboxCursor es la posición del último elemento de la página: el valor de la columna
+// de orden y el ID para desempatar
+type inboxCursor struct {
+	Valor string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+func encodeInboxCursor(cur inboxCursor) string {
+	data, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeInboxCursor(valor string) (inboxCursor, error) {
+	var cur inboxCursor
+	data, err := base64.RawURLEncoding.DecodeString(valor)
+	if err != nil || json.Unmarshal(data, &cur) != nil || cur.ID == 0 {
+		return cur, ErrInvalidCursor
+	}
+	return cur, nil
+}
+
+// parseBoolFilter interpreta un filtro true/false; nil si no se ha indicado
+func parseBoolFilter(valor string) (*bool, error) {
+	switch valor {
+	case "":
+		return nil, nil
+	case "true", "1":
+		v := true
+		return &v, nil
+	case "false", "0":
+		v := false
+		return &v, nil
+	default:
+		return nil, ErrInvalidRequest
+	}
+}
+
+// parseDateFilter acepta una fecha (2006-01-02) o un instante RFC 3339. Con fin=true,
+// una fecha sin hora incluye el día completo.
+func parseDateFilter(valor string, fin bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, valor); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", valor, time.Local)
+	if err != nil {
+		return t, ErrInvalidRequest
+	}
+	if fin {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+// inboxSearch añade la búsqueda de texto. Usa los índices FULLTEXT sobre el mensaje
+// inicial y las respuestas; si se busca un email, o solo hay palabras demasiado cortas
+// para el índice, se recurre a LIKE.
+func inboxSearch(query *gorm.DB, q string) *gorm.DB {
+	q = strings.TrimSpace(q)
+	if strings.Contains(q, "@") {
+		return query.Where("email LIKE ?", "%"+q+"%")
+	}
+
+	var terminos []string
+	for _, p := range strings.FieldsFunc(q, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		if len([]rune(p)) >= 3 {
+			terminos = append(terminos, "+"+p+"*")
+		}
+	}
+	if len(terminos) == 0 {
+		like := "%" + q + "%"
+		return query.Where("(name LIKE ? OR email LIKE ? OR message LIKE ?)", like, like, like)
+	}
+
+	busqueda := strings.Join(terminos, " ")
+	return query.Where("(MATCH(name, email, message) AGAINST (? IN BOOLEAN MODE) OR id IN (?))", busqueda,
+		db.Model(&MensajeRespuesta{}).Select("mensaje_id").Where("MATCH(cuerpo) AGAINST (? IN BOOLEAN MODE)", busqueda))
+}
+
+// contactInboxQuery aplica a la consulta los filtros de la bandeja:
+//
+//	q              búsqueda en nombre, email y texto (también en las respuestas)
+//	leido, destacado, spam, archivado   true|false (por defecto sin spam ni archivados)
+//	estado         abierto,pendiente,cerrado
+//	asignado       me|none|<id>
+//	desde, hasta   fecha de recepción
+func contactInboxQuery(c *gin.Context, user Usuario) (*gorm.DB, error) {
+	query := db.Model(&ContactMessage{})
+
+	if q := c.Query("q"); strings.TrimSpace(q) != "" {
+		query = inboxSearch(query, q)
+	}
+
+	filtros := []struct {
+		param   string
+		columna string
+		defecto *bool
+	}{
+		{"leido", "`read` = ?", nil},
+		{"destacado", "starred = ?", nil},
+		{"spam", "spam = ?", new(bool)},
+	}
+	for _, f := range filtros {
+		valor, err := parseBoolFilter(c.Query(f.param))
+		if err != nil {
+			return nil, err
+		}
+		if valor == nil {
+			valor = f.defecto
+		}
+		if valor != nil {
+			query = query.Where(f.columna, *valor)
+		}
+	}
+
+	archivado, err := parseBoolFilter(c.Query("archivado"))
+	if err != nil {
+		return nil, err
+	}
+	if archivado != nil && *archivado {
+		query = query.Where("archivado_en IS NOT NULL")
+	} else {
+		query = query.Where("archivado_en IS NULL")
+	}
+
+	if estado := c.Query("estado"); estado != "" {
+		estados, ok := threadStatusFilter(estado)
+		if !ok {
+			return nil, ErrInvalidThreadStatus
+		}
+		query = query.Where("estado IN ?", estados)
+	}
+
+	switch asignado := c.Query("asignado"); asignado {
+	case "":
+	case "me":
+		query = query.Where("asignado_id = ?", user.ID)
+	case "none":
+		query = query.Where("asignado_id IS NULL")
+	default:
+		id, err := strconv.ParseUint(asignado, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidRequest
+		}
+		query = query.Where("asignado_id = ?", id)
+	}
+
+	if desde := c.Query("desde"); desde != "" {
+		t, err := parseDateFilter(desde, false)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("created_at >= ?", t)
+	}
+	if hasta := c.Query("hasta"); hasta != "" {
+		t, err := parseDateFilter(hasta, true)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("created_at <= ?", t)
+	}
+
+	return query, nil
+}
+
+// paginateInbox ordena la consulta y la posiciona tras el cursor. Devuelve la función
+// que calcula el cursor de un mensaje para la página siguiente.
+func paginateInbox(c *gin.Context, query *gorm.DB) (*gorm.DB, func(ContactMessage) string, error) {
+	orden := c.DefaultQuery("orden", "actividad")
+	columna, ok := inboxSortColumns[orden]
+	if !ok {
+		return nil, nil, ErrInvalidRequest
+	}
+	dir := strings.ToLower(c.DefaultQuery("dir", "desc"))
+	if dir != "asc" && dir != "desc" {
+		return nil, nil, ErrInvalidRequest
+	}
+
+	valorDe := func(m ContactMessage) string {
+		switch columna {
+		case "name":
+			return m.Name
+		case "created_at":
+			return m.CreatedAt.Format(time.RFC3339Nano)
+		default:
+			if m.UltimaActividad == nil {
+				return m.CreatedAt.Format(time.RFC3339Nano)
+			}
+			return m.UltimaActividad.Format(time.RFC3339Nano)
+		}
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		cur, err := decodeInboxCursor(cursor)
+		if err != nil {
+			return nil, nil, err
+		}
+		var valor interface{} = cur.Valor
+		if columna != "name" {
+			t, err := time.Parse(time.RFC3339Nano, cur.Valor)
+			if err != nil {
+				return nil, nil, ErrInvalidCursor
+			}
+			valor = t
+		}
+		op := "<"
+		if dir == "asc" {
+			op = ">"
+		}
+		query = query.Where(fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", columna, op, columna, op), valor, valor, cur.ID)
+	}
+
+	query = query.Order(columna + " " + dir).Order("id " + dir)
+	return query, func(m ContactMessage) string {
+		return encodeInboxCursor(inboxCursor{Valor: valorDe(m), ID: m.ID})
+	}, nil
+}
+
+// contactInboxCounts calcula los contadores de la barra lateral del panel
+func contactInboxCounts(userID uint) (gin.H, error) {
+	var counts struct {
+		NoLeidos   int64
+		Destacados int64
+		Abiertos   int64
+		Asignados  int64
+		Spam       int64
+	}
+	err := db.Model(&ContactMessage{}).Select(`
+		COALESCE(SUM(spam = 0 AND archivado_en IS NULL AND `+"`read`"+` = 0), 0) AS no_leidos,
+		COALESCE(SUM(spam = 0 AND starred = 1), 0) AS destacados,
+		COALESCE(SUM(spam = 0 AND archivado_en IS NULL AND estado = ?), 0) AS abiertos,
+		COALESCE(SUM(spam = 0 AND archivado_en IS NULL AND estado <> ? AND asignado_id = ?), 0) AS asignados,
+		COALESCE(SUM(spam = 1 AND `+"`read`"+` = 0), 0) AS spam`,
+		HiloAbierto, HiloCerrado, userID).Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"no_leidos":      counts.NoLeidos,
+		"destacados":     counts.Destacados,
+		"abiertos":       counts.Abiertos,
+		"asignados_a_mi": counts.Asignados,
+		"spam":           counts.Spam,
+	}, nil
+}
+
+// getContactMessageCounts devuelve solo los contadores, para refrescar los avisos del menú
+func getContactMessageCounts(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	counts, err := contactInboxCounts(user.ID)
+	if err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	SendSuccessResponse(c, gin.H{"data": counts})
+}
+
+// BulkMessagesRequest es una acción sobre varios mensajes de la bandeja
+type BulkMessagesRequest struct {
+	IDs    []uint `json:"ids" binding:"required,min=1,max=500"`
+	Accion string `json:"accion" binding:"required"`
+}
+
+// bulkUpdateMessages aplica la acción (read, unread, star, unstar, archive, unarchive,
+// delete) a todos los mensajes indicados
+func bulkUpdateMessages(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	var req BulkMessagesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	query := db.Model(&ContactMessage{}).Where("id IN ?", req.IDs)
+	var result *gorm.DB
+	switch req.Accion {
+	case "read", "unread":
+		result = query.Update("read", req.Accion == "read")
+	case "star", "unstar":
+		result = query.Update("starred", req.Accion == "star")
+	case "archive":
+		result = query.Where("archivado_en IS NULL").Update("archivado_en", time.Now())
+	case "unarchive":
+		result = query.Update("archivado_en", nil)
+	case "delete":
+		result = db.Where("id IN ?", req.IDs).Delete(&ContactMessage{})
+	default:
+		SendErrorResponse(c, ErrInvalidRequest, http.StatusBadRequest)
+		return
+	}
+	if result.Error != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "messages_bulk_"+req.Accion,
+		fmt.Sprintf("Acción %s sobre %d mensajes", req.Accion, result.RowsAffected))
+
+	counts, err := contactInboxCounts(user.ID)
+	if err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	SendSuccessResponse(c, gin.H{"actualizados": result.RowsAffected, "counts": counts})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestInboxCursorRoundTrip(t *testing.T) {
+	tests := []inboxCursor{
+		{Valor: "2025-03-01T10:00:00.123456789+01:00", ID: 42},
+		{Valor: "Ana García", ID: 1},
+		{Valor: `comillas " y barras \ /`, ID: 7},
+		{Valor: "", ID: 99},
+	}
+	for _, cur := range tests {
+		codificado := encodeInboxCursor(cur)
+		if codificado != url.QueryEscape(codificado) {
+			t.Errorf("el cursor %q no es seguro en una URL", codificado)
+		}
+		got, err := decodeInboxCursor(codificado)
+		if err != nil {
+			t.Fatalf("decodeInboxCursor(%q): %v", codificado, err)
+		}
+		if got != cur {
+			t.Errorf("ida y vuelta = %+v, quiero %+v", got, cur)
+		}
+	}
+}
+
+func TestDecodeInboxCursorInvalid(t *testing.T) {
+	tests := []string{
+		"no es base64!",
+		encodeInboxCursor(inboxCursor{Valor: "x"}), // Sin ID
+		"bm8tanNvbg", // "no-json"
+	}
+	for _, valor := range tests {
+		if _, err := decodeInboxCursor(valor); err != ErrInvalidCursor {
+			t.Errorf("decodeInboxCursor(%q) = %v, quiero ErrInvalidCursor", valor, err)
+		}
+	}
+}
+
+// TestPaginateInboxCursor comprueba que el cursor de un mensaje, al volver en la petición
+// siguiente, posiciona la consulta justo después de ese mensaje
+func TestPaginateInboxCursor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dryRun, err := gorm.Open(mysql.New(mysql.Config{DSN: "test:test@tcp(127.0.0.1:3306)/test", SkipInitializeWithVersion: true}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatalf("gorm.Open: %v", err)
+	}
+
+	creado := time.Date(2025, 3, 1, 10, 0, 0, 123456789, time.UTC)
+	actividad := creado.Add(36 * time.Hour)
+	mensaje := ContactMessage{Name: "Ana García", UltimaActividad: &actividad}
+	mensaje.ID, mensaje.CreatedAt = 42, creado
+	sinActividad := ContactMessage{Name: "Luis"}
+	sinActividad.ID, sinActividad.CreatedAt = 43, creado
+
+	tests := []struct {
+		name    string
+		query   string
+		mensaje ContactMessage
+		valor   interface{}
+	}{
+		{"actividad descendente", "orden=actividad", mensaje, actividad},
+		{"actividad ausente usa la creación", "orden=actividad", sinActividad, creado},
+		{"recibido ascendente", "orden=recibido&dir=asc", mensaje, creado},
+		{"nombre", "orden=nombre", mensaje, "Ana García"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contexto := func(query string) *gin.Context {
+				c, _ := gin.CreateTestContext(httptest.NewRecorder())
+				c.Request = httptest.NewRequest(http.MethodGet, "/api/messages?"+query, nil)
+				return c
+			}
+
+			_, siguiente, err := paginateInbox(contexto(tt.query), dryRun.Model(&ContactMessage{}))
+			if err != nil {
+				t.Fatalf("paginateInbox: %v", err)
+			}
+			cursor := siguiente(tt.mensaje)
+
+			q, _, err := paginateInbox(contexto(tt.query+"&cursor="+cursor), dryRun.Model(&ContactMessage{}))
+			if err != nil {
+				t.Fatalf("paginateInbox con cursor: %v", err)
+			}
+			stmt := q.Find(&[]ContactMessage{}).Statement
+			want := []interface{}{tt.valor, tt.valor, tt.mensaje.ID}
+			if !reflect.DeepEqual(stmt.Vars, want) {
+				t.Errorf("parámetros = %#v, quiero %#v\n%s", stmt.Vars, want, stmt.SQL.String())
+			}
+		})
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/messages?orden=recibido&cursor="+encodeInboxCursor(inboxCursor{Valor: "ayer", ID: 1}), nil)
+	if _, _, err := paginateInbox(c, dryRun.Model(&ContactMessage{})); err != ErrInvalidCursor {
+		t.Errorf("cursor con fecha no válida: %v, quiero ErrInvalidCursor", err)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Estados de una conversación de soporte
+const (
+	HiloAbierto   = "abierto"   // Espera respuesta del equipo
+	HiloPendiente = "pendiente" // Respondida, a la espera del remitente
+	HiloCerrado   = "cerrado"
+)
+
+// Sentido de un mensaje dentro de la conversación
+const (
+	RespuestaSaliente = "saliente" // Respuesta del equipo
+	RespuestaEntrante = "entrante" // Nuevo mensaje del remitente
+)
+
+// Estados de entrega de una respuesta, además de los del outbox (pendiente, enviado, fallido)
+const (
+	EntregaSoloApp  = "solo_app" // El remitente no recibe emails: solo se entregó en la aplicación
+	EntregaRecibido = "recibido" // Mensaje entrante
+)
+
+// ErrInvalidThreadStatus se devuelve cuando el estado de la conversación no existe
+var ErrInvalidThreadStatus = errors.New("estado de conversación no válido")
+
+// MensajeRespuesta es un mensaje de una conversación de soporte posterior al inicial
+type MensajeRespuesta struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	MensajeID     uint      `gorm:"not null;index" json:"mensaje_id"`
+	Direccion     string    `gorm:"size:10;not null" json:"direccion"`
+	AutorID       *uint     `gorm:"index" json:"autor_id"` // Administrador que respondió; nulo en los entrantes
+	Autor         *Usuario  `gorm:"foreignKey:AutorID" json:"autor,omitempty"`
+	Cuerpo        string    `gorm:"type:text;not null;index:ft_respuesta_cuerpo,class:FULLTEXT" json:"cuerpo"`
+	EnviadoEn     time.Time `json:"enviado_en"`
+	EstadoEntrega string    `gorm:"size:20;not null" json:"estado_entrega"`
+	EmailOutboxID *uint     `gorm:"index" json:"email_outbox_id,omitempty"`
+	MessageID     string    `gorm:"size:255;index" json:"-"` // Message-ID del correo, para enlazar las respuestas
+	CreatedAt     time.Time `json:"created_at"`
+
+	Adjuntos []MensajeAdjunto `gorm:"foreignKey:RespuestaID" json:"adjuntos,omitempty"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (MensajeRespuesta) TableName() string {
+	return "mensaje_respuestas"
+}
+
+func validThreadStatus(estado string) bool {
+	return estado == HiloAbierto || estado == HiloPendiente || estado == HiloCerrado
+}
+
+// receiveContactMessage guarda un mensaje recibido. Con continuar, si el remitente tiene
+// una conversación sin cerrar se añade a ella y se reabre; si no, se empieza una nueva y
+// la respuesta devuelta es nil. Solo se continúa cuando el email del remitente está
+// comprobado: cualquiera puede escribir un email ajeno en el formulario público.
+// messageID es el Message-ID del correo, si el mensaje llegó por email.
+func receiveContactMessage(nombre, email, telefono, cuerpo, messageID string, continuar bool) (*ContactMessage, *MensajeRespuesta, error) {
+	var hilo ContactMessage
+	var respuesta *MensajeRespuesta
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := gorm.ErrRecordNotFound
+		if continuar {
+			err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("email = ? AND estado <> ? AND spam = ?", email, HiloCerrado, false).
+				Order("COALESCE(ultima_actividad, created_at) DESC").First(&hilo).Error
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			now := time.Now()
+			hilo = ContactMessage{
+				Name:            nombre,
+				Email:           email,
+				Phone:           telefono,
+				Message:         cuerpo,
+				Estado:          HiloAbierto,
+				UltimaActividad: &now,
+				MessageID:       messageID,
+			}
+			return tx.Create(&hilo).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		respuesta, err = appendToThread(tx, &hilo, cuerpo, messageID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &hilo, respuesta, nil
+}
+
+// appendToThread añade un mensaje del remitente a la conversación y la reabre
+func appendToThread(tx *gorm.DB, hilo *ContactMessage, cuerpo, messageID string) (*MensajeRespuesta, error) {
+	now := time.Now()
+	respuesta := MensajeRespuesta{
+		MensajeID:     hilo.ID,
+		Direccion:     RespuestaEntrante,
+		Cuerpo:        cuerpo,
+		EnviadoEn:     now,
+		EstadoEntrega: EntregaRecibido,
+		MessageID:     messageID,
+	}
+	if err := tx.Create(&respuesta).Error; err != nil {
+		return nil, err
+	}
+	err := tx.Model(hilo).Updates(map[string]interface{}{
+		"estado":           HiloAbierto,
+		"read":             false,
+		"ultima_actividad": now,
+		"archivado_en":     nil,
+	}).Error
+	return &respuesta, err
+}
+
+// loadMessageThread carga la conversación con sus respuestas en orden cronológico
+func loadMessageThread(id interface{}) (ContactMessage, error) {
+	autor := func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "nombre", "email", "image_url")
+	}
+	var hilo ContactMessage
+	err := db.Preload("Respuestas", func(db *gorm.DB) *gorm.DB {
+		return db.Order("enviado_en, id")
+	}).Preload("Respuestas.Autor", autor).Preload("Respuestas.Adjuntos").Preload("Adjuntos", "respuesta_id IS NULL").
+		Preload("Asignado", autor).First(&hilo, id).Error
+	return hilo, err
+}
+
+// syncReplyDelivery copia a las respuestas el estado del correo del outbox que las entrega
+func syncReplyDelivery(outboxID uint, estado string) {
+	if estado == EmailEnviando {
+		return
+	}
+	if err := db.Model(&MensajeRespuesta{}).Where("email_outbox_id = ?", outboxID).
+		Update("estado_entrega", estado).Error; err != nil {
+		log.Printf("Error al actualizar la entrega de la respuesta del email %d: %v", outboxID, err)
+	}
+}
+
+// updateMessageThreadStatus cambia el estado de la conversación (abierto, pendiente, cerrado)
+func updateMessageThreadStatus(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	var req struct {
+		Estado string `json:"estado" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+	if !validThreadStatus(req.Estado) {
+		SendErrorResponse(c, ErrInvalidThreadStatus, http.StatusBadRequest)
+		return
+	}
+
+	var hilo ContactMessage
+	if err := db.First(&hilo, c.Param("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		} else {
+			SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := db.Model(&hilo).Update("estado", req.Estado).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "message_status_changed",
+		fmt.Sprintf("Conversación %d con %s: %s", hilo.ID, hilo.Email, req.Estado))
+
+	SendSuccessResponse(c, gin.H{"data": hilo})
+}
+
+// assignMessageThread asigna la conversación a un miembro del equipo que pueda
+// responder mensajes, o la deja sin asignar si usuario_id es nulo
+func assignMessageThread(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	var req struct {
+		UsuarioID *uint `json:"usuario_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	var hilo ContactMessage
+	if err := db.First(&hilo, c.Param("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		} else {
+			SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var asignado Usuario
+	if req.UsuarioID != nil {
+		if err := db.First(&asignado, *req.UsuarioID).Error; err != nil {
+			SendValidationErrorResponse(c, ErrInvalidRequest, gin.H{"usuario_id": "El usuario no existe"})
+			return
+		}
+		if asignado.EsServicio || !hasPermission(asignado.Role, PermMessagesReply) {
+			SendValidationErrorResponse(c, ErrInvalidRequest, gin.H{"usuario_id": "El usuario no puede responder mensajes"})
+			return
+		}
+	}
+
+	if err := db.Model(&hilo).Update("asignado_id", req.UsuarioID).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	if req.UsuarioID == nil {
+		logActivity(c, user.ID, "message_unassigned", fmt.Sprintf("Conversación %d con %s sin asignar", hilo.ID, hilo.Email))
+	} else {
+		logActivity(c, user.ID, "message_assigned",
+			fmt.Sprintf("Conversación %d con %s asignada a %s", hilo.ID, hilo.Email, asignado.Email))
+		if asignado.ID != user.ID {
+			publishNotification(asignado.ID, NotifTipoMensajeAsignado, "Conversación asignada",
+				fmt.Sprintf("%s te ha asignado la conversación con %s", user.Nombre, hilo.Name),
+				fmt.Sprintf("/admin/messages/%d", hilo.ID))
+		}
+	}
+
+	hilo, err := loadMessageThread(hilo.ID)
+	if err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	SendSuccessResponse(c, gin.H{"data": hilo})
+}
+
+// threadStatusFilter interpreta el filtro de estado del listado ("abierto,pendiente")
+func threadStatusFilter(valor string) ([]string, bool) {
+	var estados []string
+	for _, e := range strings.Split(valor, ",") {
+		e = strings.TrimSpace(e)
+		if !validThreadStatus(e) {
+			return nil, false
+		}
+		estados = append(estados, e)
+	}
+	return estados, true
+}
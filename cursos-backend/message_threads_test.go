@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestReceiveContactMessageContinuar comprueba que un mensaje solo se añade a la
+// conversación abierta del remitente cuando su email está comprobado
+func TestReceiveContactMessageContinuar(t *testing.T) {
+	useTestDB(t)
+	email := fmt.Sprintf("hilo-%d@example.com", time.Now().UnixNano())
+	t.Cleanup(func() {
+		var ids []uint
+		db.Unscoped().Model(&ContactMessage{}).Where("email = ?", email).Pluck("id", &ids)
+		if len(ids) > 0 {
+			db.Where("mensaje_id IN ?", ids).Delete(&MensajeRespuesta{})
+			db.Unscoped().Where("id IN ?", ids).Delete(&ContactMessage{})
+		}
+	})
+
+	primero, respuesta, err := receiveContactMessage("Ana", email, "", "Primer mensaje", "", true)
+	if err != nil {
+		t.Fatalf("receiveContactMessage: %v", err)
+	}
+	if respuesta != nil {
+		t.Fatal("el primer mensaje debe abrir una conversación")
+	}
+
+	// Formulario público: aunque haya una conversación abierta con ese email, se abre otra
+	anonimo, respuesta, err := receiveContactMessage("Ana", email, "", "Desde el formulario", "", false)
+	if err != nil {
+		t.Fatalf("receiveContactMessage: %v", err)
+	}
+	if respuesta != nil || anonimo.ID == primero.ID {
+		t.Errorf("el formulario público se ha añadido a la conversación %d", primero.ID)
+	}
+
+	// Email comprobado (sesión del dueño o correo entrante): continúa la más reciente
+	continuado, respuesta, err := receiveContactMessage("Ana", email, "", "Seguimiento", "", true)
+	if err != nil {
+		t.Fatalf("receiveContactMessage: %v", err)
+	}
+	if respuesta == nil || continuado.ID != anonimo.ID {
+		t.Errorf("seguimiento: conversación %d, respuesta %v; quiero añadirlo a la %d", continuado.ID, respuesta, anonimo.ID)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// notifyNewStudent avisa al momento a quien gestiona usuarios y ha elegido avisos
+// inmediatos. El resto recibe los nuevos estudiantes en el resumen periódico.
+func notifyNewStudent(estudiante Usuario) {
+	destinatarios, err := staffRecipients(PermUsersRead, NotifNewStudents)
+	if err != nil {
+		log.Printf("Error al buscar destinatarios del aviso de nuevo estudiante: %v", err)
+		return
+	}
+
+	frontendURL := getEnv("FRONTEND_URL", "http://localhost:3000")
+	for _, u := range destinatarios {
+		if u.ID == estudiante.ID || loadNotificationPreferences(u.ID).FrecuenciaResumen != FrecuenciaInmediata {
+			continue
+		}
+
+		err := sendNotificationEmail(u, NotifNewStudents, PlantillaNuevoEstudiante, EmailMessage{}, func(unsubscribeLink string) interface{} {
+			return newStudentEmailData{
+				Name:             u.Nombre,
+				EstudianteNombre: estudiante.Nombre,
+				EstudianteEmail:  estudiante.Email,
+				Fecha:            estudiante.CreatedAt.Format("02/01/2006 15:04"),
+				AdminLink:        frontendURL + "/admin/users",
+				UnsubscribeLink:  unsubscribeLink,
+				UnsubscribeText:  "No quiero recibir avisos de nuevos estudiantes",
+			}
+		})
+		if err != nil && !errors.Is(err, ErrEmailOptOut) {
+			log.Printf("Error al enviar aviso de nuevo estudiante a %s: %v", u.Email, err)
+		}
+	}
+}
+
+// newStudentEmailData son los datos de la plantilla del aviso de nuevo estudiante
+type newStudentEmailData struct {
+	Name             string
+	EstudianteNombre string
+	EstudianteEmail  string
+	Fecha            string
+	AdminLink        string
+	UnsubscribeLink  string
+	UnsubscribeText  string
+}
+
+// digestEmailData son los datos de la plantilla del resumen periódico
+type digestEmailData struct {
+	Name               string
+	Desde              string
+	Hasta              string
+	IncluirVentas      bool
+	Ventas             int64
+	Ingresos           string
+	IncluirEstudiantes bool
+	Estudiantes        []Usuario
+	AdminLink          string
+	UnsubscribeLink    string
+	UnsubscribeText    string
+}
+
+// digestPeriod devuelve cada cuánto se envía el resumen. Con avisos inmediatos los
+// nuevos estudiantes ya se han notificado, pero el informe de ventas sigue siendo diario.
+func digestPeriod(frecuencia string) time.Duration {
+	if frecuencia == FrecuenciaSemanal {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// startNotificationDigestWorker envía periódicamente los resúmenes pendientes
+func startNotificationDigestWorker() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		sendPendingDigests()
+		<-ticker.C
+	}
+}
+
+func sendPendingDigests() {
+	vistos := make(map[uint]bool)
+	for _, permiso := range []string{PermStatsRead, PermUsersRead} {
+		usuarios, err := staffRecipients(permiso, NotifResumen)
+		if err != nil {
+			log.Printf("Error al buscar destinatarios del resumen: %v", err)
+			return
+		}
+		for _, u := range usuarios {
+			if vistos[u.ID] {
+				continue
+			}
+			vistos[u.ID] = true
+			if err := sendDigest(u, time.Now()); err != nil {
+				log.Printf("Error al enviar resumen a %s: %v", u.Email, err)
+			}
+		}
+	}
+}
+
+// sendDigest envía el resumen al usuario si ha vencido su periodo. La primera vez solo
+// se marca el inicio, para no enviar un resumen con todo el histórico.
+func sendDigest(u Usuario, now time.Time) error {
+	prefs := loadNotificationPreferences(u.ID)
+	if prefs.UltimoResumenEn == nil {
+		prefs.UltimoResumenEn = &now
+		return saveNotificationPreferences(db, &prefs)
+	}
+	desde := *prefs.UltimoResumenEn
+	if now.Sub(desde) < digestPeriod(prefs.FrecuenciaResumen) {
+		return nil
+	}
+
+	incluirVentas := prefs.SalesReports && hasPermission(u.Role, PermStatsRead)
+	incluirEstudiantes := prefs.NewStudents && prefs.FrecuenciaResumen != FrecuenciaInmediata &&
+		hasPermission(u.Role, PermUsersRead)
+
+	var ventas struct {
+		Cantidad int64
+		Total    float64
+	}
+	if incluirVentas {
+		if err := db.Model(&Pago{}).
+			Select("COUNT(*) as cantidad, COALESCE(SUM(monto), 0) as total").
+			Where("estado = 'aprobado' AND created_at BETWEEN ? AND ?", desde, now).
+			Scan(&ventas).Error; err != nil {
+			return fmt.Errorf("error al calcular ventas: %v", err)
+		}
+	}
+
+	var estudiantes []Usuario
+	if incluirEstudiantes {
+		if err := db.Where("role = ? AND es_servicio = ? AND created_at BETWEEN ? AND ?", RolUser, false, desde, now).
+			Order("created_at").Find(&estudiantes).Error; err != nil {
+			return fmt.Errorf("error al buscar nuevos estudiantes: %v", err)
+		}
+	}
+
+	if incluirVentas || incluirEstudiantes {
+		frontendURL := getEnv("FRONTEND_URL", "http://localhost:3000")
+		err := sendNotificationEmail(u, NotifResumen, PlantillaResumen, EmailMessage{}, func(unsubscribeLink string) interface{} {
+			return digestEmailData{
+				Name:               u.Nombre,
+				Desde:              desde.Format("02/01/2006 15:04"),
+				Hasta:              now.Format("02/01/2006 15:04"),
+				IncluirVentas:      incluirVentas,
+				Ventas:             ventas.Cantidad,
+				Ingresos:           fmt.Sprintf("%.2f", ventas.Total),
+				IncluirEstudiantes: incluirEstudiantes,
+				Estudiantes:        estudiantes,
+				AdminLink:          frontendURL + "/admin",
+				UnsubscribeLink:    unsubscribeLink,
+				UnsubscribeText:    "No quiero recibir el resumen de actividad",
+			}
+		})
+		if err != nil && !errors.Is(err, ErrEmailOptOut) {
+			return err
+		}
+	}
+
+	// Se avanza aunque no hubiera nada que enviar para no acumular periodos
+	return db.Model(&PreferenciasNotificacion{}).Where("usuario_id = ?", u.ID).
+		Update("ultimo_resumen_en", now).Error
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSendDigestRespectsFrequency comprueba que el resumen se envía al vencer el periodo
+// elegido, con un enlace de baja, y que no se repite dentro del mismo periodo
+func TestSendDigestRespectsFrequency(t *testing.T) {
+	useTestDB(t)
+	admin := createTestUser(t, RolAdmin)
+	t.Cleanup(func() {
+		db.Where("usuario_id = ?", admin.ID).Delete(&PreferenciasNotificacion{})
+		db.Where("destinatario = ?", admin.Email).Delete(&EmailOutbox{})
+	})
+
+	contar := func() int64 {
+		var n int64
+		db.Model(&EmailOutbox{}).Where("destinatario = ? AND asunto = ?", admin.Email, "Resumen de actividad").Count(&n)
+		return n
+	}
+
+	now := time.Now()
+	// La primera vez solo se marca el inicio del periodo
+	if err := sendDigest(admin, now); err != nil {
+		t.Fatalf("sendDigest: %v", err)
+	}
+	if n := contar(); n != 0 {
+		t.Fatalf("%d resúmenes en la primera ejecución, quiero 0", n)
+	}
+
+	// Semanal: a los 3 días todavía no toca
+	if err := sendDigest(admin, now.Add(3*24*time.Hour)); err != nil {
+		t.Fatalf("sendDigest: %v", err)
+	}
+	if n := contar(); n != 0 {
+		t.Fatalf("%d resúmenes antes de cumplirse la semana, quiero 0", n)
+	}
+
+	if err := sendDigest(admin, now.Add(8*24*time.Hour)); err != nil {
+		t.Fatalf("sendDigest: %v", err)
+	}
+	if n := contar(); n != 1 {
+		t.Fatalf("%d resúmenes al cumplirse la semana, quiero 1", n)
+	}
+	var correo EmailOutbox
+	if err := db.Where("destinatario = ?", admin.Email).Last(&correo).Error; err != nil {
+		t.Fatal(err)
+	}
+	if correo.Cabeceras == "" {
+		t.Error("el resumen no lleva la cabecera List-Unsubscribe")
+	}
+
+	// Tras darse de baja del resumen no se envía más
+	prefs := loadNotificationPreferences(admin.ID)
+	if err := prefs.unsubscribe(NotifResumen); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveNotificationPreferences(db, &prefs); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendDigest(admin, now.Add(16*24*time.Hour)); err != nil {
+		t.Fatalf("sendDigest: %v", err)
+	}
+	if n := contar(); n != 1 {
+		t.Errorf("%d resúmenes tras la baja, quiero 1", n)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Categorías de notificación. NotifTodas no es una categoría: desactiva el canal de email.
+const (
+	NotifNewMessages   = "new_messages"
+	NotifNewStudents   = "new_students"
+	NotifSalesReports  = "sales_reports"
+	NotifSystemUpdates = "system_updates"
+	NotifResumen       = "digest" // Resumen periódico (ventas y nuevos estudiantes)
+	NotifTodas         = "all"
+)
+
+// Frecuencias del resumen periódico
+const (
+	FrecuenciaInmediata = "inmediato" // Avisos al momento; el informe de ventas es diario
+	FrecuenciaDiaria    = "diario"
+	FrecuenciaSemanal   = "semanal"
+)
+
+// ErrEmailOptOut indica que el destinatario ha desactivado ese tipo de email
+var ErrEmailOptOut = errors.New("el destinatario ha desactivado estas notificaciones por email")
+
+// PreferenciasNotificacion guarda qué avisos quiere recibir cada usuario y por qué canal.
+// Los booleanos no llevan default en la base de datos: gorm omitiría los false al crear.
+type PreferenciasNotificacion struct {
+	UsuarioID          uint       `gorm:"primaryKey;autoIncrement:false" json:"-"`
+	EmailNotifications bool       `gorm:"not null" json:"emailNotifications"` // Canal email
+	InAppNotifications bool       `gorm:"not null" json:"inAppNotifications"` // Canal en la aplicación
+	NewMessages        bool       `gorm:"not null" json:"newMessages"`
+	NewStudents        bool       `gorm:"not null" json:"newStudents"`
+	SalesReports       bool       `gorm:"not null" json:"salesReports"`
+	SystemUpdates      bool       `gorm:"not null" json:"systemUpdates"`
+	FrecuenciaResumen  string     `gorm:"size:20;not null" json:"digestFrequency"`
+	UltimoResumenEn    *time.Time `json:"-"`
+	VersionBaja        uint       `gorm:"not null;default:0" json:"-"` // Al incrementarla caducan los enlaces de baja ya enviados
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (PreferenciasNotificacion) TableName() string {
+	return "preferencias_notificacion"
+}
+
+// defaultNotificationPreferences son las preferencias de quien nunca las ha cambiado
+func defaultNotificationPreferences(userID uint) PreferenciasNotificacion {
+	return PreferenciasNotificacion{
+		UsuarioID:          userID,
+		EmailNotifications: true,
+		InAppNotifications: true,
+		NewMessages:        true,
+		NewStudents:        true,
+		SalesReports:       true,
+		SystemUpdates:      false,
+		FrecuenciaResumen:  FrecuenciaSemanal,
+	}
+}
+
+// loadNotificationPreferences devuelve las preferencias guardadas o las predeterminadas
+func loadNotificationPreferences(userID uint) PreferenciasNotificacion {
+	var prefs PreferenciasNotificacion
+	if err := db.First(&prefs, "usuario_id = ?", userID).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error al cargar preferencias de notificación del usuario %d: %v", userID, err)
+		}
+		return defaultNotificationPreferences(userID)
+	}
+	return prefs
+}
+
+// wantsCategory indica si la categoría está activada, con independencia del canal
+func (p PreferenciasNotificacion) wantsCategory(categoria string) bool {
+	switch categoria {
+	case NotifNewMessages:
+		return p.NewMessages
+	case NotifNewStudents:
+		return p.NewStudents
+	case NotifSalesReports:
+		return p.SalesReports
+	case NotifSystemUpdates:
+		return p.SystemUpdates
+	case NotifResumen:
+		return p.SalesReports || p.NewStudents
+	default:
+		return true
+	}
+}
+
+// allowsEmail indica si se puede enviar por email un aviso de la categoría
+func (p PreferenciasNotificacion) allowsEmail(categoria string) bool {
+	return p.EmailNotifications && p.wantsCategory(categoria)
+}
+
+// allowsInApp indica si se puede mostrar en la aplicación un aviso de la categoría
+func (p PreferenciasNotificacion) allowsInApp(categoria string) bool {
+	return p.InAppNotifications && p.wantsCategory(categoria)
+}
+
+// unsubscribe desactiva la categoría indicada (o todo el canal de email)
+func (p *PreferenciasNotificacion) unsubscribe(categoria string) error {
+	switch categoria {
+	case NotifNewMessages:
+		p.NewMessages = false
+	case NotifNewStudents:
+		p.NewStudents = false
+	case NotifSalesReports:
+		p.SalesReports = false
+	case NotifSystemUpdates:
+		p.SystemUpdates = false
+	case NotifResumen:
+		p.SalesReports = false
+		p.NewStudents = false
+	case NotifTodas:
+		p.EmailNotifications = false
+	default:
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// saveNotificationPreferences crea o actualiza la fila del usuario
+func saveNotificationPreferences(tx *gorm.DB, prefs *PreferenciasNotificacion) error {
+	return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(prefs).Error
+}
+
+// unsubscribeToken firma el usuario, la categoría y la versión de baja. No caduca por
+// tiempo, porque un enlace de baja debe funcionar aunque el correo sea antiguo, pero deja
+// de valer en cuanto se usa o el usuario vuelve a guardar sus preferencias.
+func unsubscribeToken(userID uint, categoria string, version uint) string {
+	id := strconv.FormatUint(uint64(userID), 10)
+	v := strconv.FormatUint(uint64(version), 10)
+	return id + "." + categoria + "." + v + "." + linkSignature("unsubscribe", id, categoria, v)
+}
+
+// parseUnsubscribeToken valida la firma y devuelve usuario, categoría y versión
+func parseUnsubscribeToken(token string) (uint, string, uint, error) {
+	partes := strings.SplitN(token, ".", 4)
+	if len(partes) != 4 {
+		return 0, "", 0, ErrInvalidToken
+	}
+	id, err1 := strconv.ParseUint(partes[0], 10, 64)
+	version, err2 := strconv.ParseUint(partes[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, "", 0, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(unsubscribeToken(uint(id), partes[1], uint(version))), []byte(token)) {
+		return 0, "", 0, ErrInvalidToken
+	}
+	return uint(id), partes[1], uint(version), nil
+}
+
+// unsubscribeLinks devuelve el enlace visible (página del frontend) y el de baja
+// en un clic (RFC 8058) que usan los clientes de correo
+func unsubscribeLinks(prefs PreferenciasNotificacion, categoria string) (string, string) {
+	token := url.QueryEscape(unsubscribeToken(prefs.UsuarioID, categoria, prefs.VersionBaja))
+	frontendURL := getEnv("FRONTEND_URL", "http://localhost:3000")
+	baseURL := strings.TrimRight(getEnv("BASE_URL", "http://localhost:"+getEnv("PORT", "5000")), "/")
+	return frontendURL + "/unsubscribe?token=" + token, baseURL + "/api/notifications/unsubscribe?token=" + token
+}
+
+// unsubscribeHeaders devuelve las cabeceras List-Unsubscribe para el correo
+func unsubscribeHeaders(oneClickURL string) map[string]string {
+	return map[string]string{
+		"List-Unsubscribe":      "<" + oneClickURL + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+// sendNotificationEmail envía un aviso con la plantilla indicada si las preferencias del
+// usuario lo permiten. data recibe el enlace de baja para incluirlo en el pie del correo;
+// msg puede aportar Reply-To.
+func sendNotificationEmail(user Usuario, categoria, plantilla string, msg EmailMessage, data func(unsubscribeLink string) interface{}) error {
+	prefs := loadNotificationPreferences(user.ID)
+	if !prefs.allowsEmail(categoria) {
+		return ErrEmailOptOut
+	}
+
+	pagina, unClic := unsubscribeLinks(prefs, categoria)
+	msg.To = []string{user.Email}
+	msg.Headers = unsubscribeHeaders(unClic)
+	return sendTemplateEmail(plantilla, userEmailLocale(user), msg, data(pagina))
+}
+
+// staffRecipients devuelve los usuarios con el permiso indicado que aceptan por email
+// la categoría. Las cuentas de servicio nunca reciben correo.
+func staffRecipients(permiso, categoria string) ([]Usuario, error) {
+	roles := rolesWithPermission(permiso)
+	if len(roles) == 0 {
+		return nil, nil
+	}
+
+	var usuarios []Usuario
+	if err := db.Where("role IN ? AND es_servicio = ?", roles, false).Find(&usuarios).Error; err != nil {
+		return nil, err
+	}
+
+	destinatarios := make([]Usuario, 0, len(usuarios))
+	for _, u := range usuarios {
+		if loadNotificationPreferences(u.ID).allowsEmail(categoria) {
+			destinatarios = append(destinatarios, u)
+		}
+	}
+	return destinatarios, nil
+}
+
+// unsubscribeNotifications procesa la baja en un clic. Acepta el token por query
+// (cabecera List-Unsubscribe) o en el cuerpo JSON (página del frontend).
+func unsubscribeNotifications(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		var req struct {
+			Token string `json:"token"`
+		}
+		_ = c.ShouldBindJSON(&req)
+		token = req.Token
+	}
+
+	userID, categoria, version, err := parseUnsubscribeToken(token)
+	if err != nil {
+		SendErrorResponse(c, ErrInvalidToken, http.StatusBadRequest)
+		return
+	}
+
+	var user Usuario
+	if err := db.First(&user, userID).Error; err != nil {
+		SendErrorResponse(c, ErrInvalidToken, http.StatusBadRequest)
+		return
+	}
+
+	prefs := loadNotificationPreferences(userID)
+	if prefs.VersionBaja != version {
+		SendErrorResponse(c, ErrInvalidToken, http.StatusBadRequest)
+		return
+	}
+	if err := prefs.unsubscribe(categoria); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+	prefs.VersionBaja++
+	if err := saveNotificationPreferences(db, &prefs); err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, userID, "notifications_unsubscribe", fmt.Sprintf("Baja de notificaciones por email: %s", categoria))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"message":   "Has dejado de recibir estas notificaciones. Puedes volver a activarlas desde tu perfil.",
+		"categoria": categoria,
+	})
+}
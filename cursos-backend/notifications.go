@@ -0,0 +1,408 @@
+package main
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tipos de notificación en la aplicación
+const (
+	NotifTipoRespuestaMensaje = "message_reply"
+	NotifTipoPagoConfirmado   = "payment_confirmed"
+	NotifTipoNuevoCapitulo    = "new_chapter"
+	NotifTipoAnuncioCurso     = "course_announcement"
+	NotifTipoMensajeAsignado  = "message_assigned"
+	NotifTipoMensajeRecibido  = "message_received"
+)
+
+// Notificacion es un aviso del centro de notificaciones (icono de la campana)
+type Notificacion struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UsuarioID uint       `gorm:"not null;index:idx_notificacion_usuario_leida" json:"-"`
+	Tipo      string     `gorm:"size:40;not null" json:"tipo"`
+	Titulo    string     `gorm:"size:150;not null" json:"titulo"`
+	Mensaje   string     `gorm:"type:text" json:"mensaje"`
+	Enlace    string     `gorm:"size:255" json:"enlace,omitempty"` // Ruta del frontend a la que lleva el aviso
+	LeidaEn   *time.Time `gorm:"index:idx_notificacion_usuario_leida" json:"leida_en"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (Notificacion) TableName() string {
+	return "notificaciones"
+}
+
+// AnuncioCursoRequest es el cuerpo de un anuncio a los alumnos de un curso
+type AnuncioCursoRequest struct {
+	Titulo  string `json:"titulo" binding:"required,max=150"`
+	Mensaje string `json:"mensaje" binding:"required"`
+}
+
+// notificationHub reparte las notificaciones nuevas entre las conexiones SSE abiertas.
+// Cada usuario puede tener varias (una por pestaña o dispositivo).
+type notificationHub struct {
+	mu   sync.Mutex
+	subs map[uint]map[chan Notificacion]struct{}
+}
+
+var notifHub = &notificationHub{subs: make(map[uint]map[chan Notificacion]struct{})}
+
+func (h *notificationHub) subscribe(userID uint) chan Notificacion {
+	ch := make(chan Notificacion, 16)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[chan Notificacion]struct{})
+	}
+	h.subs[userID][ch] = struct{}{}
+	return ch
+}
+
+func (h *notificationHub) unsubscribe(userID uint, ch chan Notificacion) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs[userID], ch)
+	if len(h.subs[userID]) == 0 {
+		delete(h.subs, userID)
+	}
+}
+
+// broadcast no bloquea: si un cliente va retrasado pierde el aviso en directo,
+// pero lo verá al recargar la lista
+func (h *notificationHub) broadcast(n Notificacion) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[n.UsuarioID] {
+		select {
+		case ch <- n:
+		default:
+		}
+	}
+}
+
+// publishNotification es el punto único para crear avisos en la aplicación: respeta las
+// preferencias del usuario, guarda el aviso y lo envía a sus conexiones abiertas.
+// Devuelve false si el usuario no quiere avisos en la aplicación o si no se pudo guardar.
+func publishNotification(userID uint, tipo, titulo, mensaje, enlace string) bool {
+	if !loadNotificationPreferences(userID).allowsInApp(tipo) {
+		return false
+	}
+
+	n := Notificacion{
+		UsuarioID: userID,
+		Tipo:      tipo,
+		Titulo:    truncate(titulo, 150),
+		Mensaje:   mensaje,
+		Enlace:    truncate(enlace, 255),
+	}
+	if err := db.Create(&n).Error; err != nil {
+		log.Printf("Error al guardar notificación para el usuario %d: %v", userID, err)
+		return false
+	}
+	notifHub.broadcast(n)
+	return true
+}
+
+// publishCourseNotification avisa a todos los alumnos con acceso al curso
+func publishCourseNotification(cursoID uint, tipo, titulo, mensaje, enlace string) int {
+	var alumnos []uint
+	if err := db.Model(&Pago{}).Where("curso_id = ? AND estado = ? AND usuario_id IS NOT NULL", cursoID, "aprobado").
+		Distinct().Pluck("usuario_id", &alumnos).Error; err != nil {
+		log.Printf("Error al buscar alumnos del curso %d: %v", cursoID, err)
+		return 0
+	}
+
+	enviadas := 0
+	for _, id := range alumnos {
+		if publishNotification(id, tipo, titulo, mensaje, enlace) {
+			enviadas++
+		}
+	}
+	return enviadas
+}
+
+// notifyPaymentApproved avisa al comprador de que ya tiene acceso al curso
+func notifyPaymentApproved(pago Pago) {
+	if pago.Estado != "aprobado" || pago.UsuarioID == nil {
+		return
+	}
+	var curso Curso
+	if err := db.Select("id", "titulo").First(&curso, pago.CursoID).Error; err != nil {
+		log.Printf("Error al cargar el curso %d del pago %d: %v", pago.CursoID, pago.ID, err)
+		return
+	}
+	publishNotification(*pago.UsuarioID, NotifTipoPagoConfirmado, "Pago confirmado",
+		fmt.Sprintf("Tu pago de %.2f para \"%s\" se ha confirmado. Ya puedes acceder al curso.", pago.Monto, curso.Titulo),
+		fmt.Sprintf("/curso/%d", curso.ID))
+}
+
+// notifyNewChapter avisa a los alumnos del curso cuando se publica un capítulo
+func notifyNewChapter(capitulo Capitulo) {
+	var curso Curso
+	if err := db.Select("id", "titulo").First(&curso, capitulo.CursoID).Error; err != nil {
+		log.Printf("Error al cargar el curso %d del capítulo %d: %v", capitulo.CursoID, capitulo.ID, err)
+		return
+	}
+	publishCourseNotification(curso.ID, NotifTipoNuevoCapitulo, "Nuevo capítulo disponible",
+		fmt.Sprintf("Se ha publicado \"%s\" en el curso \"%s\".", capitulo.Titulo, curso.Titulo),
+		fmt.Sprintf("/curso/%d", curso.ID))
+}
+
+// getNotificaciones lista las notificaciones del usuario, más recientes primero
+func getNotificaciones(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	query := db.Model(&Notificacion{}).Where("usuario_id = ?", user.ID)
+	if c.Query("unread") == "true" {
+		query = query.Where("leida_en IS NULL")
+	}
+
+	var total, noLeidas int64
+	if err := query.Count(&total).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	if err := db.Model(&Notificacion{}).Where("usuario_id = ? AND leida_en IS NULL", user.ID).
+		Count(&noLeidas).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	var notificaciones []Notificacion
+	if err := query.Order("created_at DESC, id DESC").Offset((page - 1) * limit).Limit(limit).
+		Find(&notificaciones).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"data":      notificaciones,
+		"no_leidas": noLeidas,
+		"pagination": gin.H{
+			"total": total,
+			"page":  page,
+			"limit": limit,
+			"pages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+// markNotificacionLeida marca una notificación como leída
+func markNotificacionLeida(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	result := db.Model(&Notificacion{}).
+		Where("id = ? AND usuario_id = ? AND leida_en IS NULL", c.Param("id"), user.ID).
+		Update("leida_en", time.Now())
+	if result.Error != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		// Ya estaba leída o no es del usuario
+		var count int64
+		db.Model(&Notificacion{}).Where("id = ? AND usuario_id = ?", c.Param("id"), user.ID).Count(&count)
+		if count == 0 {
+			SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+			return
+		}
+	}
+
+	SendSuccessResponse(c, gin.H{"message": "Notificación marcada como leída"})
+}
+
+// markAllNotificacionesLeidas marca como leídas todas las notificaciones del usuario
+func markAllNotificacionesLeidas(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	result := db.Model(&Notificacion{}).Where("usuario_id = ? AND leida_en IS NULL", user.ID).
+		Update("leida_en", time.Now())
+	if result.Error != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	SendSuccessResponse(c, gin.H{
+		"message":      "Notificaciones marcadas como leídas",
+		"actualizadas": result.RowsAffected,
+	})
+}
+
+// sseTicketTTL es el tiempo para abrir el stream con un ticket recién emitido
+const sseTicketTTL = time.Minute
+
+// sseCheckInterval es cada cuánto se comprueba que la sesión del stream sigue activa
+const sseCheckInterval = 30 * time.Second
+
+// createStreamTicket emite un ticket de un solo uso para abrir el stream SSE. EventSource
+// no puede enviar la cabecera Authorization y el JWT no debe ir en la URL (acabaría en
+// los logs y el historial), así que la URL solo lleva este ticket de corta duración.
+func createStreamTicket(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+	sesion := c.GetString("session_id")
+
+	// El stream no sobrevive al token con el que se pidió el ticket
+	expira := time.Now().Add(accessTokenTTL())
+	if t, ok := c.Get("token_expira"); ok {
+		expira = t.(time.Time)
+	}
+
+	campos := []string{
+		strconv.FormatUint(uint64(user.ID), 10),
+		sesion,
+		strconv.FormatInt(time.Now().Add(sseTicketTTL).Unix(), 10),
+		strconv.FormatInt(expira.Unix(), 10),
+		randomHex(12),
+	}
+	ticket := strings.Join(campos, ".") + "." + linkSignature(append([]string{"sse_ticket"}, campos...)...)
+	c.Header("Cache-Control", "no-store")
+	SendSuccessResponse(c, gin.H{"ticket": ticket, "expira_en": int(sseTicketTTL.Seconds())})
+}
+
+// sseTicketAuth autentica el stream con ?ticket=: comprueba la firma, la caducidad, que
+// no se haya usado y que la sesión siga activa
+func sseTicketAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		partes := strings.Split(c.Query("ticket"), ".")
+		if len(partes) != 6 ||
+			!hmac.Equal([]byte(linkSignature(append([]string{"sse_ticket"}, partes[:5]...)...)), []byte(partes[5])) {
+			SendErrorResponse(c, ErrInvalidToken, http.StatusUnauthorized)
+			return
+		}
+		userID, err1 := strconv.ParseUint(partes[0], 10, 64)
+		caduca, err2 := strconv.ParseInt(partes[2], 10, 64)
+		tokenExpira, err3 := strconv.ParseInt(partes[3], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil || time.Now().Unix() > caduca {
+			SendErrorResponse(c, ErrInvalidToken, http.StatusUnauthorized)
+			return
+		}
+		sesion := partes[1]
+		if sesion == "" || !isSessionActive(sesion) {
+			SendErrorResponse(c, ErrSessionRevoked, http.StatusUnauthorized)
+			return
+		}
+
+		if err := consumeToken(db, "sse_ticket:"+partes[4], time.Unix(caduca, 0)); err != nil {
+			if !errors.Is(err, ErrTokenAlreadyUsed) {
+				log.Printf("Error al canjear el ticket del stream SSE: %v", err)
+			}
+			SendErrorResponse(c, ErrInvalidToken, http.StatusUnauthorized)
+			return
+		}
+
+		var user Usuario
+		if err := db.First(&user, userID).Error; err != nil {
+			SendErrorResponse(c, ErrUserNotFound, http.StatusUnauthorized)
+			return
+		}
+		c.Set("user", user)
+		c.Set("session_id", sesion)
+		c.Set("token_expira", time.Unix(tokenExpira, 0))
+		c.Next()
+	}
+}
+
+// streamNotificaciones mantiene abierta una conexión Server-Sent Events y envía cada
+// notificación nueva en cuanto se publica. Se cierra al revocar la sesión o caducar el
+// token; el cliente pide entonces otro ticket.
+func streamNotificaciones(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+	sesion := c.GetString("session_id")
+	expiraValue, _ := c.Get("token_expira")
+	expira, _ := expiraValue.(time.Time)
+
+	ch := notifHub.subscribe(user.ID)
+	defer notifHub.unsubscribe(user.ID, ch)
+
+	// El servidor tiene WriteTimeout: esta conexión debe poder quedarse abierta
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("No se pudo quitar el límite de escritura del stream SSE: %v", err)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Evita que nginx acumule los eventos
+
+	// Comentario periódico para que proxies y navegador no cierren la conexión
+	keepAlive := time.NewTicker(25 * time.Second)
+	defer keepAlive.Stop()
+	comprobacion := time.NewTicker(sseCheckInterval)
+	defer comprobacion.Stop()
+
+	c.SSEvent("ready", gin.H{"usuario_id": user.ID})
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case n := <-ch:
+			c.SSEvent("notificacion", n)
+			return true
+		case <-keepAlive.C:
+			_, _ = io.WriteString(w, ": ping\n\n")
+			return true
+		case <-comprobacion.C:
+			if (!expira.IsZero() && time.Now().After(expira)) || !isSessionActive(sesion) {
+				c.SSEvent("expired", gin.H{"motivo": "la sesión ha caducado o se ha cerrado"})
+				return false
+			}
+			return true
+		}
+	})
+}
+
+// announceCourse publica un anuncio para todos los alumnos de un curso
+func announceCourse(c *gin.Context) {
+	var req AnuncioCursoRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	var curso Curso
+	if err := db.Select("id", "titulo").First(&curso, c.Param("id")).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	enviadas := publishCourseNotification(curso.ID, NotifTipoAnuncioCurso, req.Titulo, req.Mensaje,
+		fmt.Sprintf("/curso/%d", curso.ID))
+
+	logActivity(c, user.ID, "course_announcement",
+		fmt.Sprintf("Anuncio \"%s\" en el curso ID %d enviado a %d alumnos", truncate(req.Titulo, 100), curso.ID, enviadas))
+
+	SendSuccessResponse(c, gin.H{
+		"message":  "Anuncio publicado",
+		"enviadas": enviadas,
+	})
+}
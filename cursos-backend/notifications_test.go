@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStreamTicketSingleUse(t *testing.T) {
+	useTestDB(t)
+	user := createTestUser(t, RolUser)
+	pair, err := createSession(testContext(), user)
+	if err != nil {
+		t.Fatalf("createSession: %v", err)
+	}
+	claims, err := parseAccessToken(pair.AccessToken, false)
+	if err != nil {
+		t.Fatalf("parseAccessToken: %v", err)
+	}
+
+	r := gin.New()
+	r.POST("/ticket", func(c *gin.Context) {
+		c.Set("user", user)
+		c.Set("session_id", claims.SessionID)
+		c.Set("token_expira", claims.ExpiresAt.Time)
+	}, createStreamTicket)
+	r.GET("/stream", sseTicketAuth(), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ticket", nil))
+	var resp struct {
+		Data struct {
+			Ticket string `json:"ticket"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Data.Ticket == "" {
+		t.Fatalf("respuesta sin ticket: %s", w.Body.String())
+	}
+	t.Cleanup(func() { db.Where("clave LIKE ?", "sse_ticket:%").Delete(&TokenConsumido{}) })
+
+	abrir := func(ticket string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stream?ticket="+url.QueryEscape(ticket), nil))
+		return w.Code
+	}
+
+	if code := abrir(resp.Data.Ticket); code != http.StatusNoContent {
+		t.Fatalf("primer uso del ticket: %d, quiero %d", code, http.StatusNoContent)
+	}
+	if code := abrir(resp.Data.Ticket); code != http.StatusUnauthorized {
+		t.Errorf("segundo uso del ticket: %d, quiero %d", code, http.StatusUnauthorized)
+	}
+	if code := abrir(resp.Data.Ticket + "0"); code != http.StatusUnauthorized {
+		t.Errorf("ticket alterado: %d, quiero %d", code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// OAuthProvider describe un proveedor de identidad externo. Los proveedores OIDC
+// (Google) obtienen sus endpoints del documento de descubrimiento y validan el
+// ID token; los OAuth2 puros (GitHub) consultan la API del proveedor.
+type OAuthProvider struct {
+	Nombre       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	OIDC         bool
+	DiscoveryURL string
+
+	// Endpoints fijos para proveedores sin descubrimiento
+	AuthURL  string
+	TokenURL string
+	APIURL   string
+}
+
+// OAuthUserInfo es la identidad externa normalizada
+type OAuthUserInfo struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Nombre        string
+	Imagen        string
+}
+
+// oidcDiscovery contiene los campos del documento .well-known/openid-configuration
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcClaims son los claims del ID token que nos interesan
+type oidcClaims struct {
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"` // Algunos proveedores lo envían como texto
+	Name          string      `json:"name"`
+	Picture       string      `json:"picture"`
+	Nonce         string      `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+var (
+	oauthProviders = map[string]*OAuthProvider{}
+	oauthClient    = &http.Client{Timeout: 10 * time.Second}
+
+	discoveryMu    sync.Mutex
+	discoveryCache = map[string]discoveryEntry{}
+
+	jwksMu    sync.Mutex
+	jwksCache = map[string]jwksEntry{}
+)
+
+type discoveryEntry struct {
+	doc       oidcDiscovery
+	fetchedAt time.Time
+}
+
+type jwksEntry struct {
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+const (
+	discoveryCacheTTL = 1 * time.Hour
+	jwksCacheTTL      = 1 * time.Hour
+	jwksMinRefresh    = 1 * time.Minute // Evita refrescar en bucle ante kids desconocidos
+)
+
+// initOAuthProviders registra los proveedores que tengan credenciales configuradas
+func initOAuthProviders() {
+	if id := getEnv("GOOGLE_CLIENT_ID", ""); id != "" {
+		oauthProviders["google"] = &OAuthProvider{
+			Nombre:       "google",
+			ClientID:     id,
+			ClientSecret: getEnv("GOOGLE_CLIENT_SECRET", ""),
+			Scopes:       []string{"openid", "email", "profile"},
+			OIDC:         true,
+			DiscoveryURL: getEnv("GOOGLE_DISCOVERY_URL", "https://accounts.google.com/.well-known/openid-configuration"),
+		}
+	}
+
+	if id := getEnv("GITHUB_CLIENT_ID", ""); id != "" {
+		oauthProviders["github"] = &OAuthProvider{
+			Nombre:       "github",
+			ClientID:     id,
+			ClientSecret: getEnv("GITHUB_CLIENT_SECRET", ""),
+			Scopes:       []string{"read:user", "user:email"},
+			AuthURL:      getEnv("GITHUB_AUTH_URL", "https://github.com/login/oauth/authorize"),
+			TokenURL:     getEnv("GITHUB_TOKEN_URL", "https://github.com/login/oauth/access_token"),
+			APIURL:       strings.TrimRight(getEnv("GITHUB_API_URL", "https://api.github.com"), "/"),
+		}
+	}
+
+	for nombre := range oauthProviders {
+		log.Printf("Proveedor de inicio de sesión externo habilitado: %s", nombre)
+	}
+}
+
+// oauthRedirectURI devuelve la URL de retorno registrada en el proveedor
+func oauthRedirectURI(provider string) string {
+	base := strings.TrimRight(getEnv("OAUTH_REDIRECT_BASE_URL", "http://localhost:"+getEnv("PORT", "5000")), "/")
+	return base + "/api/auth/oauth/" + provider + "/callback"
+}
+
+// pkceChallenge calcula el code_challenge S256 de un code_verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// getJSON hace una petición GET y decodifica la respuesta JSON
+func getJSON(rawURL string, headers map[string]string, dest interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("respuesta %d de %s: %s", resp.StatusCode, rawURL, strings.TrimSpace(string(body)))
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(dest)
+}
+
+// discover obtiene (y cachea) el documento de descubrimiento OIDC del proveedor
+func (p *OAuthProvider) discover() (*oidcDiscovery, error) {
+	discoveryMu.Lock()
+	defer discoveryMu.Unlock()
+
+	if entry, ok := discoveryCache[p.DiscoveryURL]; ok && time.Since(entry.fetchedAt) < discoveryCacheTTL {
+		return &entry.doc, nil
+	}
+
+	var doc oidcDiscovery
+	if err := getJSON(p.DiscoveryURL, nil, &doc); err != nil {
+		return nil, fmt.Errorf("error en el descubrimiento OIDC: %v", err)
+	}
+	if doc.Issuer == "" || doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("documento de descubrimiento OIDC incompleto")
+	}
+
+	discoveryCache[p.DiscoveryURL] = discoveryEntry{doc: doc, fetchedAt: time.Now()}
+	return &doc, nil
+}
+
+// authorizationURL construye la URL a la que se redirige al usuario
+func (p *OAuthProvider) authorizationURL(state, codeVerifier, nonce string) (string, error) {
+	endpoint := p.AuthURL
+	if p.OIDC {
+		doc, err := p.discover()
+		if err != nil {
+			return "", err
+		}
+		endpoint = doc.AuthorizationEndpoint
+	}
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", oauthRedirectURI(p.Nombre))
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("code_challenge", pkceChallenge(codeVerifier))
+	q.Set("code_challenge_method", "S256")
+	if p.OIDC {
+		q.Set("nonce", nonce)
+	}
+
+	sep := "?"
+	if strings.Contains(endpoint, "?") {
+		sep = "&"
+	}
+	return endpoint + sep + q.Encode(), nil
+}
+
+// exchangeCode canjea el código de autorización por los tokens del proveedor
+func (p *OAuthProvider) exchangeCode(code, codeVerifier string) (accessToken, idToken string, err error) {
+	endpoint := p.TokenURL
+	if p.OIDC {
+		doc, err := p.discover()
+		if err != nil {
+			return "", "", err
+		}
+		endpoint = doc.TokenEndpoint
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", oauthRedirectURI(p.Nombre))
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		ErrorDesc   string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", "", fmt.Errorf("respuesta de token no válida (%d): %v", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", "", fmt.Errorf("el proveedor rechazó el código: %s %s", body.Error, body.ErrorDesc)
+	}
+
+	return body.AccessToken, body.IDToken, nil
+}
+
+// fetchUserInfo completa el flujo y devuelve la identidad externa verificada
+func (p *OAuthProvider) fetchUserInfo(code, codeVerifier, nonce string) (*OAuthUserInfo, error) {
+	accessToken, idToken, err := p.exchangeCode(code, codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.OIDC {
+		if idToken == "" {
+			return nil, errors.New("el proveedor no devolvió un ID token")
+		}
+		return p.validateIDToken(idToken, nonce)
+	}
+
+	return p.fetchGitHubUser(accessToken)
+}
+
+// validateIDToken verifica firma (JWKS), emisor, audiencia, caducidad y nonce del ID token
+func (p *OAuthProvider) validateIDToken(idToken, nonce string) (*OAuthUserInfo, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &oidcClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}))
+	_, err = parser.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return jwksKey(doc.JWKSURI, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ID token inválido: %v", err)
+	}
+
+	if !claims.VerifyIssuer(doc.Issuer, true) {
+		return nil, errors.New("emisor del ID token no válido")
+	}
+	if !claims.VerifyAudience(p.ClientID, true) {
+		return nil, errors.New("audiencia del ID token no válida")
+	}
+	if claims.Nonce == "" || claims.Nonce != nonce {
+		return nil, errors.New("nonce del ID token no válido")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("el ID token no contiene el identificador del usuario")
+	}
+
+	verified := false
+	switch v := claims.EmailVerified.(type) {
+	case bool:
+		verified = v
+	case string:
+		verified = v == "true"
+	}
+
+	return &OAuthUserInfo{
+		Subject:       claims.Subject,
+		Email:         strings.ToLower(claims.Email),
+		EmailVerified: verified,
+		Nombre:        claims.Name,
+		Imagen:        claims.Picture,
+	}, nil
+}
+
+// fetchGitHubUser obtiene el usuario y su email principal verificado de la API de GitHub
+func (p *OAuthProvider) fetchGitHubUser(accessToken string) (*OAuthUserInfo, error) {
+	headers := map[string]string{"Authorization": "Bearer " + accessToken}
+
+	var gh struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := getJSON(p.APIURL+"/user", headers, &gh); err != nil {
+		return nil, err
+	}
+	if gh.ID == 0 {
+		return nil, errors.New("respuesta de usuario de GitHub no válida")
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(p.APIURL+"/user/emails", headers, &emails); err != nil {
+		return nil, err
+	}
+
+	info := &OAuthUserInfo{
+		Subject: fmt.Sprint(gh.ID),
+		Nombre:  gh.Name,
+		Imagen:  gh.AvatarURL,
+	}
+	if info.Nombre == "" {
+		info.Nombre = gh.Login
+	}
+	for _, e := range emails {
+		if e.Primary {
+			info.Email = strings.ToLower(e.Email)
+			info.EmailVerified = e.Verified
+			break
+		}
+	}
+
+	return info, nil
+}
+
+// jwksKey devuelve la clave pública con el kid indicado, refrescando el JWKS si no se conoce
+func jwksKey(jwksURI, kid string) (interface{}, error) {
+	jwksMu.Lock()
+	defer jwksMu.Unlock()
+
+	entry, ok := jwksCache[jwksURI]
+	if ok && time.Since(entry.fetchedAt) < jwksCacheTTL {
+		if key := pickJWK(entry.keys, kid); key != nil {
+			return key, nil
+		}
+		if time.Since(entry.fetchedAt) < jwksMinRefresh {
+			return nil, fmt.Errorf("clave %q no encontrada en el JWKS", kid)
+		}
+	}
+
+	keys, err := fetchJWKS(jwksURI)
+	if err != nil {
+		return nil, err
+	}
+	jwksCache[jwksURI] = jwksEntry{keys: keys, fetchedAt: time.Now()}
+
+	if key := pickJWK(keys, kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("clave %q no encontrada en el JWKS", kid)
+}
+
+// pickJWK selecciona la clave por kid; si el token no trae kid y solo hay una clave, se usa esa
+func pickJWK(keys map[string]interface{}, kid string) interface{} {
+	if key, ok := keys[kid]; ok {
+		return key
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key
+		}
+	}
+	return nil
+}
+
+// fetchJWKS descarga y decodifica las claves públicas RSA y EC de un JWKS
+func fetchJWKS(jwksURI string) (map[string]interface{}, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := getJSON(jwksURI, nil, &set); err != nil {
+		return nil, fmt.Errorf("error al descargar el JWKS: %v", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				log.Printf("Clave RSA %q inválida en el JWKS", k.Kid)
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				log.Printf("Clave EC %q inválida en el JWKS", k.Kid)
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{
+				Curve: curve,
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("el JWKS no contiene claves de firma utilizables")
+	}
+	return keys, nil
+}
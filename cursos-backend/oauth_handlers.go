@@ -0,0 +1,473 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// UsuarioIdentidad vincula una cuenta con la identidad de un proveedor externo.
+// Un usuario puede tener varias (Google, GitHub...).
+type UsuarioIdentidad struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UsuarioID uint       `gorm:"not null;index" json:"usuario_id"`
+	Proveedor string     `gorm:"size:30;not null;uniqueIndex:idx_proveedor_subject" json:"proveedor"`
+	Subject   string     `gorm:"size:191;not null;uniqueIndex:idx_proveedor_subject" json:"-"`
+	Email     string     `gorm:"size:100" json:"email"`
+	UltimoUso *time.Time `json:"ultimo_uso"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (UsuarioIdentidad) TableName() string {
+	return "usuario_identidades"
+}
+
+// OAuthEstado guarda el estado de un flujo en curso (state, PKCE y nonce) para que
+// funcione con varias instancias. Al volver del proveedor la misma fila pasa a guardar
+// el código de canje de un solo uso con el que el frontend obtiene la sesión.
+type OAuthEstado struct {
+	ID              uint      `gorm:"primaryKey"`
+	EstadoHash      string    `gorm:"size:64;not null;uniqueIndex"`
+	Proveedor       string    `gorm:"size:30;not null"`
+	CodeVerifier    string    `gorm:"size:100;not null"`
+	Nonce           string    `gorm:"size:100"`
+	VincularUsuario *uint     // Si está informado, el flujo vincula la identidad a este usuario
+	LoginCodeHash   *string   `gorm:"size:64;uniqueIndex"`
+	UsuarioID       *uint     // Usuario autenticado al completar el flujo
+	ExpiresAt       time.Time `gorm:"index"`
+	CreatedAt       time.Time
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (OAuthEstado) TableName() string {
+	return "oauth_estados"
+}
+
+// OAuthExchangeRequest estructura para canjear el código de inicio de sesión
+type OAuthExchangeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+const (
+	oauthStateTTL     = 10 * time.Minute
+	oauthLoginCodeTTL = 2 * time.Minute
+
+	// oauthStateCookie liga el flujo al navegador que lo inició: sin ella, un atacante
+	// podría hacer que la víctima complete el callback con el state y el code del atacante
+	oauthStateCookie     = "oauth_state"
+	oauthStateCookiePath = "/api/auth/oauth"
+)
+
+// Errores del flujo que se pueden mostrar tal cual al usuario
+var (
+	errOAuthUnverifiedEmail = errors.New("el proveedor no ha verificado tu email; inicia sesión con tu contraseña y vincula la cuenta desde tu perfil")
+	errOAuthNoEmail         = errors.New("el proveedor no ha facilitado un email")
+	errOAuthIdentityTaken   = errors.New("esa cuenta externa ya está vinculada a otro usuario")
+	errOAuthUnverifiedLocal = errors.New("ya existe una cuenta con ese email sin verificar; recupera el acceso con \"olvidé mi contraseña\" y vincula la cuenta desde tu perfil")
+)
+
+// oauthErrorMessage evita mostrar al usuario errores internos (base de datos, red...)
+func oauthErrorMessage(err error) string {
+	if errors.Is(err, errOAuthUnverifiedEmail) || errors.Is(err, errOAuthNoEmail) || errors.Is(err, errOAuthIdentityTaken) ||
+		errors.Is(err, errOAuthUnverifiedLocal) {
+		return err.Error()
+	}
+	return "error al iniciar sesión"
+}
+
+// oauthFrontendRedirect devuelve al usuario a la página del frontend que completa el flujo
+func oauthFrontendRedirect(c *gin.Context, params url.Values) {
+	frontendURL := getEnv("FRONTEND_URL", "http://localhost:3000")
+	c.Redirect(http.StatusFound, frontendURL+"/oauth/callback?"+params.Encode())
+}
+
+func oauthFrontendError(c *gin.Context, mensaje string) {
+	oauthFrontendRedirect(c, url.Values{"error": {mensaje}})
+}
+
+// setOAuthStateCookie guarda el state en una cookie HttpOnly; SameSite=Lax permite que
+// llegue en la redirección de vuelta desde el proveedor. Con maxAge negativo la borra.
+func setOAuthStateCookie(c *gin.Context, state string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthStateCookie, state, maxAge, oauthStateCookiePath, "",
+		getEnv("APP_ENV", "development") == "production", true)
+}
+
+// beginOAuth crea el estado del flujo, lo liga al navegador con una cookie y devuelve la
+// URL de autorización del proveedor
+func beginOAuth(c *gin.Context, provider *OAuthProvider, vincular *uint) (string, error) {
+	state, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := provider.authorizationURL(state, verifier, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	estado := OAuthEstado{
+		EstadoHash:      hashToken(state),
+		Proveedor:       provider.Nombre,
+		CodeVerifier:    verifier,
+		Nonce:           nonce,
+		VincularUsuario: vincular,
+		ExpiresAt:       time.Now().Add(oauthStateTTL),
+	}
+	if err := db.Create(&estado).Error; err != nil {
+		return "", err
+	}
+
+	// Limpieza oportunista de flujos abandonados
+	db.Where("expires_at < ?", time.Now()).Delete(&OAuthEstado{})
+
+	setOAuthStateCookie(c, state, int(oauthStateTTL.Seconds()))
+	return authURL, nil
+}
+
+// listOAuthProviders devuelve los proveedores habilitados para mostrar los botones
+func listOAuthProviders(c *gin.Context) {
+	nombres := make([]string, 0, len(oauthProviders))
+	for nombre := range oauthProviders {
+		nombres = append(nombres, nombre)
+	}
+	SendSuccessResponse(c, nombres)
+}
+
+// startOAuthLogin redirige al proveedor para iniciar sesión
+func startOAuthLogin(c *gin.Context) {
+	provider, ok := oauthProviders[c.Param("provider")]
+	if !ok {
+		SendErrorResponse(c, errors.New("proveedor no soportado"), http.StatusNotFound)
+		return
+	}
+
+	authURL, err := beginOAuth(c, provider, nil)
+	if err != nil {
+		log.Printf("Error al iniciar flujo OAuth con %s: %v", provider.Nombre, err)
+		SendErrorResponse(c, errors.New("no se pudo contactar con el proveedor"), http.StatusBadGateway)
+		return
+	}
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// startOAuthLink devuelve la URL para vincular un proveedor a la cuenta autenticada
+func startOAuthLink(c *gin.Context) {
+	provider, ok := oauthProviders[c.Param("provider")]
+	if !ok {
+		SendErrorResponse(c, errors.New("proveedor no soportado"), http.StatusNotFound)
+		return
+	}
+
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	authURL, err := beginOAuth(c, provider, &user.ID)
+	if err != nil {
+		log.Printf("Error al iniciar vinculación OAuth con %s: %v", provider.Nombre, err)
+		SendErrorResponse(c, errors.New("no se pudo contactar con el proveedor"), http.StatusBadGateway)
+		return
+	}
+
+	SendSuccessResponse(c, gin.H{"url": authURL})
+}
+
+// oauthCallback recibe al usuario de vuelta del proveedor
+func oauthCallback(c *gin.Context) {
+	provider, ok := oauthProviders[c.Param("provider")]
+	if !ok {
+		oauthFrontendError(c, "proveedor no soportado")
+		return
+	}
+
+	if errParam := c.Query("error"); errParam != "" {
+		oauthFrontendError(c, "inicio de sesión cancelado")
+		return
+	}
+
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" {
+		oauthFrontendError(c, "respuesta del proveedor incompleta")
+		return
+	}
+
+	cookie, _ := c.Cookie(oauthStateCookie)
+	setOAuthStateCookie(c, "", -1)
+
+	// El state se consume una sola vez
+	var estado OAuthEstado
+	if err := db.Where("estado_hash = ? AND proveedor = ? AND login_code_hash IS NULL AND expires_at > ?",
+		hashToken(state), provider.Nombre, time.Now()).First(&estado).Error; err != nil {
+		oauthFrontendError(c, "sesión de inicio caducada, vuelve a intentarlo")
+		return
+	}
+	// El flujo tiene que terminar en el mismo navegador que lo empezó
+	if cookie == "" || subtle.ConstantTimeCompare([]byte(hashToken(cookie)), []byte(estado.EstadoHash)) != 1 {
+		oauthFrontendError(c, "sesión de inicio caducada, vuelve a intentarlo")
+		return
+	}
+	// Marcarlo como consumido antes de hablar con el proveedor evita reutilizarlo en paralelo
+	consumido := db.Model(&OAuthEstado{}).Where("id = ? AND expires_at > ?", estado.ID, time.Now()).
+		Update("expires_at", time.Now())
+	if consumido.Error != nil || consumido.RowsAffected == 0 {
+		oauthFrontendError(c, "sesión de inicio caducada, vuelve a intentarlo")
+		return
+	}
+
+	info, err := provider.fetchUserInfo(code, estado.CodeVerifier, estado.Nonce)
+	if err != nil {
+		log.Printf("Error en el callback OAuth de %s: %v", provider.Nombre, err)
+		oauthFrontendError(c, "no se pudo verificar tu identidad con el proveedor")
+		return
+	}
+
+	if estado.VincularUsuario != nil {
+		db.Delete(&estado)
+		if err := linkIdentity(*estado.VincularUsuario, provider.Nombre, info); err != nil {
+			log.Printf("Error al vincular identidad de %s: %v", provider.Nombre, err)
+			oauthFrontendError(c, oauthErrorMessage(err))
+			return
+		}
+		logActivity(c, *estado.VincularUsuario, "link_identity", fmt.Sprintf("Cuenta de %s vinculada (%s)", provider.Nombre, info.Email))
+		oauthFrontendRedirect(c, url.Values{"linked": {provider.Nombre}})
+		return
+	}
+
+	user, err := resolveOAuthUser(c, provider.Nombre, info)
+	if err != nil {
+		log.Printf("Error al resolver usuario OAuth de %s: %v", provider.Nombre, err)
+		oauthFrontendError(c, oauthErrorMessage(err))
+		return
+	}
+
+	// Los tokens no viajan en la URL: el frontend canjea un código de un solo uso
+	loginCode, err := generateOpaqueToken()
+	if err != nil {
+		oauthFrontendError(c, "error al iniciar sesión")
+		return
+	}
+	if err := db.Model(&estado).Updates(map[string]interface{}{
+		"login_code_hash": hashToken(loginCode),
+		"usuario_id":      user.ID,
+		"expires_at":      time.Now().Add(oauthLoginCodeTTL),
+	}).Error; err != nil {
+		log.Printf("Error al guardar código de inicio de sesión OAuth: %v", err)
+		oauthFrontendError(c, "error al iniciar sesión")
+		return
+	}
+
+	oauthFrontendRedirect(c, url.Values{"code": {loginCode}, "provider": {provider.Nombre}})
+}
+
+// resolveOAuthUser busca la cuenta vinculada, la enlaza por email verificado o crea una nueva
+func resolveOAuthUser(c *gin.Context, proveedor string, info *OAuthUserInfo) (*Usuario, error) {
+	var identidad UsuarioIdentidad
+	err := db.Where("proveedor = ? AND subject = ?", proveedor, info.Subject).First(&identidad).Error
+	if err == nil {
+		var user Usuario
+		if err := db.First(&user, identidad.UsuarioID).Error; err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		db.Model(&identidad).Update("ultimo_uso", now)
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if info.Email == "" {
+		return nil, errOAuthNoEmail
+	}
+
+	var user Usuario
+	err = db.Where("email = ?", info.Email).First(&user).Error
+	switch {
+	case err == nil:
+		// Cuenta existente: solo se vincula si el proveedor garantiza que el email es suyo
+		if !info.EmailVerified {
+			return nil, errOAuthUnverifiedEmail
+		}
+		// Si la cuenta local nunca verificó el email, pudo crearla otra persona con una
+		// contraseña que conoce: vincularla le daría acceso a la cuenta del dueño real
+		if !user.EmailVerificado {
+			logActivity(c, user.ID, "link_identity_refused", fmt.Sprintf("Vinculación con %s rechazada: email local sin verificar", proveedor))
+			return nil, errOAuthUnverifiedLocal
+		}
+		if err := linkIdentity(user.ID, proveedor, info); err != nil {
+			return nil, err
+		}
+		logActivity(c, user.ID, "link_identity", fmt.Sprintf("Cuenta de %s vinculada por email verificado", proveedor))
+
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		nombre := info.Nombre
+		if nombre == "" {
+			nombre = info.Email
+		}
+		user = Usuario{
+			Nombre:          truncate(nombre, 100),
+			Email:           info.Email,
+			Password:        "", // Sin contraseña: puede crear una con "olvidé mi contraseña"
+			Role:            RolUser,
+			ImageURL:        truncate(info.Imagen, 255),
+			EmailVerificado: info.EmailVerified,
+		}
+		if info.EmailVerified {
+			now := time.Now()
+			user.EmailVerificadoEn = &now
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			now := time.Now()
+			return tx.Create(&UsuarioIdentidad{
+				UsuarioID: user.ID,
+				Proveedor: proveedor,
+				Subject:   info.Subject,
+				Email:     info.Email,
+				UltimoUso: &now,
+			}).Error
+		})
+		if err != nil {
+			return nil, err
+		}
+		logActivity(c, user.ID, "register", fmt.Sprintf("Cuenta creada con %s", proveedor))
+		go notifyNewStudent(user)
+
+	default:
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// linkIdentity vincula una identidad externa a un usuario
+func linkIdentity(userID uint, proveedor string, info *OAuthUserInfo) error {
+	var existente UsuarioIdentidad
+	err := db.Where("proveedor = ? AND subject = ?", proveedor, info.Subject).First(&existente).Error
+	if err == nil {
+		if existente.UsuarioID != userID {
+			return errOAuthIdentityTaken
+		}
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	now := time.Now()
+	return db.Create(&UsuarioIdentidad{
+		UsuarioID: userID,
+		Proveedor: proveedor,
+		Subject:   info.Subject,
+		Email:     info.Email,
+		UltimoUso: &now,
+	}).Error
+}
+
+// exchangeOAuthCode canjea el código de un solo uso por la sesión habitual
+func exchangeOAuthCode(c *gin.Context) {
+	var req OAuthExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	var estado OAuthEstado
+	if err := db.Where("login_code_hash = ? AND expires_at > ?", hashToken(req.Code), time.Now()).
+		First(&estado).Error; err != nil || estado.UsuarioID == nil {
+		SendErrorResponse(c, ErrInvalidToken, http.StatusUnauthorized)
+		return
+	}
+	if res := db.Delete(&estado); res.Error != nil || res.RowsAffected == 0 {
+		SendErrorResponse(c, ErrInvalidToken, http.StatusUnauthorized)
+		return
+	}
+
+	var user Usuario
+	if err := db.First(&user, *estado.UsuarioID).Error; err != nil {
+		SendErrorResponse(c, ErrUserNotFound, http.StatusUnauthorized)
+		return
+	}
+
+	if restante := accountLockedFor(user); restante > 0 {
+		sendAccountLockedResponse(c, restante)
+		return
+	}
+
+	completeLogin(c, user, "oauth:"+estado.Proveedor)
+}
+
+// listMyIdentities devuelve los proveedores vinculados a la cuenta
+func listMyIdentities(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	var identidades []UsuarioIdentidad
+	if err := db.Where("usuario_id = ?", user.ID).Order("created_at").Find(&identidades).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	SendSuccessResponse(c, gin.H{
+		"identities":   identidades,
+		"has_password": user.Password != "",
+	})
+}
+
+// unlinkIdentity desvincula un proveedor, siempre que quede otra forma de entrar
+func unlinkIdentity(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		SendErrorResponse(c, ErrInvalidRequest, http.StatusBadRequest)
+		return
+	}
+
+	var identidad UsuarioIdentidad
+	if err := db.Where("id = ? AND usuario_id = ?", id, user.ID).First(&identidad).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	var total int64
+	db.Model(&UsuarioIdentidad{}).Where("usuario_id = ?", user.ID).Count(&total)
+	if user.Password == "" && total <= 1 {
+		SendErrorResponse(c, errors.New("crea una contraseña antes de desvincular tu único método de acceso"), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.Delete(&identidad).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "unlink_identity", fmt.Sprintf("Cuenta de %s desvinculada", identidad.Proveedor))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Cuenta externa desvinculada correctamente",
+	})
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// testIdP es un proveedor OIDC mínimo: documento de descubrimiento y JWKS con una clave RSA
+type testIdP struct {
+	srv *httptest.Server
+	key *rsa.PrivateKey
+	kid string
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	idp := &testIdP{key: key, kid: "clave-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                idp.srv.URL,
+			AuthorizationEndpoint: idp.srv.URL + "/authorize",
+			TokenEndpoint:         idp.srv.URL + "/token",
+			JWKSURI:               idp.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": idp.kid,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	idp.srv = httptest.NewServer(mux)
+	t.Cleanup(idp.srv.Close)
+	return idp
+}
+
+func (idp *testIdP) provider() *OAuthProvider {
+	return &OAuthProvider{
+		Nombre:       "test",
+		ClientID:     "cliente-cursos",
+		OIDC:         true,
+		DiscoveryURL: idp.srv.URL + "/.well-known/openid-configuration",
+	}
+}
+
+// sign firma los claims con el método, la clave y el kid indicados
+func (idp *testIdP) sign(t *testing.T, claims jwt.MapClaims, kid string, method jwt.SigningMethod, key interface{}) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	firmado, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	return firmado
+}
+
+func TestValidateIDToken(t *testing.T) {
+	idp := newTestIdP(t)
+	p := idp.provider()
+	otraClave, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+
+	base := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            idp.srv.URL,
+			"aud":            p.ClientID,
+			"sub":            "12345",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          "nonce-1",
+			"email":          "Ana@Example.com",
+			"email_verified": true,
+			"name":           "Ana García",
+		}
+	}
+	con := func(cambios jwt.MapClaims) jwt.MapClaims {
+		claims := base()
+		for k, v := range cambios {
+			if v == nil {
+				delete(claims, k)
+			} else {
+				claims[k] = v
+			}
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		nonce  string
+		want   *OAuthUserInfo
+		wantOK bool
+	}{
+		{
+			name:   "válido",
+			token:  idp.sign(t, base(), idp.kid, jwt.SigningMethodRS256, idp.key),
+			nonce:  "nonce-1",
+			want:   &OAuthUserInfo{Subject: "12345", Email: "ana@example.com", EmailVerified: true, Nombre: "Ana García"},
+			wantOK: true,
+		},
+		{
+			name:   "email_verified como texto",
+			token:  idp.sign(t, con(jwt.MapClaims{"email_verified": "true"}), idp.kid, jwt.SigningMethodRS256, idp.key),
+			nonce:  "nonce-1",
+			want:   &OAuthUserInfo{Subject: "12345", Email: "ana@example.com", EmailVerified: true, Nombre: "Ana García"},
+			wantOK: true,
+		},
+		{
+			name:   "email sin verificar",
+			token:  idp.sign(t, con(jwt.MapClaims{"email_verified": false}), idp.kid, jwt.SigningMethodRS256, idp.key),
+			nonce:  "nonce-1",
+			want:   &OAuthUserInfo{Subject: "12345", Email: "ana@example.com", Nombre: "Ana García"},
+			wantOK: true,
+		},
+		{
+			name:  "nonce distinto",
+			token: idp.sign(t, base(), idp.kid, jwt.SigningMethodRS256, idp.key),
+			nonce: "otro-nonce",
+		},
+		{
+			name:  "sin nonce",
+			token: idp.sign(t, con(jwt.MapClaims{"nonce": nil}), idp.kid, jwt.SigningMethodRS256, idp.key),
+			nonce: "",
+		},
+		{
+			name:  "otra audiencia",
+			token: idp.sign(t, con(jwt.MapClaims{"aud": "otro-cliente"}), idp.kid, jwt.SigningMethodRS256, idp.key),
+			nonce: "nonce-1",
+		},
+		{
+			name:  "otro emisor",
+			token: idp.sign(t, con(jwt.MapClaims{"iss": "https://malicioso.example.com"}), idp.kid, jwt.SigningMethodRS256, idp.key),
+			nonce: "nonce-1",
+		},
+		{
+			name:  "caducado",
+			token: idp.sign(t, con(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}), idp.kid, jwt.SigningMethodRS256, idp.key),
+			nonce: "nonce-1",
+		},
+		{
+			name:  "sin sub",
+			token: idp.sign(t, con(jwt.MapClaims{"sub": nil}), idp.kid, jwt.SigningMethodRS256, idp.key),
+			nonce: "nonce-1",
+		},
+		{
+			name:  "firmado con otra clave",
+			token: idp.sign(t, base(), idp.kid, jwt.SigningMethodRS256, otraClave),
+			nonce: "nonce-1",
+		},
+		{
+			name:  "kid desconocido",
+			token: idp.sign(t, base(), "clave-retirada", jwt.SigningMethodRS256, idp.key),
+			nonce: "nonce-1",
+		},
+		{
+			name:  "HS256 con el client_id como secreto",
+			token: idp.sign(t, base(), idp.kid, jwt.SigningMethodHS256, []byte(p.ClientID)),
+			nonce: "nonce-1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := p.validateIDToken(tt.token, tt.nonce)
+			if !tt.wantOK {
+				if err == nil {
+					t.Fatalf("se esperaba un error y se obtuvo %+v", info)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateIDToken: %v", err)
+			}
+			if *info != *tt.want {
+				t.Errorf("validateIDToken = %+v, quiero %+v", *info, *tt.want)
+			}
+		})
+	}
+}
+
+// TestOAuthCallbackRequiresStateCookie comprueba que el callback solo acepta el state en el
+// navegador que inició el flujo
+func TestOAuthCallbackRequiresStateCookie(t *testing.T) {
+	useTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	provider := &OAuthProvider{
+		Nombre:   "test-cookie",
+		ClientID: "cliente-cursos",
+		AuthURL:  "https://idp.example.com/authorize",
+		TokenURL: "http://127.0.0.1:1/token", // Inalcanzable: basta con llegar a este paso
+	}
+	oauthProviders[provider.Nombre] = provider
+	t.Cleanup(func() {
+		delete(oauthProviders, provider.Nombre)
+		db.Where("proveedor = ?", provider.Nombre).Delete(&OAuthEstado{})
+	})
+
+	router := gin.New()
+	router.GET("/api/auth/oauth/:provider/start", startOAuthLogin)
+	router.GET("/api/auth/oauth/:provider/callback", oauthCallback)
+
+	inicio := httptest.NewRecorder()
+	router.ServeHTTP(inicio, httptest.NewRequest(http.MethodGet, "/api/auth/oauth/test-cookie/start", nil))
+	if inicio.Code != http.StatusFound {
+		t.Fatalf("start = %d, quiero 302: %s", inicio.Code, inicio.Body.String())
+	}
+	authURL, err := url.Parse(inicio.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Location no válida: %v", err)
+	}
+	state := authURL.Query().Get("state")
+	var cookie *http.Cookie
+	for _, ck := range inicio.Result().Cookies() {
+		if ck.Name == oauthStateCookie {
+			cookie = ck
+		}
+	}
+	if cookie == nil || cookie.Value != state || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("cookie de state = %+v, quiero HttpOnly, SameSite=Lax y el state %q", cookie, state)
+	}
+
+	callback := func(cookies ...*http.Cookie) string {
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/oauth/test-cookie/callback?state="+url.QueryEscape(state)+"&code=abc", nil)
+		for _, ck := range cookies {
+			req.AddCookie(ck)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		destino, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("Location no válida: %v", err)
+		}
+		return destino.Query().Get("error")
+	}
+
+	if got := callback(); !strings.Contains(got, "caducada") {
+		t.Errorf("callback sin cookie: error = %q, quiero que se rechace", got)
+	}
+	if got := callback(&http.Cookie{Name: oauthStateCookie, Value: "otro-state"}); !strings.Contains(got, "caducada") {
+		t.Errorf("callback con otra cookie: error = %q, quiero que se rechace", got)
+	}
+	// Los intentos rechazados no consumen el state: el navegador legítimo puede terminar
+	if got := callback(cookie); !strings.Contains(got, "no se pudo verificar") {
+		t.Errorf("callback con la cookie: error = %q, quiero que llegue a canjear el code", got)
+	}
+}
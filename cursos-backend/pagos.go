@@ -186,7 +186,7 @@ func crearPago(c *gin.Context) {
 
 	// Crear el nuevo registro de pago
 	pago := Pago{
-		UsuarioID:     user.ID,
+		UsuarioID:     &user.ID,
 		CursoID:       req.CursoID,
 		Monto:         req.Monto,
 		Metodo:        req.Metodo,
@@ -414,7 +414,7 @@ func crearCargoCoinbase(pago Pago, curso Curso) (*CoinbaseCharge, error) {
 		Metadata: CoinbaseMetadata{
 			PagoID:    pago.ID,
 			CursoID:   pago.CursoID,
-			UsuarioID: pago.UsuarioID,
+			UsuarioID: *pago.UsuarioID,
 		},
 		RedirectURL: fmt.Sprintf("%s/pagos/completado", getEnv("FRONTEND_URL", "")),
 		CancelURL:   fmt.Sprintf("%s/pagos/cancelado", getEnv("FRONTEND_URL", "")),
@@ -526,6 +526,7 @@ func verificarPagoPorCurso(c *gin.Context) {
 						pago.Estado = "aprobado"
 						db.Save(&pago)
 						log.Printf("Actualizado estado de pago ID %d a 'aprobado' según PayPal", pago.ID)
+						notifyPaymentApproved(pago)
 					}
 				} else if err != nil {
 					log.Printf("Error al verificar estado con PayPal: %v", err)
@@ -626,6 +627,7 @@ func callbackPayPal(c *gin.Context) {
 			// Continuar a pesar del error, para no bloquear al usuario
 		} else {
 			log.Printf("Pago ID %d actualizado a estado '%s'", pago.ID, pago.Estado)
+			notifyPaymentApproved(pago)
 		}
 	}
 
@@ -680,6 +682,7 @@ func simularPasarelaPago(pagoID uint, metodo string) {
 		log.Printf("Error al actualizar estado de pago ID %d: %v", pagoID, result.Error)
 	} else {
 		log.Printf("Pago ID %d actualizado a estado: %s", pagoID, pago.Estado)
+		notifyPaymentApproved(pago)
 	}
 }
 
@@ -738,6 +741,7 @@ func webhookPago(c *gin.Context) {
 	}
 
 	// Actualizar estado y posiblemente el ID de transacción
+	estadoAnterior := pago.Estado
 	pago.Estado = payload.Estado
 	if payload.TransaccionID != "" {
 		pago.TransaccionID = payload.TransaccionID
@@ -751,6 +755,9 @@ func webhookPago(c *gin.Context) {
 
 	// Registrar la actualización en logs
 	log.Printf("Pago ID %d actualizado a estado %s mediante webhook", pago.ID, pago.Estado)
+	if estadoAnterior != pago.Estado {
+		notifyPaymentApproved(pago)
+	}
 
 	SendSuccessResponse(c, gin.H{
 		"message": "Estado de pago actualizado correctamente",
@@ -823,6 +830,9 @@ func webhookPayPal(c *gin.Context) {
 
 	log.Printf("Pago ID %d actualizado de '%s' a 'aprobado' mediante webhook PayPal",
 		pago.ID, estadoAnterior)
+	if estadoAnterior != pago.Estado {
+		notifyPaymentApproved(pago)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Webhook de PayPal procesado correctamente",
@@ -914,6 +924,9 @@ func webhookCoinbase(c *gin.Context) {
 
 	log.Printf("Pago ID %d actualizado de '%s' a '%s' mediante webhook Coinbase",
 		pago.ID, estadoAnterior, pago.Estado)
+	if estadoAnterior != pago.Estado {
+		notifyPaymentApproved(pago)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Webhook de Coinbase procesado correctamente",
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bcrypt ignora todo lo que pasa de 72 bytes, así que no se admiten contraseñas más largas
+const bcryptMaxBytes = 72
+
+// Clases de caracteres que puede exigir la política
+const (
+	ClaseMinuscula = "lower"
+	ClaseMayuscula = "upper"
+	ClaseDigito    = "digit"
+	ClaseSimbolo   = "symbol"
+)
+
+var clasesCaracteres = map[string]string{
+	ClaseMinuscula: "una letra minúscula",
+	ClaseMayuscula: "una letra mayúscula",
+	ClaseDigito:    "un número",
+	ClaseSimbolo:   "un símbolo",
+}
+
+//go:embed data/common_passwords.txt
+var commonPasswordsData string
+
+var (
+	commonPasswordsOnce sync.Once
+	commonPasswords     map[string]bool
+)
+
+// PasswordPolicy reúne las reglas que debe cumplir una contraseña nueva
+type PasswordPolicy struct {
+	MinLength       int      `json:"min_length"`
+	MaxLength       int      `json:"max_length"` // En bytes, por el límite de bcrypt
+	RequiredClasses []string `json:"required_classes"`
+	RejectCommon    bool     `json:"reject_common"`
+	RejectBreached  bool     `json:"reject_breached"`
+}
+
+// PasswordViolation describe una regla incumplida
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// currentPasswordPolicy lee la política de las variables de entorno
+func currentPasswordPolicy() PasswordPolicy {
+	policy := PasswordPolicy{
+		MinLength:      parseIntEnv("PASSWORD_MIN_LENGTH", 8),
+		MaxLength:      parseIntEnv("PASSWORD_MAX_LENGTH", bcryptMaxBytes),
+		RejectCommon:   getEnv("PASSWORD_REJECT_COMMON", "true") != "false",
+		RejectBreached: getEnv("PASSWORD_BREACH_RANGES_DIR", "") != "",
+	}
+	if policy.MaxLength > bcryptMaxBytes {
+		policy.MaxLength = bcryptMaxBytes
+	}
+	if policy.MinLength > policy.MaxLength {
+		policy.MinLength = policy.MaxLength
+	}
+
+	for _, clase := range strings.Split(getEnv("PASSWORD_REQUIRED_CLASSES", "lower,upper,digit"), ",") {
+		clase = strings.TrimSpace(clase)
+		if clase == "" {
+			continue
+		}
+		if _, ok := clasesCaracteres[clase]; !ok {
+			log.Printf("Advertencia: clase de caracteres desconocida en PASSWORD_REQUIRED_CLASSES: %q", clase)
+			continue
+		}
+		policy.RequiredClasses = append(policy.RequiredClasses, clase)
+	}
+	return policy
+}
+
+// Validate comprueba la contraseña. contexto son datos del usuario (email, nombre)
+// que no deben formar parte de la contraseña.
+func (p PasswordPolicy) Validate(password string, contexto ...string) []PasswordViolation {
+	var violations []PasswordViolation
+
+	if n := utf8.RuneCountInString(password); n < p.MinLength {
+		violations = append(violations, PasswordViolation{
+			Code:    "min_length",
+			Message: fmt.Sprintf("debe tener al menos %d caracteres", p.MinLength),
+		})
+	}
+	if len(password) > p.MaxLength {
+		violations = append(violations, PasswordViolation{
+			Code:    "max_length",
+			Message: fmt.Sprintf("no puede superar los %d bytes", p.MaxLength),
+		})
+	}
+
+	presentes := passwordClasses(password)
+	for _, clase := range p.RequiredClasses {
+		if !presentes[clase] {
+			violations = append(violations, PasswordViolation{
+				Code:    "missing_" + clase,
+				Message: "debe contener " + clasesCaracteres[clase],
+			})
+		}
+	}
+
+	normalizada := strings.ToLower(password)
+	for _, dato := range contexto {
+		dato = strings.ToLower(strings.TrimSpace(dato))
+		if local, _, ok := strings.Cut(dato, "@"); ok {
+			dato = local
+		}
+		if len(dato) >= 4 && strings.Contains(normalizada, dato) {
+			violations = append(violations, PasswordViolation{
+				Code:    "contains_personal_data",
+				Message: "no puede contener tu nombre ni tu email",
+			})
+			break
+		}
+	}
+
+	if p.RejectCommon && isCommonPassword(normalizada) {
+		violations = append(violations, PasswordViolation{
+			Code:    "common",
+			Message: "es una de las contraseñas más utilizadas",
+		})
+	} else if p.RejectBreached && isBreachedPassword(password) {
+		violations = append(violations, PasswordViolation{
+			Code:    "breached",
+			Message: "aparece en filtraciones de datos conocidas",
+		})
+	}
+
+	return violations
+}
+
+// passwordClasses indica qué clases de caracteres aparecen en la contraseña
+func passwordClasses(password string) map[string]bool {
+	clases := make(map[string]bool, len(clasesCaracteres))
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			clases[ClaseMinuscula] = true
+		case unicode.IsUpper(r):
+			clases[ClaseMayuscula] = true
+		case unicode.IsDigit(r):
+			clases[ClaseDigito] = true
+		case !unicode.IsSpace(r):
+			clases[ClaseSimbolo] = true
+		}
+	}
+	return clases
+}
+
+// isCommonPassword busca la contraseña (en minúsculas) en la lista incluida en el binario
+func isCommonPassword(normalizada string) bool {
+	commonPasswordsOnce.Do(func() {
+		commonPasswords = make(map[string]bool)
+		for _, line := range strings.Split(commonPasswordsData, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			commonPasswords[line] = true
+		}
+	})
+	return commonPasswords[normalizada]
+}
+
+// isBreachedPassword consulta un directorio local de rangos de hashes filtrados con el
+// formato de Have I Been Pwned: un fichero por prefijo SHA-1 de 5 caracteres
+// (p. ej. 5BAA6.txt) con líneas "SUFIJO:APARICIONES". Solo se lee el fichero del prefijo.
+func isBreachedPassword(password string) bool {
+	dir := getEnv("PASSWORD_BREACH_RANGES_DIR", "")
+	if dir == "" {
+		return false
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefijo, sufijo := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(dir, prefijo+".txt"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error al leer rango de contraseñas filtradas %s: %v", prefijo, err)
+		}
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		linea, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(linea, sufijo) {
+			return true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Error al leer rango de contraseñas filtradas %s: %v", prefijo, err)
+	}
+	return false
+}
+
+// checkPasswordPolicy valida la contraseña y, si no cumple la política, responde con
+// el detalle de las reglas incumplidas. Devuelve false si ya se ha respondido.
+func checkPasswordPolicy(c *gin.Context, field, password string, contexto ...string) bool {
+	policy := currentPasswordPolicy()
+	violations := policy.Validate(password, contexto...)
+	if len(violations) == 0 {
+		return true
+	}
+
+	SendValidationErrorResponse(c, ErrWeakPassword, gin.H{
+		"field":      field,
+		"violations": violations,
+		"policy":     policy,
+	})
+	return false
+}
+
+// getPasswordPolicy publica la política para que el frontend muestre los requisitos
+func getPasswordPolicy(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    currentPasswordPolicy(),
+	})
+}
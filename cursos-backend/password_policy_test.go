@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Rango de contraseñas filtradas con el SHA-1 de "Tr0ub4dor&3"
+	rangos := t.TempDir()
+	if err := os.WriteFile(filepath.Join(rangos, "87457.txt"), []byte("2E7A5AE6A49466A6AC578B98ADBA78C6AA6:3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		password string
+		contexto []string
+		want     []string // Códigos de las reglas incumplidas; vacío si se acepta
+	}{
+		{
+			name:     "válida con la política por defecto",
+			password: "Cursos2025Madrid",
+		},
+		{
+			name:     "demasiado corta",
+			password: "Ab1",
+			want:     []string{"min_length"},
+		},
+		{
+			name:     "la longitud mínima cuenta caracteres, no bytes",
+			env:      map[string]string{"PASSWORD_REQUIRED_CLASSES": ""},
+			password: "ñañañaña",
+		},
+		{
+			name:     "supera el límite de bcrypt",
+			password: "Aa1" + strings.Repeat("x", 70),
+			want:     []string{"max_length"},
+		},
+		{
+			name:     "faltan clases requeridas",
+			password: "solominusculas",
+			want:     []string{"missing_upper", "missing_digit"},
+		},
+		{
+			name:     "símbolo exigido por configuración",
+			env:      map[string]string{"PASSWORD_REQUIRED_CLASSES": "lower,symbol"},
+			password: "sinsimbolos",
+			want:     []string{"missing_symbol"},
+		},
+		{
+			name:     "clase desconocida se ignora",
+			env:      map[string]string{"PASSWORD_REQUIRED_CLASSES": "lower,emoji"},
+			password: "solominusculas",
+		},
+		{
+			name:     "contiene la parte local del email",
+			password: "Anagarcia2025",
+			contexto: []string{"AnaGarcia@example.com", "Ana"},
+			want:     []string{"contains_personal_data"},
+		},
+		{
+			name:     "datos personales de menos de 4 caracteres no cuentan",
+			password: "Cursos2025Ana",
+			contexto: []string{"Ana"},
+		},
+		{
+			name:     "contraseña común sin distinguir mayúsculas",
+			password: "Password1",
+			want:     []string{"common"},
+		},
+		{
+			name:     "lista común desactivada",
+			env:      map[string]string{"PASSWORD_REJECT_COMMON": "false"},
+			password: "Password1",
+		},
+		{
+			name:     "filtrada según el rango local",
+			env:      map[string]string{"PASSWORD_BREACH_RANGES_DIR": rangos, "PASSWORD_REQUIRED_CLASSES": ""},
+			password: "Tr0ub4dor&3",
+			want:     []string{"breached"},
+		},
+		{
+			name:     "no aparece en el rango local",
+			env:      map[string]string{"PASSWORD_BREACH_RANGES_DIR": rangos},
+			password: "Cursos2025Madrid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"PASSWORD_MIN_LENGTH", "PASSWORD_MAX_LENGTH", "PASSWORD_REQUIRED_CLASSES", "PASSWORD_REJECT_COMMON", "PASSWORD_BREACH_RANGES_DIR"} {
+				t.Setenv(k, "")
+				os.Unsetenv(k)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+
+			ok := checkPasswordPolicy(c, "password", tt.password, tt.contexto...)
+			if ok != (len(tt.want) == 0) {
+				t.Fatalf("checkPasswordPolicy = %v, reglas esperadas %v; respuesta %s", ok, tt.want, w.Body.String())
+			}
+			if ok {
+				if w.Body.Len() != 0 {
+					t.Errorf("no debería responder si la contraseña es válida: %s", w.Body.String())
+				}
+				return
+			}
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("código %d, quiero %d", w.Code, http.StatusBadRequest)
+			}
+			var resp struct {
+				Error   string `json:"error"`
+				Details struct {
+					Field      string              `json:"field"`
+					Violations []PasswordViolation `json:"violations"`
+				} `json:"details"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("respuesta no es JSON: %v", err)
+			}
+			if resp.Error != ErrWeakPassword.Error() || resp.Details.Field != "password" {
+				t.Errorf("error %q en el campo %q", resp.Error, resp.Details.Field)
+			}
+			var codigos []string
+			for _, v := range resp.Details.Violations {
+				codigos = append(codigos, v.Code)
+			}
+			if !reflect.DeepEqual(codigos, tt.want) {
+				t.Errorf("reglas incumplidas = %v, quiero %v", codigos, tt.want)
+			}
+		})
+	}
+}
@@ -9,11 +9,19 @@ import (
 	"gorm.io/gorm"
 )
 
-// PasswordReset modelo para almacenar los tokens de restablecimiento
+// Propósitos de los tokens enviados por email
+const (
+	PropositoPasswordReset     = "password_reset"
+	PropositoVerificacionEmail = "email_verification"
+)
+
+// PasswordReset modelo para almacenar los tokens de un solo uso enviados por email
+// (restablecimiento de contraseña, verificación de email...)
 type PasswordReset struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	Email     string    `gorm:"size:100;not null;index" json:"email"`
 	Token     string    `gorm:"size:100;not null;uniqueIndex" json:"token"`
+	Proposito string    `gorm:"size:30;not null;default:'password_reset';index" json:"proposito"`
 	CreatedAt time.Time `json:"created_at"`
 	ExpiresAt time.Time `json:"expires_at"`
 	Used      bool      `gorm:"default:false" json:"used"`
@@ -21,20 +29,25 @@ type PasswordReset struct {
 
 // GenerateResetToken genera un nuevo token de restablecimiento
 func GenerateResetToken(email string) (*PasswordReset, error) {
-	tokenStr := uuid.New().String()
+	// Eliminar tokens anteriores para el mismo email
+	if err := db.Where("email = ? AND proposito = ?", email, PropositoPasswordReset).Delete(&PasswordReset{}).Error; err != nil {
+		log.Printf("Error al eliminar tokens anteriores: %v", err)
+	}
+
+	return generateEmailToken(email, PropositoPasswordReset, 1*time.Hour)
+}
+
+// generateEmailToken crea y guarda un token de un solo uso para el propósito indicado
+func generateEmailToken(email, proposito string, ttl time.Duration) (*PasswordReset, error) {
 	reset := &PasswordReset{
 		Email:     email,
-		Token:     tokenStr,
+		Token:     uuid.New().String(),
+		Proposito: proposito,
 		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(1 * time.Hour),
+		ExpiresAt: time.Now().Add(ttl),
 		Used:      false,
 	}
 
-	// Eliminar tokens anteriores para el mismo email
-	if err := db.Where("email = ?", email).Delete(&PasswordReset{}).Error; err != nil {
-		log.Printf("Error al eliminar tokens anteriores: %v", err)
-	}
-
 	// Guardar el nuevo token
 	if result := db.Create(&reset); result.Error != nil {
 		log.Printf("Error al crear token: %v", result.Error)
@@ -46,8 +59,14 @@ func GenerateResetToken(email string) (*PasswordReset, error) {
 
 // ValidateResetToken verifica si un token es válido
 func ValidateResetToken(token string) (*PasswordReset, error) {
+	return validateEmailToken(token, PropositoPasswordReset)
+}
+
+// validateEmailToken verifica que un token exista, no se haya usado, no haya expirado
+// y corresponda al propósito indicado
+func validateEmailToken(token, proposito string) (*PasswordReset, error) {
 	var reset PasswordReset
-	result := db.Where("token = ? AND used = ? AND expires_at > ?", token, false, time.Now()).First(&reset)
+	result := db.Where("token = ? AND proposito = ? AND used = ? AND expires_at > ?", token, proposito, false, time.Now()).First(&reset)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return nil, ErrInvalidToken
@@ -60,7 +79,7 @@ func ValidateResetToken(token string) (*PasswordReset, error) {
 
 // MarkTokenAsUsed marca un token como utilizado
 func MarkTokenAsUsed(token string) error {
-	result := db.Model(&PasswordReset{}).Where("token = ?", token).Update("used", true)
+	result := db.Model(&PasswordReset{}).Where("token = ? AND used = ?", token, false).Update("used", true)
 	if result.Error != nil {
 		log.Printf("Error al marcar token como usado: %v", result.Error)
 		return result.Error
@@ -1,13 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"errors"
 	"log"
 	"net/http"
-	"net/smtp"
-	"os"
-	"text/template"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
@@ -21,7 +17,7 @@ type ForgotPasswordRequest struct {
 // ResetPasswordRequest estructura para restablecer contraseña
 type ResetPasswordRequest struct {
 	Token    string `json:"token" binding:"required"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"`
 }
 
 // Controlador para solicitar restablecimiento de contraseña
@@ -32,6 +28,10 @@ func forgotPassword(c *gin.Context) {
 		return
 	}
 
+	if !checkRateLimit(c, ruleForgotEmail, req.Email) {
+		return
+	}
+
 	// Verificar si el email existe
 	var user Usuario
 	result := db.Where("email = ?", req.Email).First(&user)
@@ -59,7 +59,7 @@ func forgotPassword(c *gin.Context) {
 	appEnv := getEnv("APP_ENV", "development")
 
 	// Intentar enviar email en todos los entornos, pero manejar fallos de forma diferente
-	emailError := sendPasswordResetEmail(user.Email, user.Nombre, resetLink)
+	emailError := sendPasswordResetEmail(user, resetLink)
 	if emailError != nil {
 		log.Printf("Error al enviar correo: %v", emailError)
 		// En producción, esto podría ser un problema crítico
@@ -131,6 +131,10 @@ func resetPassword(c *gin.Context) {
 		return
 	}
 
+	if !checkPasswordPolicy(c, "password", req.Password, user.Email, user.Nombre) {
+		return
+	}
+
 	// Hash de la nueva contraseña
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -153,84 +157,26 @@ func resetPassword(c *gin.Context) {
 		return
 	}
 
+	// Cerrar todas las sesiones abiertas: quien tuviera la contraseña anterior pierde el acceso
+	if err := revokeAllSessions(user.ID, "password_reset", ""); err != nil {
+		log.Printf("Error al revocar sesiones tras restablecer contraseña: %v", err)
+	}
+	logActivity(c, user.ID, "password_reset", "Contraseña restablecida; sesiones cerradas")
+
 	// Devolver un JSON válido usando exactamente el mismo formato original
 	c.JSON(http.StatusOK, gin.H{"message": "Contraseña actualizada correctamente"})
 }
 
 // Función para enviar email de recuperación con manejo mejorado de errores
-func sendPasswordResetEmail(to, name, resetLink string) error {
-	from := getEnv("EMAIL_FROM", "noreply@example.com")
-	password := getEnv("EMAIL_PASSWORD", "")
-	smtpHost := getEnv("SMTP_HOST", "smtp.gmail.com")
-	smtpPort := getEnv("SMTP_PORT", "587")
-
-	// Comprobación de configuración mínima
-	if password == "" {
-		return errors.New("la contraseña de email no está configurada")
-	}
-
-	// Configurar autenticación
-	auth := smtp.PlainAuth("", from, password, smtpHost)
-
-	// Renderizar el HTML del correo electrónico
-	htmlContent, err := renderEmailTemplate(name, resetLink)
-	if err != nil {
-		log.Printf("Error al renderizar la plantilla de correo: %v", err)
-		return err
-	}
-
-	// Construir el mensaje
-	subject := "Recuperación de contraseña"
-	headers := "MIME-version: 1.0;\r\nContent-Type: text/html; charset=\"UTF-8\";\r\n"
-	message := []byte("To: " + to + "\r\n" +
-		"From: " + from + "\r\n" +
-		"Subject: " + subject + "\r\n" +
-		headers + "\r\n" +
-		htmlContent)
-
-	// En desarrollo, podemos simular el envío
-	if getEnv("APP_ENV", "development") == "development" && getEnv("MOCK_EMAIL", "true") == "true" {
-		log.Printf("Simulando envío de email a %s. Contenido: %s", to, htmlContent)
-		// Guardar una copia del correo en un archivo para probar
-		f, err := os.OpenFile("last_reset_email.html", os.O_CREATE|os.O_WRONLY, 0644)
-		if err == nil {
-			defer f.Close()
-			f.WriteString(htmlContent)
-		}
-		return nil
-	}
-
-	// Enviar email con timeout adicional
-	err = smtp.SendMail(smtpHost+":"+smtpPort, auth, from, []string{to}, message)
-	if err != nil {
-		log.Printf("Error SMTP: %v", err)
-		return err
-	}
-
-	return nil
-}
-
-// Función para renderizar el HTML del correo electrónico
-func renderEmailTemplate(name, resetLink string) (string, error) {
-	// Cargar la plantilla desde el archivo
-	tmpl, err := template.ParseFiles("templates/email_template.html")
-	if err != nil {
-		return "", err
-	}
-
-	// Ejecutar la plantilla con los datos
-	var htmlContent bytes.Buffer
-	data := struct {
-		Name      string
-		ResetLink string
-	}{
-		Name:      name,
+func sendPasswordResetEmail(user Usuario, resetLink string) error {
+	return sendTemplateEmail(PlantillaRecuperacion, userEmailLocale(user), EmailMessage{To: []string{user.Email}}, passwordResetEmailData{
+		Name:      user.Nombre,
 		ResetLink: resetLink,
-	}
-
-	if err := tmpl.Execute(&htmlContent, data); err != nil {
-		return "", err
-	}
+	})
+}
 
-	return htmlContent.String(), nil
-}
\ No newline at end of file
+// passwordResetEmailData son los datos de la plantilla de recuperación de contraseña
+type passwordResetEmailData struct {
+	Name      string
+	ResetLink string
+}
@@ -0,0 +1,612 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Permisos disponibles en el sistema
+const (
+	PermAdminPanel       = "admin:panel"
+	PermStatsRead        = "stats:read"
+	PermActivityRead     = "activity:read"
+	PermCoursesWrite     = "courses:write"
+	PermPortfolioWrite   = "portfolio:write"
+	PermHomeImagesWrite  = "home_images:write"
+	PermPaymentsRead     = "payments:read"
+	PermPaymentsRefund   = "payments:refund"
+	PermMessagesRead     = "messages:read"
+	PermMessagesReply    = "messages:reply"
+	PermMessagesManage   = "messages:manage"
+	PermUsersRead        = "users:read"
+	PermUsersManage      = "users:manage"
+	PermUsersImpersonate = "users:impersonate"
+	PermRolesManage      = "roles:manage"
+	PermEmailsManage     = "emails:manage"
+	PermReviewsModerate  = "reviews:moderate"
+)
+
+// Nombres de los roles predefinidos
+const (
+	RolAdmin   = "admin"
+	RolUser    = "user"
+	RolSupport = "support"
+	RolEditor  = "editor"
+)
+
+// permisosDisponibles describe cada permiso para el panel de administración
+var permisosDisponibles = map[string]string{
+	PermAdminPanel:       "Acceso al panel de administración",
+	PermStatsRead:        "Ver estadísticas y dashboard",
+	PermActivityRead:     "Ver el registro de actividad",
+	PermCoursesWrite:     "Crear y editar cursos, capítulos y videos",
+	PermPortfolioWrite:   "Gestionar proyectos del portfolio",
+	PermHomeImagesWrite:  "Gestionar imágenes de la página de inicio",
+	PermPaymentsRead:     "Ver pagos",
+	PermPaymentsRefund:   "Reembolsar pagos",
+	PermMessagesRead:     "Ver mensajes de contacto",
+	PermMessagesReply:    "Responder mensajes de contacto",
+	PermMessagesManage:   "Marcar y eliminar mensajes de contacto",
+	PermUsersRead:        "Ver usuarios",
+	PermUsersManage:      "Editar, eliminar y cambiar el rol de usuarios",
+	PermUsersImpersonate: "Iniciar sesión como otro usuario para dar soporte",
+	PermRolesManage:      "Gestionar roles y permisos",
+	PermEmailsManage:     "Gestionar correos salientes y plantillas de email",
+	PermReviewsModerate:  "Moderar las valoraciones de los cursos",
+}
+
+// Rol agrupa un conjunto de permisos asignables a usuarios
+type Rol struct {
+	ID          uint         `gorm:"primaryKey" json:"id"`
+	Nombre      string       `gorm:"size:20;not null;uniqueIndex" json:"nombre"`
+	Descripcion string       `gorm:"size:255" json:"descripcion"`
+	Sistema     bool         `gorm:"default:false" json:"sistema"`                          // Los roles de sistema no se pueden eliminar
+	Requiere2FA bool         `gorm:"column:requiere_2fa;default:false" json:"requiere_2fa"` // Política: exigir segundo factor a este rol
+	Permisos    []RolPermiso `gorm:"foreignKey:RolID;constraint:OnDelete:CASCADE" json:"permisos"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (Rol) TableName() string {
+	return "roles"
+}
+
+// RolPermiso es una fila de la tabla rol→permiso
+type RolPermiso struct {
+	ID      uint   `gorm:"primaryKey" json:"-"`
+	RolID   uint   `gorm:"not null;uniqueIndex:idx_rol_permiso" json:"-"`
+	Permiso string `gorm:"size:50;not null;uniqueIndex:idx_rol_permiso" json:"permiso"`
+}
+
+// RolRequest estructura para crear o actualizar un rol
+type RolRequest struct {
+	Nombre      string   `json:"nombre"`
+	Descripcion string   `json:"descripcion"`
+	Permisos    []string `json:"permisos"`
+	Requiere2FA *bool    `json:"requiere_2fa"`
+}
+
+// rolesPredefinidos se crean al arrancar si no existen
+var rolesPredefinidos = []struct {
+	Nombre      string
+	Descripcion string
+	Permisos    []string
+}{
+	{RolAdmin, "Administrador con acceso completo", nil}, // nil = todos los permisos
+	{RolUser, "Estudiante", []string{}},
+	{RolSupport, "Soporte: atiende mensajes y consulta usuarios y pagos", []string{
+		PermAdminPanel, PermMessagesRead, PermMessagesReply, PermMessagesManage, PermUsersRead, PermPaymentsRead,
+		PermReviewsModerate,
+	}},
+	{RolEditor, "Editor de contenido: cursos, portfolio e imágenes de inicio", []string{
+		PermAdminPanel, PermCoursesWrite, PermPortfolioWrite, PermHomeImagesWrite,
+	}},
+}
+
+// rolCache es la información de un rol que se consulta en cada petición
+type rolCache struct {
+	permisos    map[string]bool
+	requiere2FA bool
+}
+
+// Caché en memoria de rol → permisos para no consultar la BD en cada petición
+var (
+	permisosCache   map[string]rolCache
+	permisosCacheMu sync.RWMutex
+)
+
+// seedRoles crea los roles predefinidos que falten
+func seedRoles() error {
+	for _, def := range rolesPredefinidos {
+		var count int64
+		if err := db.Model(&Rol{}).Where("nombre = ?", def.Nombre).Count(&count).Error; err != nil {
+			return fmt.Errorf("error al comprobar rol %s: %v", def.Nombre, err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		permisos := def.Permisos
+		if permisos == nil {
+			permisos = todosLosPermisos()
+		}
+
+		rol := Rol{Nombre: def.Nombre, Descripcion: def.Descripcion, Sistema: true}
+		for _, p := range permisos {
+			rol.Permisos = append(rol.Permisos, RolPermiso{Permiso: p})
+		}
+		if err := db.Create(&rol).Error; err != nil {
+			return fmt.Errorf("error al crear rol %s: %v", def.Nombre, err)
+		}
+		log.Printf("Rol predefinido creado: %s", def.Nombre)
+	}
+
+	// El rol admin siempre conserva todos los permisos, incluidos los añadidos en nuevas versiones
+	var admin Rol
+	if err := db.Preload("Permisos").Where("nombre = ?", RolAdmin).First(&admin).Error; err == nil {
+		existentes := map[string]bool{}
+		for _, p := range admin.Permisos {
+			existentes[p.Permiso] = true
+		}
+		for _, p := range todosLosPermisos() {
+			if !existentes[p] {
+				db.Create(&RolPermiso{RolID: admin.ID, Permiso: p})
+			}
+		}
+	}
+
+	invalidatePermissionCache()
+	return nil
+}
+
+func todosLosPermisos() []string {
+	permisos := make([]string, 0, len(permisosDisponibles))
+	for p := range permisosDisponibles {
+		permisos = append(permisos, p)
+	}
+	sort.Strings(permisos)
+	return permisos
+}
+
+func invalidatePermissionCache() {
+	permisosCacheMu.Lock()
+	permisosCache = nil
+	permisosCacheMu.Unlock()
+}
+
+func loadPermissionCache() (map[string]rolCache, error) {
+	permisosCacheMu.RLock()
+	cache := permisosCache
+	permisosCacheMu.RUnlock()
+	if cache != nil {
+		return cache, nil
+	}
+
+	var roles []Rol
+	if err := db.Preload("Permisos").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+
+	cache = make(map[string]rolCache, len(roles))
+	for _, rol := range roles {
+		set := make(map[string]bool, len(rol.Permisos))
+		for _, p := range rol.Permisos {
+			set[p.Permiso] = true
+		}
+		cache[rol.Nombre] = rolCache{permisos: set, requiere2FA: rol.Requiere2FA}
+	}
+
+	permisosCacheMu.Lock()
+	permisosCache = cache
+	permisosCacheMu.Unlock()
+	return cache, nil
+}
+
+// hasPermission indica si un rol incluye el permiso solicitado
+func hasPermission(role, permiso string) bool {
+	cache, err := loadPermissionCache()
+	if err != nil {
+		log.Printf("Error al cargar permisos de roles: %v", err)
+		return false
+	}
+	return cache[role].permisos[permiso]
+}
+
+// roleRequires2FA indica si la política del rol exige segundo factor
+func roleRequires2FA(role string) bool {
+	cache, err := loadPermissionCache()
+	if err != nil {
+		log.Printf("Error al cargar permisos de roles: %v", err)
+		return false
+	}
+	return cache[role].requiere2FA
+}
+
+// permissionsForRole devuelve la lista ordenada de permisos de un rol
+func permissionsForRole(role string) []string {
+	cache, err := loadPermissionCache()
+	if err != nil {
+		log.Printf("Error al cargar permisos de roles: %v", err)
+		return []string{}
+	}
+	permisos := make([]string, 0, len(cache[role].permisos))
+	for p := range cache[role].permisos {
+		permisos = append(permisos, p)
+	}
+	sort.Strings(permisos)
+	return permisos
+}
+
+// rolesWithPermission devuelve los roles que incluyen el permiso indicado
+func rolesWithPermission(permiso string) []string {
+	cache, err := loadPermissionCache()
+	if err != nil {
+		log.Printf("Error al cargar permisos de roles: %v", err)
+		return []string{}
+	}
+	roles := make([]string, 0, len(cache))
+	for nombre, rol := range cache {
+		if rol.permisos[permiso] {
+			roles = append(roles, nombre)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// roleExists comprueba que un nombre de rol esté definido
+func roleExists(role string) bool {
+	cache, err := loadPermissionCache()
+	if err != nil {
+		log.Printf("Error al cargar permisos de roles: %v", err)
+		return false
+	}
+	_, ok := cache[role]
+	return ok
+}
+
+// permisosPrivilegiados permiten hacerse con cuentas ajenas: suplantar usuarios, leer los
+// correos salientes o editar roles. Solo los concede un rol con todos los permisos.
+var permisosPrivilegiados = map[string]bool{
+	PermUsersImpersonate: true,
+	PermEmailsManage:     true,
+	PermRolesManage:      true,
+}
+
+// canGrantPermission indica si quien tiene el rol granter puede conceder el permiso
+func canGrantPermission(granter, permiso string) bool {
+	if !hasPermission(granter, permiso) {
+		return false
+	}
+	if permisosPrivilegiados[permiso] {
+		for _, p := range todosLosPermisos() {
+			if !hasPermission(granter, p) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// canGrantRole indica si quien tiene el rol granter puede asignar el rol indicado:
+// nadie puede conceder permisos que no tiene, ni los privilegiados sin tenerlos todos
+func canGrantRole(granter, role string) bool {
+	for _, p := range permissionsForRole(role) {
+		if !canGrantPermission(granter, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkCanManageUser comprueba que el usuario autenticado pueda actuar sobre la cuenta
+// target: debe poder asignar su rol actual, para que quien gestiona usuarios no pueda
+// modificar, degradar ni eliminar cuentas con más permisos que los suyos. Si no puede,
+// responde 403 y devuelve false.
+func checkCanManageUser(c *gin.Context, target Usuario) bool {
+	userValue, _ := c.Get("user")
+	actor := userValue.(Usuario)
+	if !canGrantRole(actor.Role, target.Role) {
+		SendErrorResponse(c, errors.New("no puedes gestionar a un usuario con permisos que tú no tienes"), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// requirePermission verifica que el usuario autenticado tenga el permiso indicado
+func requirePermission(permiso string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Obtener el usuario del contexto (establecido por authMiddleware)
+		userValue, exists := c.Get("user")
+		if !exists {
+			SendErrorResponse(c, ErrUnauthorized, http.StatusUnauthorized)
+			return
+		}
+
+		user, ok := userValue.(Usuario)
+		if !ok {
+			SendErrorResponse(c, errors.New("error al obtener información del usuario"), http.StatusInternalServerError)
+			return
+		}
+
+		if !hasPermission(user.Role, permiso) {
+			// Registrar intento de acceso no autorizado para auditoría de seguridad
+			log.Printf("Acceso denegado. Usuario ID: %d, Email: %s, Rol: %s, Permiso requerido: %s",
+				user.ID, user.Email, user.Role, permiso)
+
+			SendErrorResponse(c, fmt.Errorf("acceso denegado: se requiere el permiso %s", permiso), http.StatusForbidden)
+			return
+		}
+
+		// Con una clave API el permiso debe estar además entre los alcances de la clave
+		if key, ok := currentAPIKey(c); ok {
+			if !key.HasScope(permiso) {
+				SendErrorResponse(c, fmt.Errorf("la clave API no tiene el alcance %s", permiso), http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		// La política del rol puede exigir autenticación en dos pasos para usar sus permisos
+		if roleRequires2FA(user.Role) && !user.TwoFactorEnabled {
+			SendErrorResponse(c, ErrTwoFactorSetupRequired, http.StatusForbidden)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// validarPermisos comprueba que todos los permisos existan y elimina duplicados
+func validarPermisos(permisos []string) ([]string, error) {
+	vistos := map[string]bool{}
+	resultado := make([]string, 0, len(permisos))
+	for _, p := range permisos {
+		p = strings.TrimSpace(p)
+		if _, ok := permisosDisponibles[p]; !ok {
+			return nil, fmt.Errorf("permiso no válido: %s", p)
+		}
+		if !vistos[p] {
+			vistos[p] = true
+			resultado = append(resultado, p)
+		}
+	}
+	return resultado, nil
+}
+
+// listPermissions devuelve el catálogo de permisos disponibles
+func listPermissions(c *gin.Context) {
+	type permisoInfo struct {
+		Permiso     string `json:"permiso"`
+		Descripcion string `json:"descripcion"`
+	}
+
+	permisos := make([]permisoInfo, 0, len(permisosDisponibles))
+	for _, p := range todosLosPermisos() {
+		permisos = append(permisos, permisoInfo{Permiso: p, Descripcion: permisosDisponibles[p]})
+	}
+
+	SendSuccessResponse(c, permisos)
+}
+
+// listRoles devuelve todos los roles con sus permisos
+func listRoles(c *gin.Context) {
+	var roles []Rol
+	if err := db.Preload("Permisos").Order("id asc").Find(&roles).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	SendSuccessResponse(c, roles)
+}
+
+// ungrantablePermissions devuelve los permisos de la lista que quien tiene el rol granter
+// no puede conceder
+func ungrantablePermissions(granter string, permisos []string) []string {
+	var denegados []string
+	for _, p := range permisos {
+		if !canGrantPermission(granter, p) {
+			denegados = append(denegados, p)
+		}
+	}
+	return denegados
+}
+
+// createRole crea un nuevo rol con su lista de permisos
+func createRole(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	adminUser := userValue.(Usuario)
+
+	var req RolRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	req.Nombre = strings.ToLower(strings.TrimSpace(req.Nombre))
+	if req.Nombre == "" || len(req.Nombre) > 20 {
+		SendErrorResponse(c, errors.New("el nombre del rol es obligatorio (máx. 20 caracteres)"), http.StatusBadRequest)
+		return
+	}
+
+	if roleExists(req.Nombre) {
+		SendErrorResponse(c, errors.New("ya existe un rol con ese nombre"), http.StatusBadRequest)
+		return
+	}
+
+	permisos, err := validarPermisos(req.Permisos)
+	if err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+	if denegados := ungrantablePermissions(adminUser.Role, permisos); len(denegados) > 0 {
+		SendErrorResponse(c, fmt.Errorf("no puedes conceder permisos que no tienes: %s", strings.Join(denegados, ", ")), http.StatusForbidden)
+		return
+	}
+
+	rol := Rol{Nombre: req.Nombre, Descripcion: req.Descripcion}
+	if req.Requiere2FA != nil {
+		rol.Requiere2FA = *req.Requiere2FA
+	}
+	for _, p := range permisos {
+		rol.Permisos = append(rol.Permisos, RolPermiso{Permiso: p})
+	}
+
+	if err := db.Create(&rol).Error; err != nil {
+		log.Printf("Error al crear rol: %v", err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	invalidatePermissionCache()
+
+	logActivity(c, adminUser.ID, "create_role",
+		fmt.Sprintf("Admin creó rol '%s' con permisos: %s", rol.Nombre, strings.Join(permisos, ", ")))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Rol creado correctamente",
+		"data":    rol,
+	})
+}
+
+// updateRole reemplaza la descripción y los permisos de un rol
+func updateRole(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	adminUser := userValue.(Usuario)
+	id := c.Param("id")
+
+	var rol Rol
+	if err := db.Preload("Permisos").First(&rol, id).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	var req RolRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	// El rol admin conserva siempre todos los permisos para evitar quedarse sin acceso
+	if rol.Nombre == RolAdmin && req.Permisos != nil {
+		SendErrorResponse(c, errors.New("no se pueden modificar los permisos del rol admin"), http.StatusBadRequest)
+		return
+	}
+
+	// Tampoco se puede editar un rol con permisos que uno no podría conceder
+	if !canGrantRole(adminUser.Role, rol.Nombre) {
+		SendErrorResponse(c, errors.New("no puedes modificar un rol con permisos que no tienes"), http.StatusForbidden)
+		return
+	}
+
+	var permisos []string
+	if req.Permisos != nil {
+		var err error
+		if permisos, err = validarPermisos(req.Permisos); err != nil {
+			SendErrorResponse(c, err, http.StatusBadRequest)
+			return
+		}
+		if denegados := ungrantablePermissions(adminUser.Role, permisos); len(denegados) > 0 {
+			SendErrorResponse(c, fmt.Errorf("no puedes conceder permisos que no tienes: %s", strings.Join(denegados, ", ")), http.StatusForbidden)
+			return
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if req.Descripcion != "" {
+			if err := tx.Model(&rol).Update("descripcion", req.Descripcion).Error; err != nil {
+				return err
+			}
+		}
+		if req.Requiere2FA != nil {
+			if err := tx.Model(&rol).Update("requiere_2fa", *req.Requiere2FA).Error; err != nil {
+				return err
+			}
+		}
+		if req.Permisos == nil {
+			return nil
+		}
+		if err := tx.Where("rol_id = ?", rol.ID).Delete(&RolPermiso{}).Error; err != nil {
+			return err
+		}
+		for _, p := range permisos {
+			if err := tx.Create(&RolPermiso{RolID: rol.ID, Permiso: p}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error al actualizar rol %s: %v", id, err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	invalidatePermissionCache()
+
+	if err := db.Preload("Permisos").First(&rol, rol.ID).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, adminUser.ID, "update_role",
+		fmt.Sprintf("Admin actualizó rol '%s', permisos: %s", rol.Nombre, strings.Join(permissionsForRole(rol.Nombre), ", ")))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Rol actualizado correctamente",
+		"data":    rol,
+	})
+}
+
+// deleteRole elimina un rol que no sea de sistema ni esté asignado
+func deleteRole(c *gin.Context) {
+	id := c.Param("id")
+
+	var rol Rol
+	if err := db.First(&rol, id).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	if rol.Sistema {
+		SendErrorResponse(c, errors.New("no se puede eliminar un rol de sistema"), http.StatusBadRequest)
+		return
+	}
+
+	var asignados int64
+	if err := db.Model(&Usuario{}).Where("role = ?", rol.Nombre).Count(&asignados).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	if asignados > 0 {
+		SendErrorResponse(c, fmt.Errorf("el rol está asignado a %d usuario(s)", asignados), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.Select("Permisos").Delete(&rol).Error; err != nil {
+		log.Printf("Error al eliminar rol %s: %v", id, err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	invalidatePermissionCache()
+
+	userValue, _ := c.Get("user")
+	adminUser := userValue.(Usuario)
+	logActivity(c, adminUser.ID, "delete_role", fmt.Sprintf("Admin eliminó rol '%s'", rol.Nombre))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Rol eliminado correctamente",
+	})
+}
@@ -158,14 +158,8 @@ func getProjectsByCategory(c *gin.Context) {
 
 // createProject crea un nuevo proyecto en el portfolio
 func createProject(c *gin.Context) {
-	// Solo administradores pueden crear proyectos
 	userValue, _ := c.Get("user")
 	user := userValue.(Usuario)
-	
-	if user.Role != "admin" {
-		SendErrorResponse(c, ErrUnauthorized, http.StatusForbidden)
-		return
-	}
 
 	// Obtener datos del formulario
 	title := c.PostForm("title")
@@ -243,14 +237,8 @@ func createProject(c *gin.Context) {
 
 // updateProject actualiza un proyecto existente
 func updateProject(c *gin.Context) {
-	// Solo administradores pueden actualizar proyectos
 	userValue, _ := c.Get("user")
 	user := userValue.(Usuario)
-	
-	if user.Role != "admin" {
-		SendErrorResponse(c, ErrUnauthorized, http.StatusForbidden)
-		return
-	}
 
 	id := c.Param("id")
 	var project ProjectPortfolio
@@ -340,14 +328,8 @@ func updateProject(c *gin.Context) {
 
 // deleteProject elimina un proyecto del portfolio
 func deleteProject(c *gin.Context) {
-	// Solo administradores pueden eliminar proyectos
 	userValue, _ := c.Get("user")
 	user := userValue.(Usuario)
-	
-	if user.Role != "admin" {
-		SendErrorResponse(c, ErrUnauthorized, http.StatusForbidden)
-		return
-	}
 
 	id := c.Param("id")
 	var project ProjectPortfolio
@@ -396,14 +378,8 @@ func deleteProject(c *gin.Context) {
 
 // reorderProjects actualiza el orden de los proyectos
 func reorderProjects(c *gin.Context) {
-	// Solo administradores pueden reordenar proyectos
 	userValue, _ := c.Get("user")
 	user := userValue.(Usuario)
-	
-	if user.Role != "admin" {
-		SendErrorResponse(c, ErrUnauthorized, http.StatusForbidden)
-		return
-	}
 
 	var requestData struct {
 		ProjectIds []uint `json:"projectIds" binding:"required"`
@@ -452,15 +428,9 @@ func reorderProjects(c *gin.Context) {
 
 // getPortfolioStats obtiene estadísticas del portfolio para el panel de administración
 func getPortfolioStats(c *gin.Context) {
-	// Solo administradores pueden ver estadísticas
 	userValue, _ := c.Get("user")
 	user := userValue.(Usuario)
 	
-	if user.Role != "admin" {
-		SendErrorResponse(c, ErrUnauthorized, http.StatusForbidden)
-		return
-	}
-	
 	// Registrar actividad
 	logActivity(c, user.ID, "view_portfolio_stats", "Visualización de estadísticas del portfolio")
 	
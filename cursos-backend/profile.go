@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -17,11 +18,12 @@ type UpdateProfileRequest struct {
 	Nombre string `json:"nombre"`
 	Email  string `json:"email"`
 	Phone  string `json:"phone"`
+	Idioma string `json:"idioma" binding:"omitempty,max=10"`
 }
 
 type ChangePasswordRequest struct {
 	CurrentPassword string `json:"currentPassword" binding:"required"`
-	NewPassword     string `json:"newPassword" binding:"required,min=8"`
+	NewPassword     string `json:"newPassword" binding:"required"`
 }
 
 type NotificationSettingsRequest struct {
@@ -30,6 +32,9 @@ type NotificationSettingsRequest struct {
 	NewStudents        bool `json:"newStudents"`
 	SalesReports       bool `json:"salesReports"`
 	SystemUpdates      bool `json:"systemUpdates"`
+	// Opcionales: si no se envían se conserva el valor actual
+	InAppNotifications *bool  `json:"inAppNotifications"`
+	DigestFrequency    string `json:"digestFrequency" binding:"omitempty,oneof=inmediato diario semanal"`
 }
 
 // Obtener perfil del usuario
@@ -84,14 +89,24 @@ func updateProfile(c *gin.Context) {
 		updates["nombre"] = req.Nombre
 	}
 	
+	emailCambiado := req.Email != "" && req.Email != currentUser.Email
 	if req.Email != "" {
 		updates["email"] = req.Email
 	}
+	if emailCambiado {
+		// La nueva dirección debe verificarse de nuevo
+		updates["email_verificado"] = false
+		updates["email_verificado_en"] = nil
+	}
 	
 	if req.Phone != "" {
 		updates["phone"] = req.Phone
 	}
 
+	if req.Idioma != "" {
+		updates["idioma"] = req.Idioma
+	}
+
 	// Actualizar usuario en la BD
 	result := db.Model(&currentUser).Updates(updates)
 	if result.Error != nil {
@@ -106,6 +121,13 @@ func updateProfile(c *gin.Context) {
 		return
 	}
 
+	if emailCambiado {
+		if _, err := sendVerificationEmail(updatedUser); err != nil {
+			log.Printf("Error al enviar email de verificación a %s: %v", updatedUser.Email, err)
+		}
+		logActivity(c, updatedUser.ID, "email_change", fmt.Sprintf("Email cambiado de %s a %s", currentUser.Email, updatedUser.Email))
+	}
+
 	// No enviar contraseña en la respuesta
 	updatedUser.Password = ""
 
@@ -134,6 +156,10 @@ func changePassword(c *gin.Context) {
 		return
 	}
 
+	if !checkPasswordPolicy(c, "newPassword", req.NewPassword, currentUser.Email, currentUser.Nombre) {
+		return
+	}
+
 	// Hash de la nueva contraseña
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
 	if err != nil {
@@ -148,9 +174,25 @@ func changePassword(c *gin.Context) {
 		return
 	}
 
+	// Invalidar todas las sesiones y abrir una nueva para el dispositivo actual
+	if err := revokeAllSessions(currentUser.ID, "password_change", ""); err != nil {
+		log.Printf("Error al revocar sesiones tras cambio de contraseña: %v", err)
+	}
+	logActivity(c, currentUser.ID, "password_change", "Contraseña cambiada; sesiones cerradas")
+
+	pair, err := createSession(c, currentUser)
+	if err != nil {
+		log.Printf("Error al crear sesión tras cambio de contraseña: %v", err)
+		SendErrorResponse(c, errors.New("contraseña actualizada, pero no se pudo iniciar una nueva sesión"), http.StatusInternalServerError)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Contraseña actualizada correctamente",
+		"success":       true,
+		"message":       "Contraseña actualizada correctamente",
+		"token":         pair.AccessToken,
+		"refresh_token": pair.RefreshToken,
+		"expires_in":    pair.ExpiresIn,
 	})
 }
 
@@ -193,7 +235,7 @@ func uploadProfileImage(c *gin.Context) {
 	}
 
 	// Nombre de archivo: user_ID.extension
-	filename := "user_" + string(currentUser.ID) + extension
+	filename := fmt.Sprintf("user_%d%s", currentUser.ID, extension)
 	filepath := filepath.Join(uploadDir, filename)
 
 	// Guardar archivo
@@ -221,20 +263,8 @@ func getNotificationSettings(c *gin.Context) {
 	// Obtener usuario actual
 	userValue, _ := c.Get("user")
 	currentUser := userValue.(Usuario)
-	
-	// Registrar la acción (para evitar el error "declared and not used")
-	log.Printf("Usuario %s (ID: %d) consultó sus preferencias de notificación", currentUser.Email, currentUser.ID)
-
-	// En una implementación real, obtendríamos esto de la base de datos
-	// Por ahora, devolvemos valores predeterminados
-
-	settings := NotificationSettingsRequest{
-		EmailNotifications: true,
-		NewMessages:        true,
-		NewStudents:        true,
-		SalesReports:       true,
-		SystemUpdates:      false,
-	}
+
+	settings := loadNotificationPreferences(currentUser.ID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success":  true,
@@ -253,15 +283,33 @@ func updateNotificationSettings(c *gin.Context) {
 	// Obtener usuario actual
 	userValue, _ := c.Get("user")
 	currentUser := userValue.(Usuario)
-	
-	// Registrar la acción (para evitar el error "declared and not used")
-	log.Printf("Usuario %s (ID: %d) actualizó sus preferencias de notificación", currentUser.Email, currentUser.ID)
 
-	// En una implementación real, guardaríamos esto en la base de datos
-	// usando el ID del usuario: currentUser.ID
+	settings := loadNotificationPreferences(currentUser.ID)
+	settings.EmailNotifications = req.EmailNotifications
+	settings.NewMessages = req.NewMessages
+	settings.NewStudents = req.NewStudents
+	settings.SalesReports = req.SalesReports
+	settings.SystemUpdates = req.SystemUpdates
+	if req.InAppNotifications != nil {
+		settings.InAppNotifications = *req.InAppNotifications
+	}
+	if req.DigestFrequency != "" {
+		settings.FrecuenciaResumen = req.DigestFrequency
+	}
+	// Los enlaces de baja enviados antes de este cambio dejan de valer
+	settings.VersionBaja++
+
+	if err := saveNotificationPreferences(db, &settings); err != nil {
+		log.Printf("Error al guardar preferencias de notificación del usuario %d: %v", currentUser.ID, err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, currentUser.ID, "update_notification_settings", "Preferencias de notificación actualizadas")
 
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Preferencias de notificación actualizadas correctamente",
+		"success":  true,
+		"message":  "Preferencias de notificación actualizadas correctamente",
+		"settings": settings,
 	})
-}
\ No newline at end of file
+}
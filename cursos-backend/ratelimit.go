@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RateLimitStore guarda los contadores (token buckets) del limitador.
+// Allow consume un token de la clave y, si no queda ninguno, indica cuánto esperar.
+type RateLimitStore interface {
+	Allow(key string, limit int, window time.Duration) (bool, time.Duration, error)
+}
+
+// RateLimitRule define cuántas peticiones se permiten por ventana de tiempo
+type RateLimitRule struct {
+	Nombre  string
+	Limite  int
+	Ventana time.Duration
+}
+
+// Reglas de limitación. Se pueden ajustar con variables de entorno en formato
+// "N/duración", por ejemplo RATE_LIMIT_LOGIN_IP=20/1m
+var (
+	ruleLoginIP     = RateLimitRule{"login_ip", 20, time.Minute}
+	ruleLoginEmail  = RateLimitRule{"login_email", 10, 15 * time.Minute}
+	ruleForgotIP    = RateLimitRule{"forgot_ip", 10, 15 * time.Minute}
+	ruleForgotEmail = RateLimitRule{"forgot_email", 3, time.Hour}
+	ruleContactIP   = RateLimitRule{"contact_ip", 5, 10 * time.Minute}
+	ruleContactDay  = RateLimitRule{"contact_ip_day", 20, 24 * time.Hour}
+	ruleContactMail = RateLimitRule{"contact_email", 3, time.Hour}
+	ruleDataExport  = RateLimitRule{"data_export", 3, time.Hour}
+)
+
+// rateLimiter es el almacén activo (memoria por defecto, o base de datos con RATE_LIMIT_STORE=db)
+var rateLimiter RateLimitStore = newMemoryRateLimitStore()
+
+// RateLimitBucket guarda un token bucket compartido entre instancias
+type RateLimitBucket struct {
+	Clave         string    `gorm:"primaryKey;size:191" json:"clave"`
+	Tokens        float64   `json:"tokens"`
+	ActualizadoEn time.Time `gorm:"index" json:"actualizado_en"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (RateLimitBucket) TableName() string {
+	return "rate_limit_buckets"
+}
+
+// applyRateLimitEnv sustituye el límite de una regla si la variable de entorno es válida
+func applyRateLimitEnv(rule *RateLimitRule, envKey string) {
+	value := getEnv(envKey, "")
+	if value == "" {
+		return
+	}
+
+	n, d, ok := strings.Cut(value, "/")
+	limite, errN := strconv.Atoi(n)
+	ventana, errD := time.ParseDuration(d)
+	if !ok || errN != nil || errD != nil || limite <= 0 || ventana <= 0 {
+		log.Printf("Advertencia: valor inválido para %s (%q), usando %d/%v", envKey, value, rule.Limite, rule.Ventana)
+		return
+	}
+
+	rule.Limite = limite
+	rule.Ventana = ventana
+}
+
+// initRateLimiter aplica la configuración del entorno y selecciona el almacén según RATE_LIMIT_STORE
+func initRateLimiter() {
+	applyRateLimitEnv(&ruleLoginIP, "RATE_LIMIT_LOGIN_IP")
+	applyRateLimitEnv(&ruleLoginEmail, "RATE_LIMIT_LOGIN_EMAIL")
+	applyRateLimitEnv(&ruleForgotIP, "RATE_LIMIT_FORGOT_IP")
+	applyRateLimitEnv(&ruleForgotEmail, "RATE_LIMIT_FORGOT_EMAIL")
+	applyRateLimitEnv(&ruleContactIP, "RATE_LIMIT_CONTACT_IP")
+	applyRateLimitEnv(&ruleContactDay, "RATE_LIMIT_CONTACT_IP_DAY")
+	applyRateLimitEnv(&ruleContactMail, "RATE_LIMIT_CONTACT_EMAIL")
+	applyRateLimitEnv(&ruleDataExport, "RATE_LIMIT_DATA_EXPORT")
+
+	switch store := getEnv("RATE_LIMIT_STORE", "memory"); store {
+	case "db":
+		rateLimiter = &dbRateLimitStore{}
+		go cleanupRateLimitBuckets()
+		log.Printf("Limitador de peticiones usando la base de datos")
+	case "memory":
+	default:
+		log.Printf("Advertencia: RATE_LIMIT_STORE inválido (%q), usando memoria", store)
+	}
+}
+
+// refillBucket aplica el algoritmo token bucket: recarga los tokens según el tiempo
+// transcurrido y consume uno si hay disponible
+func refillBucket(tokens float64, last, now time.Time, limit int, window time.Duration) (float64, bool, time.Duration) {
+	rate := float64(limit) / window.Seconds() // tokens por segundo
+	tokens = math.Min(float64(limit), tokens+now.Sub(last).Seconds()*rate)
+
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+
+	espera := time.Duration((1 - tokens) / rate * float64(time.Second))
+	return tokens, false, espera
+}
+
+// memoryRateLimitStore guarda los buckets en memoria (solo sirve para una instancia)
+type memoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+type memoryBucket struct {
+	tokens float64
+	last   time.Time
+	limit  int
+	window time.Duration
+}
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	s := &memoryRateLimitStore{buckets: make(map[string]*memoryBucket)}
+	go s.cleanup()
+	return s
+}
+
+func (s *memoryRateLimitStore) Allow(key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(limit), last: now, limit: limit, window: window}
+		s.buckets[key] = b
+	}
+
+	tokens, allowed, espera := refillBucket(b.tokens, b.last, now, limit, window)
+	b.tokens = tokens
+	b.last = now
+	return allowed, espera, nil
+}
+
+// cleanup elimina periódicamente los buckets que ya se han recargado por completo
+func (s *memoryRateLimitStore) cleanup() {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		s.mu.Lock()
+		for key, b := range s.buckets {
+			if now.Sub(b.last) >= b.window {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// dbRateLimitStore comparte los buckets entre instancias a través de la base de datos
+type dbRateLimitStore struct{}
+
+func (s *dbRateLimitStore) Allow(key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	var allowed bool
+	var espera time.Duration
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		// Crear el bucket lleno si aún no existe
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&RateLimitBucket{Clave: key, Tokens: float64(limit), ActualizadoEn: now}).Error; err != nil {
+			return err
+		}
+
+		var b RateLimitBucket
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("clave = ?", key).First(&b).Error; err != nil {
+			return err
+		}
+
+		var tokens float64
+		tokens, allowed, espera = refillBucket(b.Tokens, b.ActualizadoEn, now, limit, window)
+
+		return tx.Model(&b).Updates(map[string]interface{}{
+			"tokens":         tokens,
+			"actualizado_en": now,
+		}).Error
+	})
+
+	return allowed, espera, err
+}
+
+// cleanupRateLimitBuckets borra los buckets sin actividad reciente
+func cleanupRateLimitBuckets() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := db.Where("actualizado_en < ?", time.Now().Add(-24*time.Hour)).
+			Delete(&RateLimitBucket{}).Error; err != nil {
+			log.Printf("Error al limpiar buckets del limitador: %v", err)
+		}
+	}
+}
+
+// checkRateLimit consume un token de la regla para el valor dado (IP, email...).
+// Si se supera el límite responde 429 y devuelve false.
+func checkRateLimit(c *gin.Context, rule RateLimitRule, value string) bool {
+	key := rule.Nombre + ":" + strings.ToLower(strings.TrimSpace(value))
+
+	allowed, espera, err := rateLimiter.Allow(key, rule.Limite, rule.Ventana)
+	if err != nil {
+		// Si el almacén falla no bloqueamos el servicio
+		log.Printf("Error en el limitador de peticiones (%s): %v", rule.Nombre, err)
+		return true
+	}
+
+	if !allowed {
+		segundos := int(math.Ceil(espera.Seconds()))
+		log.Printf("Límite %s superado para %s desde IP %s", rule.Nombre, value, c.ClientIP())
+		c.Header("Retry-After", fmt.Sprint(segundos))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"success":     false,
+			"error":       ErrTooManyRequests.Error(),
+			"retry_after": segundos,
+		})
+		c.Abort()
+		return false
+	}
+
+	return true
+}
+
+// rateLimitByIP limita una ruta por dirección IP del cliente
+func rateLimitByIP(rule RateLimitRule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkRateLimit(c, rule, c.ClientIP()) {
+			return
+		}
+		c.Next()
+	}
+}
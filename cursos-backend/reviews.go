@@ -0,0 +1,455 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrReviewNotAllowed se devuelve cuando el usuario no tiene una compra aprobada del curso
+	ErrReviewNotAllowed = errors.New("solo los alumnos inscritos pueden valorar el curso")
+	// ErrReviewExists se devuelve al intentar valorar dos veces el mismo curso
+	ErrReviewExists = errors.New("ya has valorado este curso")
+)
+
+// Valoracion es la puntuación (1-5) y la reseña opcional de un alumno sobre un curso.
+// Cada alumno valora un curso una sola vez; al editarla se guarda la versión anterior.
+type Valoracion struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UsuarioID  uint       `gorm:"not null;uniqueIndex:idx_valoracion_usuario_curso" json:"usuario_id"`
+	CursoID    uint       `gorm:"not null;uniqueIndex:idx_valoracion_usuario_curso;index" json:"curso_id"`
+	Puntuacion int        `gorm:"not null" json:"puntuacion"`
+	Comentario string     `gorm:"type:text" json:"comentario"`
+	EditadaEn  *time.Time `json:"editada_en,omitempty"`
+
+	// Moderación: las ocultas no se muestran ni cuentan en la media; las marcadas
+	// quedan pendientes de revisión pero siguen visibles
+	Oculta           bool       `gorm:"not null;default:false;index" json:"oculta"`
+	Marcada          bool       `gorm:"not null;default:false;index" json:"marcada"`
+	MotivoModeracion string     `gorm:"size:255" json:"motivo_moderacion,omitempty"`
+	ModeradaPorID    *uint      `json:"moderada_por_id,omitempty"`
+	ModeradaEn       *time.Time `json:"moderada_en,omitempty"`
+
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Usuario   *Usuario              `gorm:"foreignKey:UsuarioID" json:"usuario,omitempty"`
+	Historial []ValoracionHistorial `gorm:"foreignKey:ValoracionID" json:"historial,omitempty"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (Valoracion) TableName() string {
+	return "valoraciones"
+}
+
+// ValoracionHistorial guarda cada versión anterior de una valoración editada
+type ValoracionHistorial struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	ValoracionID uint      `gorm:"not null;index" json:"valoracion_id"`
+	Puntuacion   int       `gorm:"not null" json:"puntuacion"`
+	Comentario   string    `gorm:"type:text" json:"comentario"`
+	CreatedAt    time.Time `json:"created_at"` // Momento en que se sustituyó
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (ValoracionHistorial) TableName() string {
+	return "valoraciones_historial"
+}
+
+// ValoracionRequest es el cuerpo para crear o editar la valoración propia
+type ValoracionRequest struct {
+	Puntuacion int    `json:"puntuacion" binding:"required,min=1,max=5"`
+	Comentario string `json:"comentario" binding:"max=5000"`
+}
+
+// ResumenValoraciones es la media y el reparto de estrellas de un curso
+type ResumenValoraciones struct {
+	Media        float64          `json:"media"`
+	Total        int64            `json:"total"`
+	Distribucion map[string]int64 `json:"distribucion"` // "1".."5" → número de valoraciones
+}
+
+func newResumenValoraciones() *ResumenValoraciones {
+	return &ResumenValoraciones{Distribucion: map[string]int64{"1": 0, "2": 0, "3": 0, "4": 0, "5": 0}}
+}
+
+// courseRatingSummaries calcula en una sola consulta el resumen de los cursos indicados.
+// Solo cuentan las valoraciones visibles.
+func courseRatingSummaries(cursoIDs []uint) (map[uint]*ResumenValoraciones, error) {
+	resumenes := make(map[uint]*ResumenValoraciones, len(cursoIDs))
+	for _, id := range cursoIDs {
+		resumenes[id] = newResumenValoraciones()
+	}
+	if len(cursoIDs) == 0 {
+		return resumenes, nil
+	}
+
+	var filas []struct {
+		CursoID    uint
+		Puntuacion int
+		Total      int64
+	}
+	if err := db.Model(&Valoracion{}).
+		Select("curso_id, puntuacion, COUNT(*) AS total").
+		Where("curso_id IN ? AND oculta = ?", cursoIDs, false).
+		Group("curso_id, puntuacion").Scan(&filas).Error; err != nil {
+		return nil, err
+	}
+
+	sumas := make(map[uint]int64)
+	for _, f := range filas {
+		r := resumenes[f.CursoID]
+		r.Distribucion[strconv.Itoa(f.Puntuacion)] += f.Total
+		r.Total += f.Total
+		sumas[f.CursoID] += int64(f.Puntuacion) * f.Total
+	}
+	for id, r := range resumenes {
+		if r.Total > 0 {
+			r.Media = roundRating(float64(sumas[id]) / float64(r.Total))
+		}
+	}
+	return resumenes, nil
+}
+
+func roundRating(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// hasApprovedEnrollment indica si el usuario tiene un pago aprobado del curso
+func hasApprovedEnrollment(userID, cursoID uint) (bool, error) {
+	var count int64
+	err := db.Model(&Pago{}).Where("usuario_id = ? AND curso_id = ? AND estado = ?", userID, cursoID, "aprobado").
+		Count(&count).Error
+	return count > 0, err
+}
+
+// getCourseReviews devuelve las valoraciones visibles del curso, las más recientes primero
+func getCourseReviews(c *gin.Context) {
+	var curso Curso
+	if err := db.Select("id").First(&curso, c.Param("id")).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 50 {
+		limit = 10
+	}
+
+	query := db.Model(&Valoracion{}).Where("curso_id = ? AND oculta = ?", curso.ID, false)
+	if p := c.Query("puntuacion"); p != "" {
+		query = query.Where("puntuacion = ?", p)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	var valoraciones []Valoracion
+	if err := query.Preload("Usuario", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "nombre", "image_url")
+	}).Order("created_at DESC, id DESC").Limit(limit).Offset((page - 1) * limit).Find(&valoraciones).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	// Los datos de moderación solo se muestran en el panel
+	for i := range valoraciones {
+		valoraciones[i].Marcada = false
+		valoraciones[i].MotivoModeracion = ""
+		valoraciones[i].ModeradaPorID = nil
+		valoraciones[i].ModeradaEn = nil
+	}
+
+	resumenes, err := courseRatingSummaries([]uint{curso.ID})
+	if err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	SendSuccessResponse(c, gin.H{
+		"data":    valoraciones,
+		"resumen": resumenes[curso.ID],
+		"pagination": gin.H{
+			"total": total,
+			"page":  page,
+			"limit": limit,
+			"pages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+// getMyCourseReview devuelve la valoración del usuario y si puede valorar el curso
+func getMyCourseReview(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	cursoID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		SendErrorResponse(c, ErrInvalidRequest, http.StatusBadRequest)
+		return
+	}
+	inscrito, err := hasApprovedEnrollment(user.ID, uint(cursoID))
+	if err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	var v Valoracion
+	err = db.Where("usuario_id = ? AND curso_id = ?", user.ID, cursoID).First(&v).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		SendSuccessResponse(c, gin.H{"data": nil, "puede_valorar": inscrito})
+		return
+	}
+	if err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	SendSuccessResponse(c, gin.H{"data": v, "puede_valorar": false})
+}
+
+// createCourseReview registra la valoración del alumno. Requiere una compra aprobada y
+// solo se permite una por curso.
+func createCourseReview(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	var curso Curso
+	if err := db.Select("id", "titulo").First(&curso, c.Param("id")).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	var req ValoracionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	inscrito, err := hasApprovedEnrollment(user.ID, curso.ID)
+	if err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	if !inscrito {
+		SendErrorResponse(c, ErrReviewNotAllowed, http.StatusForbidden)
+		return
+	}
+
+	var existente int64
+	if err := db.Model(&Valoracion{}).Where("usuario_id = ? AND curso_id = ?", user.ID, curso.ID).
+		Count(&existente).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	if existente > 0 {
+		SendErrorResponse(c, ErrReviewExists, http.StatusConflict)
+		return
+	}
+
+	v := Valoracion{
+		UsuarioID:  user.ID,
+		CursoID:    curso.ID,
+		Puntuacion: req.Puntuacion,
+		Comentario: strings.TrimSpace(req.Comentario),
+	}
+	if err := db.Create(&v).Error; err != nil {
+		// El índice único cubre la carrera entre dos envíos simultáneos
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			SendErrorResponse(c, ErrReviewExists, http.StatusConflict)
+			return
+		}
+		log.Printf("Error al crear la valoración del curso %d: %v", curso.ID, err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "review_create", fmt.Sprintf("Valoración %d (%d estrellas) del curso %d: %s", v.ID, v.Puntuacion, curso.ID, curso.Titulo))
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": v})
+}
+
+// updateCourseReview edita la valoración propia y guarda la versión anterior en el historial
+func updateCourseReview(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	var req ValoracionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	var v Valoracion
+	if err := db.Where("usuario_id = ? AND curso_id = ?", user.ID, c.Param("id")).First(&v).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	comentario := strings.TrimSpace(req.Comentario)
+	if v.Puntuacion == req.Puntuacion && v.Comentario == comentario {
+		SendSuccessResponse(c, gin.H{"data": v})
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		anterior := ValoracionHistorial{
+			ValoracionID: v.ID,
+			Puntuacion:   v.Puntuacion,
+			Comentario:   v.Comentario,
+		}
+		if err := tx.Create(&anterior).Error; err != nil {
+			return err
+		}
+		ahora := time.Now()
+		v.Puntuacion = req.Puntuacion
+		v.Comentario = comentario
+		v.EditadaEn = &ahora
+		return tx.Model(&v).Updates(map[string]interface{}{
+			"puntuacion": v.Puntuacion,
+			"comentario": v.Comentario,
+			"editada_en": v.EditadaEn,
+		}).Error
+	})
+	if err != nil {
+		log.Printf("Error al editar la valoración %d: %v", v.ID, err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "review_update", fmt.Sprintf("Valoración %d del curso %d editada (%d estrellas)", v.ID, v.CursoID, v.Puntuacion))
+	SendSuccessResponse(c, gin.H{"data": v})
+}
+
+// listReviewsAdmin lista todas las valoraciones, incluidas las ocultas, para moderarlas
+func listReviewsAdmin(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	query := db.Model(&Valoracion{})
+	if cursoID := c.Query("curso_id"); cursoID != "" {
+		query = query.Where("curso_id = ?", cursoID)
+	}
+	if p := c.Query("puntuacion"); p != "" {
+		query = query.Where("puntuacion = ?", p)
+	}
+	for param, columna := range map[string]string{"oculta": "oculta = ?", "marcada": "marcada = ?"} {
+		valor, err := parseBoolFilter(c.Query(param))
+		if err != nil {
+			SendErrorResponse(c, err, http.StatusBadRequest)
+			return
+		}
+		if valor != nil {
+			query = query.Where(columna, *valor)
+		}
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		query = query.Where("comentario LIKE ?", "%"+q+"%")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+	var valoraciones []Valoracion
+	if err := query.Preload("Usuario", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "nombre", "email", "image_url")
+	}).Order("marcada DESC, created_at DESC, id DESC").Limit(limit).Offset((page - 1) * limit).Find(&valoraciones).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	SendSuccessResponse(c, gin.H{
+		"data": valoraciones,
+		"pagination": gin.H{
+			"total": total,
+			"page":  page,
+			"limit": limit,
+			"pages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+// getReviewAdmin devuelve una valoración con su historial de ediciones
+func getReviewAdmin(c *gin.Context) {
+	var v Valoracion
+	if err := db.Preload("Usuario", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "nombre", "email", "image_url")
+	}).Preload("Historial", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at, id")
+	}).First(&v, c.Param("id")).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+	SendSuccessResponse(c, gin.H{"data": v})
+}
+
+// moderateReview aplica una acción de moderación: hide, unhide, flag o unflag
+func moderateReview(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	var req struct {
+		Accion string `json:"accion" binding:"required"`
+		Motivo string `json:"motivo" binding:"max=255"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	var v Valoracion
+	if err := db.First(&v, c.Param("id")).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	switch req.Accion {
+	case "hide", "unhide":
+		v.Oculta = req.Accion == "hide"
+		// Ocultar o restaurar resuelve la revisión pendiente
+		v.Marcada = false
+	case "flag", "unflag":
+		v.Marcada = req.Accion == "flag"
+	default:
+		SendErrorResponse(c, ErrInvalidRequest, http.StatusBadRequest)
+		return
+	}
+	ahora := time.Now()
+	v.MotivoModeracion = strings.TrimSpace(req.Motivo)
+	v.ModeradaPorID = &user.ID
+	v.ModeradaEn = &ahora
+
+	if err := db.Model(&v).Updates(map[string]interface{}{
+		"oculta":            v.Oculta,
+		"marcada":           v.Marcada,
+		"motivo_moderacion": v.MotivoModeracion,
+		"moderada_por_id":   v.ModeradaPorID,
+		"moderada_en":       v.ModeradaEn,
+	}).Error; err != nil {
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "review_"+req.Accion, fmt.Sprintf("Valoración %d del curso %d: %s %s", v.ID, v.CursoID, req.Accion, v.MotivoModeracion))
+	SendSuccessResponse(c, gin.H{"data": v})
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionInfo describe una sesión activa tal como se muestra al usuario
+type SessionInfo struct {
+	ID              string    `json:"id"`
+	Dispositivo     string    `json:"dispositivo"`
+	Navegador       string    `json:"navegador"`
+	Sistema         string    `json:"sistema"`
+	Tipo            string    `json:"tipo"`
+	IP              string    `json:"ip"`
+	Ubicacion       string    `json:"ubicacion"`
+	IniciadaEn      time.Time `json:"iniciada_en"`
+	LastSeenAt      time.Time `json:"last_seen_at"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	Actual          bool      `json:"actual"`
+	SuplantadaPorID *uint     `json:"suplantada_por_id,omitempty"`
+}
+
+// listActiveSessions devuelve las sesiones vigentes de un usuario (una por familia)
+func listActiveSessions(userID uint, currentFamilia string) ([]SessionInfo, error) {
+	var sesiones []Sesion
+	err := db.Where("usuario_id = ? AND revoked_at IS NULL AND rotated_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at desc").
+		Find(&sesiones).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]SessionInfo, 0, len(sesiones))
+	for _, s := range sesiones {
+		ua := parseUserAgent(s.UserAgent)
+		iniciadaEn := s.IniciadaEn
+		if iniciadaEn.IsZero() {
+			iniciadaEn = s.CreatedAt
+		}
+		result = append(result, SessionInfo{
+			ID:              s.FamiliaID,
+			Dispositivo:     ua.String(),
+			Navegador:       ua.Navegador,
+			Sistema:         ua.Sistema,
+			Tipo:            ua.Tipo,
+			IP:              s.IP,
+			Ubicacion:       s.Ubicacion,
+			IniciadaEn:      iniciadaEn,
+			LastSeenAt:      s.LastSeenAt,
+			ExpiresAt:       s.ExpiresAt,
+			Actual:          s.FamiliaID == currentFamilia,
+			SuplantadaPorID: s.SuplantadaPorID,
+		})
+	}
+	return result, nil
+}
+
+// getMySessions lista las sesiones activas del usuario autenticado
+func getMySessions(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	sesiones, err := listActiveSessions(user.ID, c.GetString("session_id"))
+	if err != nil {
+		log.Printf("Error al listar sesiones del usuario %d: %v", user.ID, err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	SendSuccessResponse(c, sesiones)
+}
+
+// revokeMySession cierra una sesión concreta del usuario autenticado
+func revokeMySession(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+	familiaID := c.Param("id")
+
+	if err := revokeUserSession(user.ID, familiaID, "user_revoked"); err != nil {
+		if errors.Is(err, ErrResourceNotFound) {
+			SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+			return
+		}
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "revoke_session", fmt.Sprintf("Usuario cerró la sesión %s", familiaID))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Sesión cerrada correctamente",
+	})
+}
+
+// revokeOtherSessions cierra todas las sesiones del usuario excepto la actual
+func revokeOtherSessions(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	if err := revokeAllSessions(user.ID, "user_revoked", c.GetString("session_id")); err != nil {
+		log.Printf("Error al cerrar las demás sesiones del usuario %d: %v", user.ID, err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "revoke_other_sessions", "Usuario cerró todas las demás sesiones")
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Se cerraron las demás sesiones",
+	})
+}
+
+// getUserSessions lista las sesiones activas de un usuario (solo admin)
+func getUserSessions(c *gin.Context) {
+	id := c.Param("id")
+
+	var target Usuario
+	if err := db.First(&target, id).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	sesiones, err := listActiveSessions(target.ID, "")
+	if err != nil {
+		log.Printf("Error al listar sesiones del usuario %d: %v", target.ID, err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	currentUser, _ := c.Get("user")
+	adminUser := currentUser.(Usuario)
+	logActivity(c, adminUser.ID, "view_user_sessions",
+		fmt.Sprintf("Admin consultó las sesiones del usuario ID: %d, Email: %s", target.ID, target.Email))
+
+	SendSuccessResponse(c, sesiones)
+}
+
+// revokeUserSessions fuerza el cierre de todas las sesiones de un usuario (solo admin)
+func revokeUserSessions(c *gin.Context) {
+	id := c.Param("id")
+
+	var target Usuario
+	if err := db.First(&target, id).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+	if !checkCanManageUser(c, target) {
+		return
+	}
+
+	if err := revokeAllSessions(target.ID, "admin_revoked", ""); err != nil {
+		log.Printf("Error al cerrar sesiones del usuario %d: %v", target.ID, err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	currentUser, _ := c.Get("user")
+	adminUser := currentUser.(Usuario)
+	logActivity(c, adminUser.ID, "force_logout_user",
+		fmt.Sprintf("Admin cerró todas las sesiones del usuario ID: %d, Email: %s", target.ID, target.Email))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Todas las sesiones del usuario han sido cerradas",
+	})
+}
+
+// revokeUserSessionByID fuerza el cierre de una sesión concreta de un usuario (solo admin)
+func revokeUserSessionByID(c *gin.Context) {
+	id := c.Param("id")
+	familiaID := c.Param("sessionId")
+
+	var target Usuario
+	if err := db.First(&target, id).Error; err != nil {
+		SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+	if !checkCanManageUser(c, target) {
+		return
+	}
+
+	if err := revokeUserSession(target.ID, familiaID, "admin_revoked"); err != nil {
+		if errors.Is(err, ErrResourceNotFound) {
+			SendErrorResponse(c, ErrResourceNotFound, http.StatusNotFound)
+			return
+		}
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	currentUser, _ := c.Get("user")
+	adminUser := currentUser.(Usuario)
+	logActivity(c, adminUser.ID, "force_logout_session",
+		fmt.Sprintf("Admin cerró la sesión %s del usuario ID: %d, Email: %s", familiaID, target.ID, target.Email))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Sesión cerrada correctamente",
+	})
+}
+
+// revokeUserSession revoca una familia de sesión comprobando que pertenezca al usuario
+func revokeUserSession(userID uint, familiaID, reason string) error {
+	var count int64
+	if err := db.Model(&Sesion{}).Where("usuario_id = ? AND familia_id = ? AND revoked_at IS NULL", userID, familiaID).
+		Count(&count).Error; err != nil {
+		log.Printf("Error al buscar sesión %s: %v", familiaID, err)
+		return err
+	}
+	if count == 0 {
+		return ErrResourceNotFound
+	}
+	if err := revokeFamily(db, familiaID, reason); err != nil {
+		log.Printf("Error al revocar sesión %s: %v", familiaID, err)
+		return err
+	}
+	return nil
+}
+
+// UserAgentInfo contiene los datos de dispositivo extraídos del User-Agent
+type UserAgentInfo struct {
+	Navegador string
+	Sistema   string
+	Tipo      string // Escritorio, Móvil, Tablet o Desconocido
+}
+
+func (u UserAgentInfo) String() string {
+	if u.Navegador == "" && u.Sistema == "" {
+		return "Dispositivo desconocido"
+	}
+	return fmt.Sprintf("%s en %s", u.Navegador, u.Sistema)
+}
+
+var (
+	uaVersionRe = regexp.MustCompile(`(Edg|OPR|Firefox|Chrome|CriOS|FxiOS|Version)/(\d+)`)
+	uaAndroidRe = regexp.MustCompile(`Android (\d+)`)
+	uaIOSRe     = regexp.MustCompile(`OS (\d+)_\d+`)
+)
+
+// parseUserAgent identifica navegador, sistema operativo y tipo de dispositivo.
+// Es un análisis aproximado pensado solo para mostrar al usuario.
+func parseUserAgent(ua string) UserAgentInfo {
+	info := UserAgentInfo{Tipo: "Desconocido"}
+	if ua == "" {
+		return info
+	}
+
+	versions := map[string]string{}
+	for _, m := range uaVersionRe.FindAllStringSubmatch(ua, -1) {
+		versions[m[1]] = m[2]
+	}
+
+	switch {
+	case versions["Edg"] != "":
+		info.Navegador = "Edge " + versions["Edg"]
+	case versions["OPR"] != "":
+		info.Navegador = "Opera " + versions["OPR"]
+	case versions["Firefox"] != "":
+		info.Navegador = "Firefox " + versions["Firefox"]
+	case versions["FxiOS"] != "":
+		info.Navegador = "Firefox " + versions["FxiOS"]
+	case versions["CriOS"] != "":
+		info.Navegador = "Chrome " + versions["CriOS"]
+	case versions["Chrome"] != "":
+		info.Navegador = "Chrome " + versions["Chrome"]
+	case strings.Contains(ua, "Safari") && versions["Version"] != "":
+		info.Navegador = "Safari " + versions["Version"]
+	case strings.HasPrefix(ua, "curl/"), strings.Contains(ua, "PostmanRuntime"), strings.HasPrefix(ua, "Go-http-client"):
+		info.Navegador = strings.SplitN(ua, " ", 2)[0]
+	default:
+		info.Navegador = "Navegador desconocido"
+	}
+
+	switch {
+	case strings.Contains(ua, "iPad"):
+		info.Sistema, info.Tipo = "iPadOS", "Tablet"
+		if m := uaIOSRe.FindStringSubmatch(ua); m != nil {
+			info.Sistema += " " + m[1]
+		}
+	case strings.Contains(ua, "iPhone"):
+		info.Sistema, info.Tipo = "iOS", "Móvil"
+		if m := uaIOSRe.FindStringSubmatch(ua); m != nil {
+			info.Sistema += " " + m[1]
+		}
+	case strings.Contains(ua, "Android"):
+		info.Sistema, info.Tipo = "Android", "Móvil"
+		if !strings.Contains(ua, "Mobile") {
+			info.Tipo = "Tablet"
+		}
+		if m := uaAndroidRe.FindStringSubmatch(ua); m != nil {
+			info.Sistema += " " + m[1]
+		}
+	case strings.Contains(ua, "Windows"):
+		info.Sistema, info.Tipo = "Windows", "Escritorio"
+	case strings.Contains(ua, "Mac OS X"), strings.Contains(ua, "Macintosh"):
+		info.Sistema, info.Tipo = "macOS", "Escritorio"
+	case strings.Contains(ua, "CrOS"):
+		info.Sistema, info.Tipo = "ChromeOS", "Escritorio"
+	case strings.Contains(ua, "Linux"):
+		info.Sistema, info.Tipo = "Linux", "Escritorio"
+	default:
+		info.Sistema = "Sistema desconocido"
+	}
+
+	return info
+}
+
+// approximateLocation estima la ubicación de la petición. No se usa ninguna base de
+// datos GeoIP: se leen las cabeceras que añade el proxy/CDN (Cloudflare, nginx con
+// geoip, etc.), solo si la petición llega a través de un proxy de confianza (gin solo
+// usa la IP reenviada en ese caso), y se reconocen las direcciones de red local.
+func approximateLocation(c *gin.Context) string {
+	if c.ClientIP() != c.RemoteIP() {
+		city := c.GetHeader("X-Geo-City")
+		country := c.GetHeader("CF-IPCountry")
+		if country == "" {
+			country = c.GetHeader("X-Geo-Country")
+		}
+
+		switch {
+		case city != "" && country != "":
+			return truncate(city+", "+country, 100)
+		case country != "" && country != "XX":
+			return truncate(country, 100)
+		}
+	}
+
+	ip := net.ParseIP(c.ClientIP())
+	if ip != nil && (ip.IsLoopback() || ip.IsPrivate()) {
+		return "Red local"
+	}
+	return "Desconocida"
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		name string
+		ua   string
+		want UserAgentInfo
+	}{
+		{
+			name: "vacío",
+			ua:   "",
+			want: UserAgentInfo{Tipo: "Desconocido"},
+		},
+		{
+			name: "chrome en windows",
+			ua:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			want: UserAgentInfo{Navegador: "Chrome 124", Sistema: "Windows", Tipo: "Escritorio"},
+		},
+		{
+			name: "edge antes que chrome",
+			ua:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.2478.51",
+			want: UserAgentInfo{Navegador: "Edge 124", Sistema: "Windows", Tipo: "Escritorio"},
+		},
+		{
+			name: "firefox en linux",
+			ua:   "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+			want: UserAgentInfo{Navegador: "Firefox 125", Sistema: "Linux", Tipo: "Escritorio"},
+		},
+		{
+			name: "safari en macos",
+			ua:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_4) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15",
+			want: UserAgentInfo{Navegador: "Safari 17", Sistema: "macOS", Tipo: "Escritorio"},
+		},
+		{
+			name: "safari en iphone",
+			ua:   "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+			want: UserAgentInfo{Navegador: "Safari 17", Sistema: "iOS 17", Tipo: "Móvil"},
+		},
+		{
+			name: "chrome en ipad",
+			ua:   "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/123.0.6312.52 Mobile/15E148 Safari/604.1",
+			want: UserAgentInfo{Navegador: "Chrome 123", Sistema: "iPadOS 16", Tipo: "Tablet"},
+		},
+		{
+			name: "chrome en android móvil",
+			ua:   "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36",
+			want: UserAgentInfo{Navegador: "Chrome 124", Sistema: "Android 14", Tipo: "Móvil"},
+		},
+		{
+			name: "tablet android",
+			ua:   "Mozilla/5.0 (Linux; Android 13; SM-X200) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			want: UserAgentInfo{Navegador: "Chrome 124", Sistema: "Android 13", Tipo: "Tablet"},
+		},
+		{
+			name: "curl",
+			ua:   "curl/8.5.0",
+			want: UserAgentInfo{Navegador: "curl/8.5.0", Sistema: "Sistema desconocido", Tipo: "Desconocido"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseUserAgent(tt.ua); got != tt.want {
+				t.Errorf("parseUserAgent = %+v, quiero %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApproximateLocationTrustedProxy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		proxies string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:    "cabeceras sin proxy configurado",
+			remote:  "203.0.113.7:4000",
+			headers: map[string]string{"CF-IPCountry": "ES", "X-Geo-City": "Madrid"},
+			want:    "Desconocida",
+		},
+		{
+			name:    "cabeceras desde un proxy no confiable",
+			proxies: "10.0.0.0/8",
+			remote:  "203.0.113.7:4000",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.4", "CF-IPCountry": "ES"},
+			want:    "Desconocida",
+		},
+		{
+			name:    "proxy confiable por rango",
+			proxies: "10.0.0.0/8",
+			remote:  "10.1.2.3:4000",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.4", "CF-IPCountry": "ES", "X-Geo-City": "Madrid"},
+			want:    "Madrid, ES",
+		},
+		{
+			name:    "proxy confiable por IP",
+			proxies: "192.0.2.1, 10.0.0.0/8",
+			remote:  "192.0.2.1:4000",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.4", "X-Geo-Country": "FR"},
+			want:    "FR",
+		},
+		{
+			name:    "X-Forwarded-For no convierte en red local a un cliente externo",
+			remote:  "203.0.113.7:4000",
+			headers: map[string]string{"X-Forwarded-For": "192.168.1.10"},
+			want:    "Desconocida",
+		},
+		{
+			name:    "cliente de la red local detrás de un proxy confiable",
+			proxies: "10.0.0.0/8",
+			remote:  "10.1.2.3:4000",
+			headers: map[string]string{"X-Forwarded-For": "192.168.1.10"},
+			want:    "Red local",
+		},
+		{
+			name:   "conexión directa desde la red local",
+			remote: "192.168.1.10:4000",
+			want:   "Red local",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tt.proxies)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			c, router := gin.CreateTestContext(httptest.NewRecorder())
+			if err := router.SetTrustedProxies(trustedProxies()); err != nil {
+				t.Fatalf("SetTrustedProxies: %v", err)
+			}
+			c.Request = req
+
+			if got := approximateLocation(c); got != tt.want {
+				t.Errorf("approximateLocation = %q, quiero %q", got, tt.want)
+			}
+		})
+	}
+}
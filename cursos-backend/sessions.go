@@ -0,0 +1,345 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Sesion representa un refresh token emitido a un dispositivo.
+// Cada rotación crea una fila nueva dentro de la misma familia; solo la
+// última fila de la familia (sin RotatedAt) es válida para renovar.
+type Sesion struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	UsuarioID     uint       `gorm:"not null;index" json:"usuario_id"`
+	FamiliaID     string     `gorm:"size:36;not null;index" json:"familia_id"`
+	TokenHash     string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	IP            string     `gorm:"size:50" json:"ip"`
+	UserAgent     string     `gorm:"size:255" json:"user_agent"`
+	Ubicacion     string     `gorm:"size:100" json:"ubicacion"`
+	IniciadaEn    time.Time  `json:"iniciada_en"` // Inicio de la familia, se conserva al rotar
+	LastSeenAt    time.Time  `json:"last_seen_at"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	RotatedAt     *time.Time `json:"rotated_at"`
+	RevokedAt     *time.Time `gorm:"index" json:"revoked_at"`
+	RevokedReason string     `gorm:"size:50" json:"revoked_reason"`
+	CreatedAt     time.Time  `json:"created_at"`
+
+	// Admin que abrió la sesión para suplantar al usuario (sin refresh token)
+	SuplantadaPorID *uint `json:"suplantada_por_id,omitempty"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (Sesion) TableName() string {
+	return "sesiones"
+}
+
+// TokenPair contiene el access token (JWT) y el refresh token opaco
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// RefreshTokenRequest estructura para renovar o cerrar sesión
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// accessTokenTTL devuelve la duración de los access tokens (por defecto 15 minutos)
+func accessTokenTTL() time.Duration {
+	return parseDurationEnv("ACCESS_TOKEN_TTL", 15*time.Minute)
+}
+
+// refreshTokenTTL devuelve la duración de los refresh tokens (por defecto 30 días)
+func refreshTokenTTL() time.Duration {
+	return parseDurationEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+}
+
+func parseDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	value := getEnv(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Advertencia: valor inválido para %s (%q), usando %v", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+// generateOpaqueToken genera un token aleatorio de 32 bytes codificado en base64url
+func generateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken calcula el SHA-256 de un token para almacenarlo en la base de datos
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createSession abre una nueva familia de sesión y devuelve el par de tokens
+func createSession(c *gin.Context, user Usuario) (*TokenPair, error) {
+	return createSessionWith(c, user, nil)
+}
+
+// createSessionWith abre la sesión en una transacción que ejecuta antes el paso indicado
+// (p. ej. canjear un token de un solo uso); si ese paso falla no se abre la sesión
+func createSessionWith(c *gin.Context, user Usuario, antes func(tx *gorm.DB) error) (*TokenPair, error) {
+	var pair *TokenPair
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if antes != nil {
+			if err := antes(tx); err != nil {
+				return err
+			}
+		}
+		var err error
+		pair, err = issueSessionTokens(tx, c, user, uuid.New().String(), time.Now())
+		return err
+	})
+	return pair, err
+}
+
+// issueSessionTokens crea una fila de sesión en la familia indicada y firma el access token
+func issueSessionTokens(tx *gorm.DB, c *gin.Context, user Usuario, familiaID string, iniciadaEn time.Time) (*TokenPair, error) {
+	refresh, err := generateOpaqueToken()
+	if err != nil {
+		return nil, fmt.Errorf("error al generar refresh token: %v", err)
+	}
+
+	sesion := Sesion{
+		UsuarioID:  user.ID,
+		FamiliaID:  familiaID,
+		TokenHash:  hashToken(refresh),
+		IP:         c.ClientIP(),
+		UserAgent:  truncate(c.GetHeader("User-Agent"), 255),
+		Ubicacion:  approximateLocation(c),
+		IniciadaEn: iniciadaEn,
+		LastSeenAt: time.Now(),
+		ExpiresAt:  time.Now().Add(refreshTokenTTL()),
+	}
+	if err := tx.Create(&sesion).Error; err != nil {
+		return nil, fmt.Errorf("error al guardar sesión: %v", err)
+	}
+
+	access, err := generateToken(user.ID, user.Role, familiaID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    int(accessTokenTTL().Seconds()),
+	}, nil
+}
+
+// rotateSession canjea un refresh token por un par nuevo. Si el token ya había sido
+// rotado se considera reutilizado y se revoca toda la familia.
+func rotateSession(c *gin.Context, refreshToken string) (*TokenPair, *Usuario, error) {
+	var pair *TokenPair
+	var user Usuario
+	var reutilizada *Sesion
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var sesion Sesion
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashToken(refreshToken)).First(&sesion).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidToken
+			}
+			return err
+		}
+
+		if sesion.RevokedAt != nil || time.Now().After(sesion.ExpiresAt) {
+			return ErrSessionRevoked
+		}
+
+		if sesion.RotatedAt != nil {
+			// Reutilización de un refresh token ya canjeado: la familia se revoca fuera
+			// de la transacción para que la revocación no se deshaga con el rollback
+			reutilizada = &sesion
+			return ErrSessionRevoked
+		}
+
+		if err := tx.First(&user, sesion.UsuarioID).Error; err != nil {
+			return ErrUserNotFound
+		}
+
+		now := time.Now()
+		if err := tx.Model(&sesion).Update("rotated_at", now).Error; err != nil {
+			return err
+		}
+
+		iniciadaEn := sesion.IniciadaEn
+		if iniciadaEn.IsZero() {
+			iniciadaEn = sesion.CreatedAt
+		}
+
+		var err error
+		pair, err = issueSessionTokens(tx, c, user, sesion.FamiliaID, iniciadaEn)
+		return err
+	})
+
+	if reutilizada != nil {
+		// Posible robo del refresh token: se invalidan todas las sesiones de la familia
+		log.Printf("Reutilización de refresh token detectada. Usuario ID: %d, Familia: %s", reutilizada.UsuarioID, reutilizada.FamiliaID)
+		if err := revokeFamily(db, reutilizada.FamiliaID, "reuse_detected"); err != nil {
+			log.Printf("Error al revocar familia de sesión %s: %v", reutilizada.FamiliaID, err)
+		}
+		logActivity(c, reutilizada.UsuarioID, "refresh_token_reuse",
+			fmt.Sprintf("Reutilización de refresh token detectada; sesión %s revocada", reutilizada.FamiliaID))
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pair, &user, nil
+}
+
+// revokeFamily revoca todas las filas de una familia de sesión
+func revokeFamily(tx *gorm.DB, familiaID, reason string) error {
+	return tx.Model(&Sesion{}).
+		Where("familia_id = ? AND revoked_at IS NULL", familiaID).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason}).Error
+}
+
+// revokeAllSessions revoca todas las sesiones de un usuario, salvo la familia indicada
+func revokeAllSessions(userID uint, reason, exceptFamilia string) error {
+	query := db.Model(&Sesion{}).Where("usuario_id = ? AND revoked_at IS NULL", userID)
+	if exceptFamilia != "" {
+		query = query.Where("familia_id <> ?", exceptFamilia)
+	}
+	return query.Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason}).Error
+}
+
+// isSessionActive comprueba que la familia de sesión de un access token siga vigente
+func isSessionActive(familiaID string) bool {
+	var count int64
+	err := db.Model(&Sesion{}).
+		Where("familia_id = ? AND revoked_at IS NULL AND rotated_at IS NULL AND expires_at > ?", familiaID, time.Now()).
+		Count(&count).Error
+	if err != nil {
+		log.Printf("Error al verificar sesión %s: %v", familiaID, err)
+		return false
+	}
+	return count > 0
+}
+
+// touchSession actualiza la última actividad de la sesión como mucho una vez por minuto
+func touchSession(familiaID string) {
+	now := time.Now()
+	err := db.Model(&Sesion{}).
+		Where("familia_id = ? AND rotated_at IS NULL AND revoked_at IS NULL AND last_seen_at < ?", familiaID, now.Add(-time.Minute)).
+		Update("last_seen_at", now).Error
+	if err != nil {
+		log.Printf("Error al actualizar actividad de sesión %s: %v", familiaID, err)
+	}
+}
+
+// refreshToken canjea un refresh token por un nuevo par de tokens (rotación)
+func refreshToken(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+		SendErrorResponse(c, errors.New("refresh_token requerido"), http.StatusBadRequest)
+		return
+	}
+
+	pair, user, err := rotateSession(c, req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrSessionRevoked) || errors.Is(err, ErrUserNotFound) {
+			SendErrorResponse(c, ErrInvalidToken, http.StatusUnauthorized)
+			return
+		}
+		log.Printf("Error al renovar sesión: %v", err)
+		SendErrorResponse(c, errors.New("error al renovar el token"), http.StatusInternalServerError)
+		return
+	}
+
+	user.Password = ""
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"token":         pair.AccessToken,
+		"refresh_token": pair.RefreshToken,
+		"expires_in":    pair.ExpiresIn,
+		"user":          user,
+	})
+}
+
+// logout revoca la sesión asociada al refresh token o, en su defecto, al access token
+func logout(c *gin.Context) {
+	var req RefreshTokenRequest
+	_ = c.ShouldBindJSON(&req)
+
+	familiaID := ""
+	var userID uint
+
+	if req.RefreshToken != "" {
+		var sesion Sesion
+		if err := db.Where("token_hash = ?", hashToken(req.RefreshToken)).First(&sesion).Error; err == nil {
+			familiaID = sesion.FamiliaID
+			userID = sesion.UsuarioID
+		}
+	} else if tokenString := bearerToken(c); tokenString != "" {
+		// Se acepta un access token expirado: solo necesitamos identificar la sesión
+		if claims, err := parseAccessToken(tokenString, true); err == nil {
+			familiaID = claims.SessionID
+			userID = claims.UserID
+		}
+	}
+
+	if familiaID == "" {
+		SendErrorResponse(c, ErrInvalidToken, http.StatusUnauthorized)
+		return
+	}
+
+	if err := revokeFamily(db, familiaID, "logout"); err != nil {
+		log.Printf("Error al cerrar sesión %s: %v", familiaID, err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, userID, "logout", "Cierre de sesión")
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Sesión cerrada correctamente",
+	})
+}
+
+// bearerToken extrae el token del encabezado Authorization, si tiene formato Bearer
+func bearerToken(c *gin.Context) string {
+	parts := strings.Split(c.GetHeader("Authorization"), " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return ""
+	}
+	return parts[1]
+}
+
+// truncate recorta una cadena a un máximo de n bytes
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
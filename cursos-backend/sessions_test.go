@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRotateSessionDetectsReuse(t *testing.T) {
+	useTestDB(t)
+	user := createTestUser(t, RolUser)
+	c := testContext()
+
+	inicial, err := createSession(c, user)
+	if err != nil {
+		t.Fatalf("createSession: %v", err)
+	}
+	claims, err := parseAccessToken(inicial.AccessToken, false)
+	if err != nil {
+		t.Fatalf("parseAccessToken: %v", err)
+	}
+	familia := claims.SessionID
+	if !isSessionActive(familia) {
+		t.Fatal("la sesión recién creada no está activa")
+	}
+
+	rotado, _, err := rotateSession(testContext(), inicial.RefreshToken)
+	if err != nil {
+		t.Fatalf("rotateSession: %v", err)
+	}
+	if rotado.RefreshToken == inicial.RefreshToken {
+		t.Fatal("la rotación debe emitir un refresh token nuevo")
+	}
+	if !isSessionActive(familia) {
+		t.Fatal("la familia debe seguir activa tras una rotación normal")
+	}
+
+	// Reutilizar el token ya canjeado revoca toda la familia
+	if _, _, err := rotateSession(testContext(), inicial.RefreshToken); !errors.Is(err, ErrSessionRevoked) {
+		t.Fatalf("reutilización: err = %v, quiero ErrSessionRevoked", err)
+	}
+	if isSessionActive(familia) {
+		t.Error("la familia sigue activa tras detectar la reutilización")
+	}
+	if _, _, err := rotateSession(testContext(), rotado.RefreshToken); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("el último refresh token de la familia sigue sirviendo: err = %v", err)
+	}
+
+	var sesiones []Sesion
+	if err := db.Where("familia_id = ?", familia).Find(&sesiones).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(sesiones) != 2 {
+		t.Fatalf("%d filas en la familia, quiero 2", len(sesiones))
+	}
+	for _, s := range sesiones {
+		if s.RevokedAt == nil || s.RevokedReason != "reuse_detected" {
+			t.Errorf("sesión %d: revoked_at = %v, motivo %q; quiero revocada por reuse_detected", s.ID, s.RevokedAt, s.RevokedReason)
+		}
+	}
+}
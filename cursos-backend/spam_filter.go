@@ -0,0 +1,307 @@
+package main
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Motivos por los que un mensaje de contacto se marca como spam
+const (
+	MotivoTokenAusente     = "token_ausente"
+	MotivoTokenInvalido    = "token_invalido"
+	MotivoTokenReutilizado = "token_reutilizado"
+	MotivoEnvioRapido      = "envio_rapido"
+	MotivoEnlaces          = "enlaces"
+	MotivoTerminoBloqueado = "termino_bloqueado"
+	MotivoBayes            = "bayes"
+)
+
+// spamTotalToken es la fila que cuenta los mensajes entrenados (no es una palabra real)
+const spamTotalToken = "#mensajes"
+
+// FiltroSpamToken cuenta cuántas veces aparece una palabra en mensajes marcados como spam
+// y en mensajes legítimos. Es el modelo del filtro bayesiano.
+type FiltroSpamToken struct {
+	Token string `gorm:"primaryKey;size:64"`
+	Spam  int64  `gorm:"not null;default:0"`
+	Ham   int64  `gorm:"not null;default:0"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (FiltroSpamToken) TableName() string {
+	return "filtro_spam_tokens"
+}
+
+var (
+	spamLinkPattern  = regexp.MustCompile(`(?i)(https?://|www\.|\[url)`)
+	defaultBlocklist = []string{
+		"viagra", "cialis", "casino", "porn", "escort", "backlinks", "guest post",
+		"crypto investment", "forex signals", "préstamo rápido", "gana dinero rápido",
+	}
+)
+
+// newContactFormToken firma el momento en que se cargó el formulario. Al enviarlo se
+// comprueba que no haya pasado demasiado poco tiempo (un bot lo rellena al instante).
+func newContactFormToken() string {
+	emitido := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	nonce := randomHex(8)
+	return emitido + "." + nonce + "." + linkSignature("contact_form", emitido, nonce)
+}
+
+// checkContactFormToken devuelve el motivo de sospecha del token, o "" si es válido.
+// Cada token solo sirve para un envío.
+func checkContactFormToken(token string) string {
+	if token == "" {
+		return MotivoTokenAusente
+	}
+	partes := strings.Split(token, ".")
+	if len(partes) != 3 || !hmac.Equal([]byte(linkSignature("contact_form", partes[0], partes[1])), []byte(partes[2])) {
+		return MotivoTokenInvalido
+	}
+	ms, err := strconv.ParseInt(partes[0], 10, 64)
+	if err != nil {
+		return MotivoTokenInvalido
+	}
+
+	edad := time.Since(time.UnixMilli(ms))
+	validez := parseDurationEnv("CONTACT_FORM_TOKEN_TTL", 2*time.Hour)
+	if edad > validez {
+		return MotivoTokenInvalido
+	}
+	if edad < parseDurationEnv("CONTACT_MIN_SUBMIT_TIME", 3*time.Second) {
+		return MotivoEnvioRapido
+	}
+
+	// El limitador hace de registro de tokens usados: un solo uso durante su validez
+	permitido, _, err := rateLimiter.Allow("contact_token:"+partes[1], 1, validez)
+	if err != nil {
+		log.Printf("Error al comprobar el token del formulario de contacto: %v", err)
+		return ""
+	}
+	if !permitido {
+		return MotivoTokenReutilizado
+	}
+	return ""
+}
+
+func contactBlocklist() []string {
+	valor := getEnv("CONTACT_BLOCKLIST", "")
+	if valor == "" {
+		return defaultBlocklist
+	}
+	var terminos []string
+	for _, t := range strings.Split(valor, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			terminos = append(terminos, t)
+		}
+	}
+	return terminos
+}
+
+// spamTokens divide el mensaje en palabras únicas para el filtro bayesiano. El dominio
+// del remitente se incluye como token propio.
+func spamTokens(nombre, email, texto string) []string {
+	vistos := make(map[string]bool)
+	var tokens []string
+	add := func(t string) {
+		if !vistos[t] {
+			vistos[t] = true
+			tokens = append(tokens, t)
+		}
+	}
+
+	palabras := strings.FieldsFunc(strings.ToLower(nombre+" "+texto), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, p := range palabras {
+		if n := len([]rune(p)); n >= 3 && n <= 30 {
+			add(p)
+		}
+	}
+	if _, dominio, ok := strings.Cut(strings.ToLower(email), "@"); ok {
+		add(truncate("dominio:"+dominio, 64))
+	}
+	return tokens
+}
+
+// spamProbability combina las probabilidades de las palabras más significativas del
+// mensaje (método de Robinson). Devuelve false si el filtro aún no tiene entrenamiento
+// suficiente para opinar.
+func spamProbability(tokens []string) (float64, bool) {
+	var filas []FiltroSpamToken
+	if err := db.Where("token IN ?", append(tokens, spamTotalToken)).Find(&filas).Error; err != nil {
+		log.Printf("Error al consultar el filtro de spam: %v", err)
+		return 0, false
+	}
+
+	var total FiltroSpamToken
+	conteos := make(map[string]FiltroSpamToken, len(filas))
+	for _, f := range filas {
+		if f.Token == spamTotalToken {
+			total = f
+		} else {
+			conteos[f.Token] = f
+		}
+	}
+	minimo := int64(parseIntEnv("CONTACT_BAYES_MIN_TRAINING", 10))
+	if total.Spam < minimo || total.Ham < minimo {
+		return 0, false
+	}
+
+	const fuerza, previa = 1.0, 0.5
+	var probs []float64
+	for _, t := range tokens {
+		f, ok := conteos[t]
+		if !ok || f.Spam+f.Ham == 0 {
+			continue
+		}
+		spam := float64(f.Spam) / float64(total.Spam)
+		ham := float64(f.Ham) / float64(total.Ham)
+		p := spam / (spam + ham)
+		n := float64(f.Spam + f.Ham)
+		probs = append(probs, (fuerza*previa+n*p)/(fuerza+n))
+	}
+	if len(probs) == 0 {
+		return previa, true
+	}
+
+	// Solo cuentan las 15 palabras más alejadas de la neutralidad
+	sort.Slice(probs, func(i, j int) bool {
+		return math.Abs(probs[i]-0.5) > math.Abs(probs[j]-0.5)
+	})
+	if len(probs) > 15 {
+		probs = probs[:15]
+	}
+	var logSpam, logHam float64
+	for _, p := range probs {
+		p = math.Min(math.Max(p, 0.01), 0.99)
+		logSpam += math.Log(p)
+		logHam += math.Log(1 - p)
+	}
+	return 1 / (1 + math.Exp(logHam-logSpam)), true
+}
+
+// scoreContactMessage analiza el contenido del mensaje y devuelve la probabilidad
+// bayesiana y los motivos de sospecha
+func scoreContactMessage(req ContactRequest) (float64, []string) {
+	var motivos []string
+	texto := strings.ToLower(req.Name + " " + req.Message)
+
+	if len(spamLinkPattern.FindAllStringIndex(texto, -1)) > parseIntEnv("CONTACT_MAX_LINKS", 2) {
+		motivos = append(motivos, MotivoEnlaces)
+	}
+	for _, termino := range contactBlocklist() {
+		if strings.Contains(texto, termino) {
+			motivos = append(motivos, MotivoTerminoBloqueado)
+			break
+		}
+	}
+
+	umbral, err := strconv.ParseFloat(getEnv("CONTACT_SPAM_THRESHOLD", "0.9"), 64)
+	if err != nil {
+		umbral = 0.9
+	}
+	probabilidad, ok := spamProbability(spamTokens(req.Name, req.Email, req.Message))
+	if ok && probabilidad >= umbral {
+		motivos = append(motivos, MotivoBayes)
+	}
+	return probabilidad, motivos
+}
+
+// trainSpamFilter suma (delta 1) o resta (delta -1) las palabras del mensaje en la
+// columna de spam o de mensajes legítimos
+func trainSpamFilter(tx *gorm.DB, tokens []string, spam bool, delta int) error {
+	columna := "ham"
+	if spam {
+		columna = "spam"
+	}
+	for _, t := range append(tokens, spamTotalToken) {
+		fila := FiltroSpamToken{Token: t}
+		if delta > 0 {
+			if spam {
+				fila.Spam = 1
+			} else {
+				fila.Ham = 1
+			}
+		}
+		err := tx.Clauses(clause.OnConflict{
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				columna: gorm.Expr("GREATEST("+columna+" + ?, 0)", delta),
+			}),
+		}).Create(&fila).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trainContactMessage entrena el filtro con la decisión del administrador. Si el mensaje
+// ya se había entrenado en el otro sentido, primero se deshace.
+func trainContactMessage(m *ContactMessage, spam bool) error {
+	clase := "ham"
+	if spam {
+		clase = "spam"
+	}
+	if m.EntrenadoComo == clase {
+		return nil
+	}
+
+	tokens := spamTokens(m.Name, m.Email, m.Message)
+	return db.Transaction(func(tx *gorm.DB) error {
+		if m.EntrenadoComo != "" {
+			if err := trainSpamFilter(tx, tokens, m.EntrenadoComo == "spam", -1); err != nil {
+				return err
+			}
+		}
+		if err := trainSpamFilter(tx, tokens, spam, 1); err != nil {
+			return err
+		}
+		m.EntrenadoComo = clase
+		return tx.Model(m).Update("entrenado_como", clase).Error
+	})
+}
+
+// markMessageSpam marca o desmarca la conversación como spam y entrena el filtro
+func markMessageSpam(m *ContactMessage, spam bool) error {
+	if err := trainContactMessage(m, spam); err != nil {
+		return fmt.Errorf("error al entrenar el filtro de spam: %v", err)
+	}
+	m.Spam = spam
+	return db.Model(m).Update("spam", spam).Error
+}
+
+// getContactFormToken entrega el token que el formulario de contacto debe enviar
+func getContactFormToken(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	SendSuccessResponse(c, gin.H{"form_token": newContactFormToken()})
+}
+
+// isContactSpam aplica al mensaje las defensas del formulario: el honeypot (bot seguro),
+// el token firmado y la puntuación del contenido. Devuelve motivos solo si el mensaje
+// debe marcarse como spam.
+func isContactSpam(req ContactRequest) (bot bool, puntuacion float64, motivos []string) {
+	if req.Website != "" {
+		return true, 1, nil
+	}
+	if motivo := checkContactFormToken(req.FormToken); motivo != "" {
+		motivos = append(motivos, motivo)
+	}
+	puntuacion, contenido := scoreContactMessage(req)
+	motivos = append(motivos, contenido...)
+
+	// Sin token también es spam: omitirlo no puede ser la forma de saltarse el control
+	return false, puntuacion, motivos
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// useTestDB conecta la variable global db a la base de datos de TEST_DATABASE_DSN y
+// ejecuta las migraciones. Sin esa variable el test se omite: estas pruebas necesitan
+// MySQL y nunca deben apuntar a la base de datos de desarrollo por accidente.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN no configurado; se omite la prueba con base de datos")
+	}
+
+	conexion, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("no se pudo conectar a la base de datos de pruebas: %v", err)
+	}
+
+	anterior := db
+	db = conexion
+	t.Cleanup(func() {
+		if sqlDB, err := conexion.DB(); err == nil {
+			sqlDB.Close()
+		}
+		db = anterior
+	})
+
+	if err := migrateWithConstraints(); err != nil {
+		t.Fatalf("migración: %v", err)
+	}
+	if err := initJWTKeys(); err != nil {
+		t.Fatalf("initJWTKeys: %v", err)
+	}
+}
+
+// createTestUser crea un usuario con un email único y lo elimina al terminar el test
+func createTestUser(t *testing.T, role string) Usuario {
+	t.Helper()
+	user := Usuario{
+		Nombre:          "Usuario de prueba",
+		Email:           fmt.Sprintf("test-%d@example.com", time.Now().UnixNano()),
+		Password:        "no-es-un-hash", // bcrypt no lo acepta: no se puede iniciar sesión con contraseña
+		Role:            role,
+		EmailVerificado: true,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("no se pudo crear el usuario de prueba: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Where("usuario_id = ?", user.ID).Delete(&Sesion{})
+		db.Where("user_id = ?", user.ID).Delete(&ActivityLog{})
+		db.Delete(&Usuario{}, user.ID)
+	})
+	return user
+}
+
+// testContext devuelve un contexto de gin con una petición mínima
+func testContext() *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	c.Request.Header.Set("User-Agent", "Go-http-client/1.1")
+	return c
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Parámetros TOTP (RFC 6238) compatibles con Google Authenticator, Authy, etc.
+const (
+	totpPeriod        = 30
+	totpDigits        = 6
+	totpSkew          = 1 // Pasos de tolerancia a cada lado por desfase de reloj
+	recoveryCodeQty   = 10
+	pendingTokenTTL   = 5 * time.Minute
+	purpose2FAPending = "2fa_pending"
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// CodigoRecuperacion es un código de un solo uso para entrar sin el autenticador
+type CodigoRecuperacion struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UsuarioID  uint       `gorm:"not null;index" json:"usuario_id"`
+	CodigoHash string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	UsadoEn    *time.Time `json:"usado_en"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// TableName sobrescribe el nombre de la tabla predeterminado
+func (CodigoRecuperacion) TableName() string {
+	return "codigos_recuperacion"
+}
+
+// Estructuras para solicitudes de 2FA
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type TwoFactorDisableRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"` // Código TOTP o de recuperación
+}
+
+type TwoFactorLoginRequest struct {
+	PendingToken string `json:"pending_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// generateTOTPSecret genera un secreto aleatorio de 160 bits en base32
+func generateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(b), nil
+}
+
+// totpCode calcula el código HOTP (RFC 4226) para un contador dado
+func totpCode(secret []byte, counter int64) string {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(buf[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := (uint32(sum[offset])&0x7f)<<24 |
+		uint32(sum[offset+1])<<16 |
+		uint32(sum[offset+2])<<8 |
+		uint32(sum[offset+3])
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// verifyTOTP comprueba un código contra el secreto. Devuelve el paso de tiempo aceptado
+// para que el llamador lo guarde y no se pueda reutilizar el mismo código.
+func verifyTOTP(secretB32, code string, lastStep int64) (int64, bool) {
+	secret, err := base32NoPadding.DecodeString(strings.ToUpper(secretB32))
+	if err != nil || len(secret) == 0 {
+		return 0, false
+	}
+
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := time.Now().Unix() / totpPeriod
+	for d := int64(-totpSkew); d <= totpSkew; d++ {
+		step := current + d
+		if step <= lastStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// otpauthURL construye la URI que las apps autenticadoras leen desde el código QR
+func otpauthURL(secret, email string) string {
+	issuer := getEnv("TOTP_ISSUER", "Plataforma de Cursos")
+	label := url.PathEscape(issuer + ":" + email)
+
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// normalizeRecoveryCode elimina guiones/espacios y pasa a minúsculas
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// generateRecoveryCodes reemplaza los códigos de recuperación del usuario y devuelve
+// los nuevos en claro (solo se muestran una vez)
+func generateRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("usuario_id = ?", userID).Delete(&CodigoRecuperacion{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeQty)
+	for i := 0; i < recoveryCodeQty; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(base32NoPadding.EncodeToString(b))[:10]
+		code := raw[:5] + "-" + raw[5:]
+
+		if err := tx.Create(&CodigoRecuperacion{
+			UsuarioID:  userID,
+			CodigoHash: hashToken(normalizeRecoveryCode(code)),
+		}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// useRecoveryCode marca como usado un código de recuperación válido
+func useRecoveryCode(userID uint, code string) bool {
+	result := db.Model(&CodigoRecuperacion{}).
+		Where("usuario_id = ? AND codigo_hash = ? AND usado_en IS NULL", userID, hashToken(normalizeRecoveryCode(code))).
+		Update("usado_en", time.Now())
+	if result.Error != nil {
+		log.Printf("Error al usar código de recuperación: %v", result.Error)
+		return false
+	}
+	return result.RowsAffected == 1
+}
+
+// checkTOTP valida un código TOTP del usuario y registra el paso para evitar repeticiones
+func checkTOTP(user *Usuario, code string) bool {
+	step, ok := verifyTOTP(user.TOTPSecret, code, user.TOTPLastStep)
+	if !ok {
+		return false
+	}
+	// Solo se acepta si nadie más ha consumido ya este paso (peticiones concurrentes)
+	result := db.Model(&Usuario{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+	user.TOTPLastStep = step
+	return true
+}
+
+// generatePendingToken emite el token temporal que da paso a la verificación del segundo
+// factor. Lleva un jti para que solo se pueda canjear una vez.
+func generatePendingToken(user Usuario, metodo string) (string, error) {
+	claims := &Claims{
+		UserID:  user.ID,
+		Role:    user.Role,
+		Purpose: purpose2FAPending,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        randomHex(16),
+			Subject:   metodo,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(pendingTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	return signClaims(claims)
+}
+
+// get2FAStatus informa del estado del segundo factor del usuario autenticado
+func get2FAStatus(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	var restantes int64
+	if user.TwoFactorEnabled {
+		db.Model(&CodigoRecuperacion{}).Where("usuario_id = ? AND usado_en IS NULL", user.ID).Count(&restantes)
+	}
+
+	SendSuccessResponse(c, gin.H{
+		"enabled":                  user.TwoFactorEnabled,
+		"required_by_role":         roleRequires2FA(user.Role),
+		"recovery_codes_remaining": restantes,
+	})
+}
+
+// setup2FA genera un nuevo secreto TOTP pendiente de confirmación
+func setup2FA(c *gin.Context) {
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	if user.TwoFactorEnabled {
+		SendErrorResponse(c, errors.New("la autenticación en dos pasos ya está activada"), http.StatusBadRequest)
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		log.Printf("Error al generar secreto TOTP: %v", err)
+		SendErrorResponse(c, ErrServerError, http.StatusInternalServerError)
+		return
+	}
+
+	// El secreto se guarda pero 2FA no se activa hasta verificar el primer código
+	if err := db.Model(&user).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+		log.Printf("Error al guardar secreto TOTP: %v", err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	SendSuccessResponse(c, gin.H{
+		"secret":      secret,
+		"otpauth_url": otpauthURL(secret, user.Email),
+	})
+}
+
+// enable2FA verifica el primer código y activa el segundo factor
+func enable2FA(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	if user.TwoFactorEnabled {
+		SendErrorResponse(c, errors.New("la autenticación en dos pasos ya está activada"), http.StatusBadRequest)
+		return
+	}
+	if user.TOTPSecret == "" {
+		SendErrorResponse(c, errors.New("primero debes iniciar la configuración de 2FA"), http.StatusBadRequest)
+		return
+	}
+
+	if !checkTOTP(&user, req.Code) {
+		SendErrorResponse(c, ErrInvalid2FACode, http.StatusBadRequest)
+		return
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("two_factor_enabled", true).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = generateRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		log.Printf("Error al activar 2FA para usuario %d: %v", user.ID, err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "enable_2fa", "Autenticación en dos pasos activada")
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":        true,
+		"message":        "Autenticación en dos pasos activada. Guarda los códigos de recuperación en un lugar seguro.",
+		"recovery_codes": codes,
+	})
+}
+
+// disable2FA desactiva el segundo factor tras confirmar contraseña y código
+func disable2FA(c *gin.Context) {
+	var req TwoFactorDisableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	if !user.TwoFactorEnabled {
+		SendErrorResponse(c, errors.New("la autenticación en dos pasos no está activada"), http.StatusBadRequest)
+		return
+	}
+
+	if roleRequires2FA(user.Role) {
+		SendErrorResponse(c, errors.New("tu rol exige autenticación en dos pasos; no se puede desactivar"), http.StatusForbidden)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		SendErrorResponse(c, errors.New("contraseña incorrecta"), http.StatusBadRequest)
+		return
+	}
+
+	if !checkTOTP(&user, req.Code) && !useRecoveryCode(user.ID, req.Code) {
+		SendErrorResponse(c, ErrInvalid2FACode, http.StatusBadRequest)
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"two_factor_enabled": false,
+			"totp_secret":        "",
+			"totp_last_step":     0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("usuario_id = ?", user.ID).Delete(&CodigoRecuperacion{}).Error
+	})
+	if err != nil {
+		log.Printf("Error al desactivar 2FA para usuario %d: %v", user.ID, err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "disable_2fa", "Autenticación en dos pasos desactivada")
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Autenticación en dos pasos desactivada",
+	})
+}
+
+// regenerateRecoveryCodes invalida los códigos anteriores y genera otros nuevos
+func regenerateRecoveryCodes(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	userValue, _ := c.Get("user")
+	user := userValue.(Usuario)
+
+	if !user.TwoFactorEnabled {
+		SendErrorResponse(c, errors.New("la autenticación en dos pasos no está activada"), http.StatusBadRequest)
+		return
+	}
+
+	if !checkTOTP(&user, req.Code) {
+		SendErrorResponse(c, ErrInvalid2FACode, http.StatusBadRequest)
+		return
+	}
+
+	codes, err := generateRecoveryCodes(db, user.ID)
+	if err != nil {
+		log.Printf("Error al regenerar códigos de recuperación para usuario %d: %v", user.ID, err)
+		SendErrorResponse(c, ErrDatabaseError, http.StatusInternalServerError)
+		return
+	}
+
+	logActivity(c, user.ID, "regenerate_recovery_codes", "Códigos de recuperación regenerados")
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":        true,
+		"message":        "Códigos de recuperación regenerados",
+		"recovery_codes": codes,
+	})
+}
+
+// verify2FALogin completa el segundo paso del login con un código TOTP o de recuperación
+func verify2FALogin(c *gin.Context) {
+	var req TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		SendErrorResponse(c, err, http.StatusBadRequest)
+		return
+	}
+
+	if req.Code == "" && req.RecoveryCode == "" {
+		SendErrorResponse(c, errors.New("se requiere un código de verificación o de recuperación"), http.StatusBadRequest)
+		return
+	}
+
+	claims := &Claims{}
+	if err := parseClaims(req.PendingToken, claims, false); err != nil || claims.Purpose != purpose2FAPending || claims.ID == "" {
+		SendErrorResponse(c, ErrInvalidToken, http.StatusUnauthorized)
+		return
+	}
+
+	var user Usuario
+	if err := db.First(&user, claims.UserID).Error; err != nil {
+		SendErrorResponse(c, ErrInvalidToken, http.StatusUnauthorized)
+		return
+	}
+
+	if !user.TwoFactorEnabled {
+		SendErrorResponse(c, ErrInvalidToken, http.StatusUnauthorized)
+		return
+	}
+
+	if restante := accountLockedFor(user); restante > 0 {
+		sendAccountLockedResponse(c, restante)
+		return
+	}
+
+	metodo := claims.Subject + "+totp"
+	if req.Code != "" {
+		if !checkTOTP(&user, req.Code) {
+			logActivity(c, user.ID, "2fa_failed", "Código de verificación incorrecto en el inicio de sesión")
+			time.Sleep(loginDelay(registerFailedLogin(c, user)))
+			SendErrorResponse(c, ErrInvalid2FACode, http.StatusUnauthorized)
+			return
+		}
+	} else {
+		if !useRecoveryCode(user.ID, req.RecoveryCode) {
+			logActivity(c, user.ID, "2fa_failed", "Código de recuperación incorrecto en el inicio de sesión")
+			time.Sleep(loginDelay(registerFailedLogin(c, user)))
+			SendErrorResponse(c, ErrInvalid2FACode, http.StatusUnauthorized)
+			return
+		}
+		metodo = claims.Subject + "+recovery_code"
+		logActivity(c, user.ID, "recovery_code_used", "Inicio de sesión con código de recuperación")
+	}
+
+	// El token pendiente se canjea en la misma transacción que abre la sesión
+	finishLoginConsuming(c, user, metodo, func(tx *gorm.DB) error {
+		return consumeToken(tx, "2fa_pending:"+claims.ID, time.Now().Add(pendingTokenTTL))
+	})
+}
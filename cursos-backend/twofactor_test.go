@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Vectores SHA-1 del apéndice B de RFC 6238 (secreto ASCII "12345678901234567890").
+// El RFC da códigos de 8 dígitos; los de 6 son sus últimas seis cifras.
+func TestTOTPCodeRFC6238(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(secret, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("T=%d: totpCode = %s, quiero %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	secretB32 := base32NoPadding.EncodeToString(secret)
+	step := time.Now().Unix() / totpPeriod
+	code := totpCode(secret, step)
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		lastStep int64
+		wantOK   bool
+	}{
+		{"código actual", secretB32, code, 0, true},
+		{"con espacios", secretB32, code[:3] + " " + code[3:], 0, true},
+		{"secreto en minúsculas", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code, 0, true},
+		{"paso anterior dentro del margen", secretB32, totpCode(secret, step-1), 0, true},
+		{"fuera del margen", secretB32, totpCode(secret, step-3), 0, false},
+		{"paso ya usado", secretB32, code, step, false},
+		{"longitud incorrecta", secretB32, code[:5], 0, false},
+		{"secreto inválido", "no-es-base32!", code, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := verifyTOTP(tt.secret, tt.code, tt.lastStep)
+			if ok != tt.wantOK {
+				t.Fatalf("verifyTOTP ok = %v, quiero %v", ok, tt.wantOK)
+			}
+			if ok && got < step-totpSkew {
+				t.Errorf("paso aceptado %d fuera del margen de %d", got, step)
+			}
+		})
+	}
+}
@@ -70,7 +70,9 @@ class AuthService {
 
   // Método para refrescar el token
   refreshToken() {
-    return this.api.post('/auth/refresh-token');
+    return this.api.post('/auth/refresh-token', {
+      refresh_token: localStorage.getItem('refreshToken')
+    });
   }
 }
 
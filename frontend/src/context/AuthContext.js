@@ -101,8 +101,11 @@ export const AuthProvider = ({ children }) => {
   }, [apiUrl]);
 
   // Función para iniciar sesión
-  const login = async (token, userData) => {
+  const login = async (token, userData, refreshTokenValue) => {
     localStorage.setItem('token', token);
+    if (refreshTokenValue) {
+      localStorage.setItem('refreshToken', refreshTokenValue);
+    }
     setIsLoggedIn(true);
     setAuthError(null);
     
@@ -129,7 +132,17 @@ export const AuthProvider = ({ children }) => {
 
   // Función para cerrar sesión
   const logout = () => {
+    const storedRefreshToken = localStorage.getItem('refreshToken');
+    if (storedRefreshToken) {
+      // Revocar la sesión en el servidor (sin bloquear el cierre local)
+      fetch(`${apiUrl}/api/auth/logout`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refresh_token: storedRefreshToken })
+      }).catch(err => console.error('Error al cerrar sesión en el servidor:', err));
+    }
     localStorage.removeItem('token');
+    localStorage.removeItem('refreshToken');
     setIsLoggedIn(false);
     setUser(null);
     setAuthError(null);
@@ -214,18 +227,18 @@ export const AuthProvider = ({ children }) => {
     }));
   };
 
-  // Nueva función para refrescar el token
+  // Nueva función para refrescar el token (rota el refresh token en cada uso)
   const refreshToken = async () => {
-    const token = localStorage.getItem('token');
-    if (!token) return false;
+    const storedRefreshToken = localStorage.getItem('refreshToken');
+    if (!storedRefreshToken) return false;
     
     try {
       const response = await fetch(`${apiUrl}/api/auth/refresh-token`, {
         method: 'POST',
         headers: {
-          'Authorization': `Bearer ${token}`,
           'Content-Type': 'application/json'
-        }
+        },
+        body: JSON.stringify({ refresh_token: storedRefreshToken })
       });
       
       if (!response.ok) {
@@ -238,6 +251,7 @@ export const AuthProvider = ({ children }) => {
       const data = await response.json();
       if (data.success && data.token) {
         localStorage.setItem('token', data.token);
+        localStorage.setItem('refreshToken', data.refresh_token);
         return true;
       }
       
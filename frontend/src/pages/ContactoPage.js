@@ -1,4 +1,4 @@
-import React, { useState } from 'react';
+import React, { useState, useEffect, useCallback } from 'react';
 import { motion } from 'framer-motion';
 import { 
   Mail, 
@@ -19,14 +19,31 @@ const ContactPage = () => {
     name: '',
     email: '',
     phone: '',
-    message: ''
+    message: '',
+    website: ''
   });
+  const [formToken, setFormToken] = useState('');
   const [isSubmitting, setIsSubmitting] = useState(false);
   const [submitStatus, setSubmitStatus] = useState({
     success: false,
     error: null
   });
 
+  // Token firmado que el servidor exige para distinguir el formulario real de un bot;
+  // es de un solo uso, así que se pide otro después de cada envío
+  const fetchFormToken = useCallback(async () => {
+    try {
+      const response = await axios.get('http://localhost:5000/api/contact/token');
+      setFormToken(response.data.form_token || '');
+    } catch (error) {
+      console.error('Error al obtener el token del formulario:', error);
+    }
+  }, []);
+
+  useEffect(() => {
+    fetchFormToken();
+  }, [fetchFormToken]);
+
   const handleChange = (e) => {
     const { name, value } = e.target;
     setFormData(prev => ({
@@ -41,7 +58,10 @@ const ContactPage = () => {
     setSubmitStatus({ success: false, error: null });
 
     try {
-      const response = await axios.post('http://localhost:5000/api/contact', formData);
+      const response = await axios.post('http://localhost:5000/api/contact', {
+        ...formData,
+        form_token: formToken
+      });
       
       if (response.data.success) {
         setSubmitStatus({ success: true, error: null });
@@ -49,7 +69,8 @@ const ContactPage = () => {
           name: '',
           email: '',
           phone: '',
-          message: ''
+          message: '',
+          website: ''
         });
       }
     } catch (error) {
@@ -58,6 +79,7 @@ const ContactPage = () => {
       console.error('Error:', error);
     } finally {
       setIsSubmitting(false);
+      fetchFormToken();
     }
   };
 
@@ -183,6 +205,20 @@ const ContactPage = () => {
             />
           </div>
 
+          {/* Campo trampa: invisible para las personas, los bots lo rellenan */}
+          <div className="form-honeypot" aria-hidden="true">
+            <label htmlFor="website">Sitio web</label>
+            <input
+              type="text"
+              id="website"
+              name="website"
+              tabIndex={-1}
+              autoComplete="off"
+              value={formData.website}
+              onChange={handleChange}
+            />
+          </div>
+
           <button
             type="submit"
             className="submit-btn"
@@ -54,7 +54,7 @@ function LoginPage() {
         throw new Error(data.error || data.message || 'Error al iniciar sesión');
       }
       
-      login(data.token);
+      login(data.token, null, data.refresh_token);
       navigate('/', { replace: true });
     } catch (err) {
       setError(err.message || 'Error de red al intentar conectar con el servidor');